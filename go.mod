@@ -2,10 +2,11 @@ module github.com/NickSavage/gopherboy
 
 go 1.22.5
 
+require github.com/veandco/go-sdl2 v0.4.40
+
 require (
 	github.com/ebitengine/purego v0.7.1 // indirect
 	github.com/gen2brain/raylib-go/raylib v0.0.0-20250215042252-db8e47f0e5c5 // indirect
-	github.com/veandco/go-sdl2 v0.4.40 // indirect
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
 	golang.org/x/sys v0.20.0 // indirect
 )
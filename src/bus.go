@@ -0,0 +1,23 @@
+package main
+
+// BusAccess is one memory access made by the CPU.
+type BusAccess struct {
+	Address uint16
+	Value   uint8
+	Write   bool
+}
+
+// BusRecorder keeps the memory accesses the CPU makes, in order, so tests
+// can check them cycle by cycle.
+type BusRecorder struct {
+	Accesses []BusAccess
+}
+
+func (b *BusRecorder) record(address uint16, value uint8, write bool) {
+	b.Accesses = append(b.Accesses, BusAccess{address, value, write})
+}
+
+// Reset forgets the accesses recorded so far.
+func (b *BusRecorder) Reset() {
+	b.Accesses = b.Accesses[:0]
+}
@@ -0,0 +1,167 @@
+package main
+
+// In CGB mode the Game Boy Color adds to the DMG:
+//
+//   - a second 8KB VRAM bank, selected by VBK (0xFF4F), holding more tiles
+//     and an attribute byte for each BG map entry
+//   - 32KB of WRAM in eight 4KB banks: bank 0 is always at 0xC000 and
+//     0xD000 has the bank selected by SVBK (0xFF70), where 0 selects 1
+//   - eight BG and eight object palettes of four 15-bit colours, written
+//     through BCPS/BCPD (0xFF68/0xFF69) and OCPS/OCPD (0xFF6A/0xFF6B)
+//   - a double speed mode, switched by STOP after setting KEY1 (0xFF4D)
+//   - VRAM DMA, see hdma.go
+//
+// cpu.Memory always holds the banks that are mapped in; the others are kept
+// in cpu.VRAMBanks and cpu.WRAMBanks and swapped in when the bank changes.
+
+// resetCGB puts the CGB registers in the state the boot ROM leaves them in.
+// In DMG mode they are plain memory.
+func (cpu *CPU) resetCGB() {
+	cpu.Memory[0xFF4D] = 0x7E
+	cpu.Memory[0xFF4F] = 0xFE
+	cpu.Memory[0xFF55] = 0xFF
+	cpu.Memory[0xFF68] = 0x40
+	cpu.Memory[0xFF6A] = 0x40
+	cpu.Memory[0xFF70] = 0xF8
+	cpu.VRAMBank, cpu.WRAMBank = 0, 1
+	// the boot ROM sets every BG colour to white
+	for i := 0; i < len(cpu.BGPalettes); i += 2 {
+		cpu.BGPalettes[i], cpu.BGPalettes[i+1] = 0xFF, 0x7F
+	}
+	cpu.Memory[0xFF69] = cpu.BGPalettes[0]
+}
+
+// writeCGB stores a write to a CGB I/O register in CGB mode.
+func (cpu *CPU) writeCGB(address uint16, value uint8) {
+	switch address {
+	case 0xFF4D:
+		// only the switch can be armed; bit 7 is the current speed
+		cpu.Memory[0xFF4D] = cpu.Memory[0xFF4D]&0x80 | 0x7E | value&0x01
+	case 0xFF4F:
+		cpu.setVRAMBank(int(value & 0x01))
+		cpu.Memory[0xFF4F] = 0xFE | value
+	case 0xFF55:
+		cpu.writeHDMA(value)
+	case 0xFF68, 0xFF6A:
+		cpu.Memory[address] = 0x40 | value
+		cpu.Memory[address+1] = cpu.paletteRAM(address)[value&0x3F]
+	case 0xFF69, 0xFF6B:
+		cpu.writePalette(address-1, value)
+	case 0xFF70:
+		bank := int(value & 0x07)
+		if bank == 0 {
+			bank = 1
+		}
+		cpu.setWRAMBank(bank)
+		cpu.Memory[0xFF70] = 0xF8 | value
+	}
+}
+
+func (cpu *CPU) setVRAMBank(bank int) {
+	if bank == cpu.VRAMBank {
+		return
+	}
+	copy(cpu.VRAMBanks[cpu.VRAMBank][:], cpu.Memory[0x8000:0xA000])
+	copy(cpu.Memory[0x8000:0xA000], cpu.VRAMBanks[bank][:])
+	cpu.VRAMBank = bank
+}
+
+func (cpu *CPU) setWRAMBank(bank int) {
+	if bank == cpu.WRAMBank {
+		return
+	}
+	copy(cpu.WRAMBanks[cpu.WRAMBank][:], cpu.Memory[0xD000:0xE000])
+	copy(cpu.Memory[0xD000:0xE000], cpu.WRAMBanks[bank][:])
+	cpu.WRAMBank = bank
+}
+
+// vram returns a VRAM bank, indexed from 0x8000, whether or not it is the
+// one mapped in.
+func (cpu *CPU) vram(bank int) []uint8 {
+	if bank == cpu.VRAMBank {
+		return cpu.Memory[0x8000:0xA000]
+	}
+	return cpu.VRAMBanks[bank][:]
+}
+
+// paletteRAM returns the palettes selected by BCPS or OCPS.
+func (cpu *CPU) paletteRAM(spec uint16) []uint8 {
+	if spec == 0xFF68 {
+		return cpu.BGPalettes[:]
+	}
+	return cpu.OBJPalettes[:]
+}
+
+// writePalette stores a byte of palette RAM at the index in the BCPS or
+// OCPS register spec, moving the index on if its bit 7 asks for that. The
+// PPU has the palettes to itself during mode 3, when writes are ignored.
+func (cpu *CPU) writePalette(spec uint16, value uint8) {
+	index := cpu.Memory[spec] & 0x3F
+	palettes := cpu.paletteRAM(spec)
+	if cpu.Memory[0xFF41]&0x03 != 3 {
+		palettes[index] = value
+	}
+	if cpu.Memory[spec]&0x80 != 0 {
+		index = (index + 1) & 0x3F
+		cpu.Memory[spec] = 0xC0 | index
+	}
+	cpu.Memory[spec+1] = palettes[index]
+}
+
+// cgbColour returns colour number colour of a palette as ARGB. Colours are
+// stored little endian as 5 bits each of red, green and blue.
+func cgbColour(palettes []uint8, palette, colour uint8) uint32 {
+	i := int(palette)*8 + int(colour)*2
+	rgb := uint32(palettes[i]) | uint32(palettes[i+1])<<8
+	scale := func(c uint32) uint32 {
+		c &= 0x1F
+		return c<<3 | c>>2
+	}
+	return 0xFF000000 | scale(rgb)<<16 | scale(rgb>>5)<<8 | scale(rgb>>10)
+}
+
+// The CGB boot ROM gives DMG games colours too. Nintendo's own games get
+// palettes picked by their title, which aren't emulated; every other game
+// gets the ones chosen by holding Right+A while it boots: white, green,
+// blue and black for the background, and white, pink, brown and black for
+// both object palettes. BGP, OBP0 and OBP1 then pick from those four
+// colours instead of shades of grey.
+var (
+	compatibilityBG  = [4]uint16{0x7FFF, 0x1BEF, 0x6180, 0x0000}
+	compatibilityOBJ = [4]uint16{0x7FFF, 0x421F, 0x1CF2, 0x0000}
+)
+
+func (cpu *CPU) setCompatibilityPalettes() {
+	for i, colour := range compatibilityBG {
+		cpu.BGPalettes[i*2], cpu.BGPalettes[i*2+1] = uint8(colour), uint8(colour>>8)
+	}
+	for palette := 0; palette < 2; palette++ {
+		for i, colour := range compatibilityOBJ {
+			cpu.OBJPalettes[palette*8+i*2], cpu.OBJPalettes[palette*8+i*2+1] = uint8(colour), uint8(colour>>8)
+		}
+	}
+}
+
+// SpeedSwitchCycles is how long the CPU is paused for while it changes
+// speed, in M-cycles.
+const SpeedSwitchCycles = 2050
+
+// speedSwitchArmed reports whether KEY1 has a speed switch waiting for STOP.
+func (cpu *CPU) speedSwitchArmed() bool {
+	return cpu.CGBMode && cpu.Memory[0xFF4D]&0x01 != 0
+}
+
+// switchSpeed changes between normal and double speed, as STOP does when
+// KEY1 has asked for it. DIV is reset and doesn't count while the CPU is
+// paused for the switch.
+func (cpu *CPU) switchSpeed() {
+	cpu.DoubleSpeed = !cpu.DoubleSpeed
+	cpu.Memory[0xFF4D] = 0x7E
+	if cpu.DoubleSpeed {
+		cpu.Memory[0xFF4D] |= 0x80
+	}
+	for i := 0; i < SpeedSwitchCycles; i++ {
+		cpu.tick()
+	}
+	cpu.writeTimer(0xFF04, 0)
+}
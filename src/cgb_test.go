@@ -0,0 +1,134 @@
+package main
+
+import "testing"
+
+func newCGBTestCPU() *CPU {
+	cpu := InitCPU()
+	cpu.ROM[cgbFlag] = 0x80
+	cpu.SetModel(ModelAuto)
+	return cpu
+}
+
+func TestVRAMBanks(t *testing.T) {
+	cpu := newCGBTestCPU()
+	cpu.WriteMemory(0x9800, 0x11)
+	cpu.WriteMemory(0xFF4F, 0x01)
+	if vbk := cpu.ReadMemory(0xFF4F); vbk != 0xFF {
+		t.Errorf("VBK reads %02X, expected FF", vbk)
+	}
+	if value := cpu.ReadMemory(0x9800); value != 0x00 {
+		t.Errorf("bank 1 reads %02X", value)
+	}
+	cpu.WriteMemory(0x9800, 0x22)
+	cpu.WriteMemory(0xFF4F, 0x00)
+	if value := cpu.ReadMemory(0x9800); value != 0x11 {
+		t.Errorf("bank 0 reads %02X after switching back, expected 11", value)
+	}
+	if bank0, bank1 := cpu.vram(0)[0x1800], cpu.vram(1)[0x1800]; bank0 != 0x11 || bank1 != 0x22 {
+		t.Errorf("banks hold %02X and %02X, expected 11 and 22", bank0, bank1)
+	}
+
+	// in DMG mode VBK does nothing
+	cpu = InitCPU()
+	cpu.SetModel(ModelDMG)
+	cpu.WriteMemory(0x9800, 0x11)
+	cpu.WriteMemory(0xFF4F, 0x01)
+	if value := cpu.ReadMemory(0x9800); value != 0x11 {
+		t.Errorf("DMG switched VRAM bank")
+	}
+}
+
+func TestWRAMBanks(t *testing.T) {
+	cpu := newCGBTestCPU()
+	cpu.WriteMemory(0xC000, 0xCC)
+	for bank := uint8(1); bank < 8; bank++ {
+		cpu.WriteMemory(0xFF70, bank)
+		cpu.WriteMemory(0xD000, bank)
+	}
+	for bank := uint8(7); bank >= 1; bank-- {
+		cpu.WriteMemory(0xFF70, bank)
+		if value := cpu.ReadMemory(0xD000); value != bank {
+			t.Errorf("bank %d holds %d", bank, value)
+		}
+	}
+	cpu.WriteMemory(0xFF70, 0x00)
+	if value, svbk := cpu.ReadMemory(0xD000), cpu.ReadMemory(0xFF70); value != 1 || svbk != 0xF8 {
+		t.Errorf("bank 0 selected bank holding %d, SVBK %02X; expected bank 1, F8", value, svbk)
+	}
+	if value := cpu.ReadMemory(0xC000); value != 0xCC {
+		t.Errorf("0xC000 changed with the bank")
+	}
+}
+
+func TestPaletteRAM(t *testing.T) {
+	cpu := newCGBTestCPU()
+	cpu.WriteMemory(0xFF6A, 0x80|0x3E) // OCPS, auto increment from the last colour
+	for _, value := range []uint8{0x1F, 0x00, 0xE0, 0x03} {
+		cpu.WriteMemory(0xFF6B, value)
+	}
+	if cpu.OBJPalettes[0x3E] != 0x1F || cpu.OBJPalettes[0x3F] != 0x00 || cpu.OBJPalettes[0] != 0xE0 || cpu.OBJPalettes[1] != 0x03 {
+		t.Errorf("palette RAM % X ... % X", cpu.OBJPalettes[:2], cpu.OBJPalettes[0x3E:])
+	}
+	if ocps := cpu.ReadMemory(0xFF6A); ocps != 0xC2 {
+		t.Errorf("OCPS reads %02X after wrapping around, expected C2", ocps)
+	}
+
+	cpu.WriteMemory(0xFF68, 0x02) // no auto increment
+	cpu.WriteMemory(0xFF69, 0x55)
+	cpu.WriteMemory(0xFF69, 0x66)
+	if bcpd := cpu.ReadMemory(0xFF69); bcpd != 0x66 || cpu.BGPalettes[3] != 0x7F {
+		t.Errorf("BCPD reads %02X, next byte %02X", bcpd, cpu.BGPalettes[3])
+	}
+
+	// locked during mode 3
+	cpu.Memory[0xFF41] = 0x83
+	cpu.writePalette(0xFF68, 0x77)
+	if cpu.BGPalettes[2] != 0x66 {
+		t.Errorf("palette written during mode 3")
+	}
+}
+
+func TestCGBColour(t *testing.T) {
+	palettes := []uint8{0xFF, 0x7F, 0x1F, 0x00, 0xE0, 0x03, 0x00, 0x7C}
+	for colour, expected := range []uint32{0xFFFFFFFF, 0xFFFF0000, 0xFF00FF00, 0xFF0000FF} {
+		if argb := cgbColour(palettes, 0, uint8(colour)); argb != expected {
+			t.Errorf("colour %d is %08X, expected %08X", colour, argb, expected)
+		}
+	}
+}
+
+func TestSpeedSwitch(t *testing.T) {
+	cpu := newCGBTestCPU()
+	copy(cpu.Memory[0xC000:], []uint8{
+		0x3E, 0x01, // LD A, 1
+		0xE0, 0x4D, // LDH (KEY1), A
+		0x10, 0x00, // STOP
+		0x00, // NOP
+	})
+	cpu.PC = 0xC000
+	cpu.Memory[0xFF00] = 0x30 // no buttons selected
+	for cpu.PC < 0xC006 {
+		cpu.ParseNextOpcode()
+	}
+	if !cpu.DoubleSpeed || cpu.Stopped || cpu.Halted {
+		t.Fatalf("double speed %t, stopped %t, halted %t", cpu.DoubleSpeed, cpu.Stopped, cpu.Halted)
+	}
+	if key1 := cpu.ReadMemory(0xFF4D); key1 != 0xFE {
+		t.Errorf("KEY1 reads %02X, expected FE", key1)
+	}
+
+	// the CPU and the timer are twice as fast as the LCD
+	start, counter := cpu.Clock, cpu.divCounter()
+	cpu.ParseNextOpcode()
+	if cpu.Clock-start != 2 || cpu.divCounter()-counter != 4 {
+		t.Errorf("NOP took %d clocks and %d timer cycles, expected 2 and 4", cpu.Clock-start, cpu.divCounter()-counter)
+	}
+
+	// and back
+	cpu.Memory[0xFF4D] |= 0x01
+	cpu.PC = 0xC004
+	cpu.ParseNextOpcode()
+	if cpu.DoubleSpeed || cpu.ReadMemory(0xFF4D) != 0x7E {
+		t.Errorf("still in double speed, KEY1 %02X", cpu.Memory[0xFF4D])
+	}
+}
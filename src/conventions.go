@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Debug conventions shared with BGB and no$gmb, so homebrew can talk to the
+// emulator from inside the ROM:
+//
+//	ld b,b             ; 0x40, a source breakpoint
+//
+//	ld d,d             ; 0x52, a debug message
+//	jr .end
+//	dw $6464
+//	dw $0000
+//	db "HL=%HL% [wScore]=%[wScore]%"
+//	.end
+//
+// Both instructions do nothing on hardware. Message placeholders are
+// written between percent signs:
+//
+//	%A% ... %L%, %AF% ... %HL%, %SP%, %PC%   registers in hex
+//	%ZERO% %NEGATIVE% %HALFCARRY% %CARRY%     flags as 0 or 1
+//	%LY% %SCANLINE%                           current line, decimal
+//	%FRAME%                                   frames since power on, decimal
+//	%[ADDR]%                                  byte at ADDR: a hex address,
+//	                                          register pair or label
+//	%%                                        a percent sign
+//
+// Unknown placeholders are left as they are.
+
+// SourceBreakMode is what ld b,b does.
+type SourceBreakMode int
+
+const (
+	SourceBreakOff SourceBreakMode = iota
+	SourceBreakLog
+	SourceBreakDebugger
+)
+
+func ParseSourceBreakMode(s string) (SourceBreakMode, error) {
+	switch s {
+	case "off":
+		return SourceBreakOff, nil
+	case "log":
+		return SourceBreakLog, nil
+	case "break":
+		return SourceBreakDebugger, nil
+	}
+	return SourceBreakOff, fmt.Errorf("unknown mode %q (off, log or break)", s)
+}
+
+// DebugConventions handles ld b,b and ld d,d before they execute.
+type DebugConventions struct {
+	SourceBreak SourceBreakMode
+	Messages    bool
+	Out         io.Writer // where messages and logged breakpoints go
+}
+
+const (
+	opcodeLDBB = 0x40
+	opcodeLDDD = 0x52
+)
+
+// Check is called before every instruction.
+func (dc *DebugConventions) Check(cpu *CPU) {
+	switch cpu.Memory[cpu.PC] {
+	case opcodeLDBB:
+		switch dc.SourceBreak {
+		case SourceBreakLog:
+			fmt.Fprintf(dc.Out, "ld b,b breakpoint at %s\n", cpu.DescribeAddress(cpu.PC))
+		case SourceBreakDebugger:
+			if cpu.GDB != nil && cpu.GDB.Attached() {
+				cpu.GDB.Break()
+			} else {
+				cpu.AttachDebugger().Break("ld b,b")
+			}
+		}
+	case opcodeLDDD:
+		if !dc.Messages {
+			return
+		}
+		if message, ok := cpu.debugMessage(cpu.PC); ok {
+			fmt.Fprintln(dc.Out, cpu.FormatDebugMessage(message))
+		}
+	}
+}
+
+// debugMessage returns the message of an ld d,d block at address, if the
+// instruction is followed by one.
+func (cpu *CPU) debugMessage(address uint16) (string, bool) {
+	mem := func(offset uint16) uint8 { return cpu.Memory[address+offset] }
+	if mem(1) != 0x18 || mem(3) != 0x64 || mem(4) != 0x64 || mem(5) != 0x00 || mem(6) != 0x00 {
+		return "", false
+	}
+	// the jr skips over the block; its operand is relative to address+3
+	end := 3 + int(int8(mem(2)))
+	if end < 7 {
+		return "", false
+	}
+	var b strings.Builder
+	for offset := 7; offset < end; offset++ {
+		c := mem(uint16(offset))
+		if c == 0 {
+			break
+		}
+		b.WriteByte(c)
+	}
+	return b.String(), true
+}
+
+// FormatDebugMessage fills in the placeholders of a debug message.
+func (cpu *CPU) FormatDebugMessage(message string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(message, '%')
+		if start < 0 {
+			b.WriteString(message)
+			return b.String()
+		}
+		end := strings.IndexByte(message[start+1:], '%')
+		if end < 0 {
+			b.WriteString(message)
+			return b.String()
+		}
+		end += start + 1
+
+		b.WriteString(message[:start])
+		token := message[start+1 : end]
+		if value, ok := cpu.placeholder(token); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(message[start : end+1])
+		}
+		message = message[end+1:]
+	}
+}
+
+func (cpu *CPU) placeholder(token string) (string, bool) {
+	name := strings.ToLower(token)
+	if _, ok := registers8[name]; ok {
+		value, _ := readRegister(cpu, name)
+		return fmt.Sprintf("%02X", value), true
+	}
+	switch name {
+	case "":
+		return "%", true
+	case "af", "bc", "de", "hl", "sp", "pc":
+		value, _ := readRegister(cpu, name)
+		return fmt.Sprintf("%04X", value), true
+	case "zero", "negative", "halfcarry", "carry":
+		flag := map[string]string{"zero": "zf", "negative": "nf", "halfcarry": "hf", "carry": "cf"}[name]
+		value, _ := readRegister(cpu, flag)
+		return strconv.Itoa(int(value)), true
+	case "ly", "scanline":
+		return strconv.Itoa(int(cpu.Memory[0xFF44])), true
+	case "frame":
+		return strconv.FormatUint(cpu.Frames, 10), true
+	}
+
+	if strings.HasPrefix(token, "[") && strings.HasSuffix(token, "]") {
+		location := strings.TrimSpace(token[1 : len(token)-1])
+		if pair, ok := registers16[strings.ToLower(location)]; ok {
+			address := uint16(cpu.Registers[pair[0]])<<8 | uint16(cpu.Registers[pair[1]])
+			return fmt.Sprintf("%02X", cpu.Memory[address]), true
+		}
+		if _, address, err := cpu.ParseLocation(location); err == nil {
+			return fmt.Sprintf("%02X", cpu.Memory[address]), true
+		}
+	}
+	return "", false
+}
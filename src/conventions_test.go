@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// debugMessageProgram prints a message with placeholders, then hits a
+// source breakpoint.
+var debugMessageProgram = []byte{
+	0x21, 0x00, 0xC0, // 0000 LD HL, 0xC000
+	0x36, 0x2A, // 0003 LD (HL), 0x2A
+	0x52,       // 0005 LD D, D
+	0x18, 0x1A, // 0006 JR +26, to 0x0022
+	0x64, 0x64, 0x00, 0x00, // 0008 signature
+	'H', 'L', '=', '%', 'H', 'L', '%', ' ', // 000C message
+	'(', 'H', 'L', ')', '=', '%', '[', 'H', 'L', ']', '%', ' ',
+	'%', '%',
+	0x40,       // 0022 LD B, B
+	0x18, 0xFE, // 0023 JR -2
+}
+
+func newConventionsTestCPU(mode SourceBreakMode) (*CPU, *strings.Builder) {
+	cpu := InitCPU()
+	copy(cpu.Memory, debugMessageProgram)
+	var out strings.Builder
+	cpu.Conventions = &DebugConventions{SourceBreak: mode, Messages: true, Out: &out}
+	return cpu, &out
+}
+
+func TestDebugMessage(t *testing.T) {
+	cpu, out := newConventionsTestCPU(SourceBreakOff)
+	for i := 0; i < 10; i++ {
+		cpu.Step()
+	}
+	if out.String() != "HL=C000 (HL)=2A %\n" {
+		t.Errorf("unexpected message %q", out.String())
+	}
+}
+
+func TestFormatDebugMessage(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Registers[RegA] = 0x12
+	cpu.Flags.SetC(true)
+	cpu.Memory[0xC123] = 0x99
+	cpu.Symbols, _ = ReadSymbols(strings.NewReader("00:c123 wLives\n"))
+	message := cpu.FormatDebugMessage("a=%A% c=%CARRY% z=%ZERO% lives=%[wLives]% at=%[c123]% %NOPE% 100%")
+	if expected := "a=12 c=1 z=0 lives=99 at=99 %NOPE% 100%"; message != expected {
+		t.Errorf("expected %q, got %q", expected, message)
+	}
+}
+
+func TestSourceBreakpoint(t *testing.T) {
+	cpu, out := newConventionsTestCPU(SourceBreakLog)
+	cpu.Conventions.Messages = false
+	for i := 0; i < 5; i++ {
+		cpu.Step()
+	}
+	if !strings.HasPrefix(out.String(), "ld b,b breakpoint at 00:0022\n") {
+		t.Errorf("expected the breakpoint to be logged, got %q", out.String())
+	}
+
+	cpu, _ = newConventionsTestCPU(SourceBreakDebugger)
+	var debugOut strings.Builder
+	cpu.Debugger = NewDebugger(strings.NewReader("quit\n"), &debugOut)
+	for i := 0; i < 10 && cpu.Debugger != nil; i++ {
+		cpu.Step()
+	}
+	if !strings.Contains(debugOut.String(), "Break (ld b,b) at 00:0022") {
+		t.Errorf("expected to break into the debugger, output:\n%s", debugOut.String())
+	}
+}
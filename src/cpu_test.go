@@ -32,8 +32,37 @@ type CPUState struct {
 	RAM [][2]int `json:"ram"`
 }
 
+// CycleEvent is one M-cycle: [address, data, "read" or "write"], or null
+// for a cycle without a memory access.
 type CycleEvent []interface{}
 
+// BusAccesses returns the memory accesses of a test, leaving out the
+// internal cycles.
+func (test CPUTest) BusAccesses() []BusAccess {
+	var accesses []BusAccess
+	for _, event := range test.Cycles {
+		if len(event) != 3 {
+			continue
+		}
+		address, _ := event[0].(float64)
+		value, _ := event[1].(float64)
+		accesses = append(accesses, BusAccess{uint16(address), uint8(value), event[2] == "write"})
+	}
+	return accesses
+}
+
+func equalBusAccesses(a, b []BusAccess) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Function to parse the JSON
 func ParseGameBoyTest(jsonData []byte) ([]CPUTest, error) {
 	var tests []CPUTest
@@ -95,6 +124,12 @@ func LoadAllTests(dirPath string) (map[string][]CPUTest, error) {
 	return allTests, nil
 }
 
+// hardwareRegisters are the I/O registers that don't simply hold what is
+// written to them: DIV resets, TAC's unused bits read 1, STAT's mode bits
+// are read only and LY follows the LCD. The tests treat all of memory as
+// RAM, so these are only checked through the bus accesses.
+var hardwareRegisters = map[uint16]bool{0xFF04: true, 0xFF07: true, 0xFF41: true, 0xFF44: true}
+
 func RunTest(test CPUTest, t *testing.T) {
 	cpu := InitCPU()
 	cpu.Registers[RegA] = test.Initial.A
@@ -110,10 +145,28 @@ func RunTest(test CPUTest, t *testing.T) {
 	for _, ram := range test.Initial.RAM {
 		cpu.Memory[ram[0]] = uint8(ram[1])
 	}
+	opcode := cpu.Memory[cpu.PC]
 
 	// run
 
+	cpu.Bus = &BusRecorder{}
+	before := cpu.Clock
 	cpu.ParseNextOpcode()
+	cycles := int(cpu.Clock-before) / 4
+
+	// The tests start with the opcode already fetched and end by fetching the
+	// next one. The interpreter fetches at the start of an instruction, so
+	// its first access has to be that fetch, which the tests leave out, and
+	// the next fetch is made here instead.
+	cpu.ReadMemory(cpu.PC)
+	accesses := cpu.Bus.Accesses
+	cpu.Bus = nil
+	if fetch := (BusAccess{Address: test.Initial.PC - 1, Value: opcode}); len(accesses) == 0 || accesses[0] != fetch {
+		log.Printf("Test %v: opcode fetch mismatch: expected %v, got %v", test.Name, fetch, accesses)
+		t.Errorf("Test %v: opcode fetch mismatch: expected %v, got %v", test.Name, fetch, accesses)
+	} else {
+		accesses = accesses[1:]
+	}
 
 	// check
 
@@ -157,7 +210,18 @@ func RunTest(test CPUTest, t *testing.T) {
 		log.Printf("Test %v: SP mismatch: expected %v, got %v", test.Name, test.Final.SP, cpu.SP)
 		t.Errorf("Test %v: SP mismatch: expected %v, got %v", test.Name, test.Final.SP, cpu.SP)
 	}
+	if cycles != len(test.Cycles) {
+		log.Printf("Test %v: M-cycle mismatch: expected %v, got %v", test.Name, len(test.Cycles), cycles)
+		t.Errorf("Test %v: M-cycle mismatch: expected %v, got %v", test.Name, len(test.Cycles), cycles)
+	}
+	if expected := test.BusAccesses(); !equalBusAccesses(expected, accesses) {
+		log.Printf("Test %v: bus mismatch: expected %v, got %v", test.Name, expected, accesses)
+		t.Errorf("Test %v: bus mismatch: expected %v, got %v", test.Name, expected, accesses)
+	}
 	for i, ram := range test.Final.RAM {
+		if hardwareRegisters[uint16(ram[0])] {
+			continue
+		}
 		if cpu.Memory[ram[0]] != uint8(ram[1]) {
 			log.Printf("Test %v: RAM mismatch at index %v: expected %v, got %v", test.Name, i, ram[1], cpu.Memory[ram[0]])
 			t.Errorf("Test %v: RAM mismatch at index %v: expected %v, got %v", test.Name, i, ram[1], cpu.Memory[ram[0]])
@@ -0,0 +1,691 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Debugger is the interactive command-line debugger. When attached to the
+// CPU (cpu.Debugger) it is consulted before every instruction and drops
+// into a prompt on stdin when a breakpoint, watchpoint or step completes.
+type Debugger struct {
+	Breakpoints []*Breakpoint
+	Watchpoints []*Watchpoint
+
+	in  *bufio.Scanner
+	out io.Writer
+
+	nextID int
+
+	pending  string // reason to break before the next instruction
+	stepping int    // instructions left before breaking, 0 when not stepping
+
+	// step over: break when execution returns to overAddr with the stack
+	// back where it was
+	overActive bool
+	overAddr   uint16
+	overSP     uint16
+
+	// step out: break once a return pops the stack above outSP
+	outActive  bool
+	outSP      uint16
+	lastReturn bool // the previous instruction was a RET
+}
+
+// Breakpoint stops execution when PC reaches Address. Bank is the ROM bank
+// it applies to, or -1 for any bank.
+type Breakpoint struct {
+	ID        int
+	Bank      int
+	Address   uint16
+	Label     string // label at Address, if symbols are loaded
+	Condition *Condition
+}
+
+const (
+	WatchRead = 1 << iota
+	WatchWrite
+	WatchExecute
+)
+
+// Watchpoint stops execution after an access of the given kinds anywhere in
+// Start..End (inclusive).
+type Watchpoint struct {
+	ID    int
+	Kind  int
+	Start uint16
+	End   uint16
+}
+
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		in:     bufio.NewScanner(in),
+		out:    out,
+		nextID: 1,
+	}
+}
+
+// Break stops at the next instruction, e.g. at start up or from a hotkey.
+func (d *Debugger) Break(reason string) {
+	d.pending = reason
+}
+
+// Check is called before every instruction and runs the prompt if execution
+// should stop there.
+func (d *Debugger) Check(cpu *CPU) {
+	reason := d.pending
+	d.pending = ""
+
+	if reason == "" && d.stepping > 0 {
+		d.stepping--
+		if d.stepping == 0 {
+			reason = "step"
+		}
+	}
+	if reason == "" && d.overActive && cpu.PC == d.overAddr && cpu.SP >= d.overSP {
+		reason = "step over"
+	}
+	if reason == "" && d.outActive && d.lastReturn && cpu.SP > d.outSP {
+		reason = "step out"
+	}
+	if reason == "" {
+		reason = d.checkBreakpoints(cpu)
+	}
+	d.lastReturn = cpu.Disassemble(cpu.PC).IsReturn()
+
+	if reason != "" {
+		d.stepping = 0
+		d.overActive = false
+		d.outActive = false
+		d.prompt(cpu, reason)
+		d.lastReturn = cpu.Disassemble(cpu.PC).IsReturn()
+	}
+}
+
+func (d *Debugger) checkBreakpoints(cpu *CPU) string {
+	for _, bp := range d.Breakpoints {
+		if bp.Address != cpu.PC || (bp.Bank >= 0 && bp.Bank != cpu.BankAt(cpu.PC)) {
+			continue
+		}
+		if bp.Condition != nil && !bp.Condition.Eval(cpu) {
+			continue
+		}
+		return fmt.Sprintf("breakpoint %d", bp.ID)
+	}
+	for _, wp := range d.Watchpoints {
+		if wp.Kind&WatchExecute != 0 && cpu.PC >= wp.Start && cpu.PC <= wp.End {
+			return fmt.Sprintf("watchpoint %d: execute 0x%04X", wp.ID, cpu.PC)
+		}
+	}
+	return ""
+}
+
+// Access is called by ReadMemory and WriteMemory. A hit stops execution once
+// the current instruction has finished.
+func (d *Debugger) Access(address uint16, value uint8, write bool) {
+	kind, verb := WatchRead, "read"
+	if write {
+		kind, verb = WatchWrite, "write"
+	}
+	for _, wp := range d.Watchpoints {
+		if wp.Kind&kind != 0 && address >= wp.Start && address <= wp.End {
+			d.pending = fmt.Sprintf("watchpoint %d: %s 0x%02X at 0x%04X", wp.ID, verb, value, address)
+			return
+		}
+	}
+}
+
+func (d *Debugger) prompt(cpu *CPU, reason string) {
+	fmt.Fprintf(d.out, "Break (%s) at %s\n", reason, cpu.DescribeAddress(cpu.PC))
+	d.printRegisters(cpu)
+	d.printInstruction(cpu)
+
+	for {
+		fmt.Fprint(d.out, "(gbdb) ")
+		if !d.in.Scan() {
+			// stdin closed, nothing more to do but run
+			fmt.Fprintln(d.out)
+			return
+		}
+		fields := strings.Fields(d.in.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		resume, err := d.command(cpu, fields[0], fields[1:])
+		if err != nil {
+			fmt.Fprintf(d.out, "error: %v\n", err)
+		}
+		if resume {
+			return
+		}
+	}
+}
+
+const debuggerHelp = `Addresses and values are hex (0x and $ prefixes are optional); counts are decimal.
+An address may be bank qualified, e.g. 01:4000, or a label from the symbol file.
+
+  break ADDR [if COND]     set a breakpoint, COND like "a == 3 && hl >= c000"
+  watch [r|w|rw|x] A[-B]   stop on read/write/execute in an address range (default w)
+  delete ID                remove a breakpoint or watchpoint
+  list                     list breakpoints and watchpoints
+  step [N]                 execute N instructions (default 1)
+  next                     step over CALL and RST
+  finish                   run until the current routine returns
+  continue                 resume execution
+  regs                     show registers
+  set REG VALUE            set a register (a f b c d e h l af bc de hl sp pc ime) or flag (zf nf hf cf)
+  x ADDR [N]               dump N bytes of memory (default 64)
+  disas [ADDR] [N]         disassemble N instructions from ADDR (default PC, 10)
+  write ADDR BYTE...       write bytes to memory
+  quit                     stop the emulator
+`
+
+// command runs one debugger command and reports whether execution should
+// resume.
+func (d *Debugger) command(cpu *CPU, name string, args []string) (bool, error) {
+	switch name {
+	case "help", "h", "?":
+		fmt.Fprint(d.out, debuggerHelp)
+	case "break", "b":
+		return false, d.addBreakpoint(cpu, args)
+	case "watch", "w":
+		return false, d.addWatchpoint(cpu, args)
+	case "delete", "d":
+		return false, d.delete(args)
+	case "list", "l", "info":
+		d.list()
+	case "step", "s":
+		count := 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				return false, fmt.Errorf("invalid step count %q", args[0])
+			}
+			count = n
+		}
+		d.stepping = count
+		return true, nil
+	case "next", "n":
+		if inst := cpu.Disassemble(cpu.PC); inst.IsCall() {
+			d.overActive = true
+			d.overAddr = cpu.PC + uint16(inst.Length())
+			d.overSP = cpu.SP
+		} else {
+			d.stepping = 1
+		}
+		return true, nil
+	case "finish", "out", "o":
+		d.outActive = true
+		d.outSP = cpu.SP
+		return true, nil
+	case "continue", "c":
+		return true, nil
+	case "regs", "r":
+		d.printRegisters(cpu)
+	case "set":
+		return false, d.set(cpu, args)
+	case "x":
+		return false, d.examine(cpu, args)
+	case "disas", "u":
+		return false, d.disassemble(cpu, args)
+	case "write":
+		return false, d.write(cpu, args)
+	case "quit", "q":
+		cpu.Exit()
+		cpu.Debugger = nil
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown command %q, try help", name)
+	}
+	return false, nil
+}
+
+func (d *Debugger) addBreakpoint(cpu *CPU, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: break ADDR [if COND]")
+	}
+	bank, address, err := cpu.ParseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	bp := &Breakpoint{ID: d.nextID, Bank: bank, Address: address}
+	if label, ok := cpu.Label(address); ok && (bank < 0 || bank == cpu.BankAt(address)) {
+		bp.Label = label
+	}
+	if len(args) > 1 {
+		if args[1] != "if" || len(args) < 3 {
+			return fmt.Errorf("usage: break ADDR [if COND]")
+		}
+		cond, err := ParseCondition(strings.Join(args[2:], " "), cpu.Symbols)
+		if err != nil {
+			return err
+		}
+		bp.Condition = cond
+	}
+	d.nextID++
+	d.Breakpoints = append(d.Breakpoints, bp)
+	fmt.Fprintf(d.out, "Breakpoint %d at %s\n", bp.ID, bp)
+	return nil
+}
+
+func (d *Debugger) addWatchpoint(cpu *CPU, args []string) error {
+	kind := WatchWrite
+	if len(args) > 1 {
+		switch args[0] {
+		case "r":
+			kind = WatchRead
+		case "w":
+			kind = WatchWrite
+		case "rw":
+			kind = WatchRead | WatchWrite
+		case "x":
+			kind = WatchExecute
+		default:
+			return fmt.Errorf("unknown watchpoint kind %q (r, w, rw or x)", args[0])
+		}
+		args = args[1:]
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: watch [r|w|rw|x] ADDR[-ADDR]")
+	}
+
+	start, end, found := strings.Cut(args[0], "-")
+	_, first, err := cpu.ParseLocation(start)
+	if err != nil {
+		return err
+	}
+	last := first
+	if found {
+		if _, last, err = cpu.ParseLocation(end); err != nil {
+			return err
+		}
+	}
+	if last < first {
+		return fmt.Errorf("empty range %04X-%04X", first, last)
+	}
+
+	wp := &Watchpoint{ID: d.nextID, Kind: kind, Start: first, End: last}
+	d.nextID++
+	d.Watchpoints = append(d.Watchpoints, wp)
+	fmt.Fprintf(d.out, "Watchpoint %d: %s\n", wp.ID, wp)
+	return nil
+}
+
+func (d *Debugger) delete(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: delete ID")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid id %q", args[0])
+	}
+	for i, bp := range d.Breakpoints {
+		if bp.ID == id {
+			d.Breakpoints = append(d.Breakpoints[:i], d.Breakpoints[i+1:]...)
+			return nil
+		}
+	}
+	for i, wp := range d.Watchpoints {
+		if wp.ID == id {
+			d.Watchpoints = append(d.Watchpoints[:i], d.Watchpoints[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("no breakpoint or watchpoint %d", id)
+}
+
+func (d *Debugger) list() {
+	for _, bp := range d.Breakpoints {
+		fmt.Fprintf(d.out, "%d: break %s\n", bp.ID, bp)
+	}
+	for _, wp := range d.Watchpoints {
+		fmt.Fprintf(d.out, "%d: watch %s\n", wp.ID, wp)
+	}
+}
+
+func (bp *Breakpoint) String() string {
+	s := fmt.Sprintf("%04X", bp.Address)
+	if bp.Bank >= 0 {
+		s = fmt.Sprintf("%02X:%04X", bp.Bank, bp.Address)
+	}
+	if bp.Label != "" {
+		s += " (" + bp.Label + ")"
+	}
+	if bp.Condition != nil {
+		s += " if " + bp.Condition.String()
+	}
+	return s
+}
+
+func (wp *Watchpoint) String() string {
+	kind := ""
+	if wp.Kind&WatchRead != 0 {
+		kind += "r"
+	}
+	if wp.Kind&WatchWrite != 0 {
+		kind += "w"
+	}
+	if wp.Kind&WatchExecute != 0 {
+		kind += "x"
+	}
+	if wp.Start == wp.End {
+		return fmt.Sprintf("%s %04X", kind, wp.Start)
+	}
+	return fmt.Sprintf("%s %04X-%04X", kind, wp.Start, wp.End)
+}
+
+func (d *Debugger) printRegisters(cpu *CPU) {
+	flag := func(set bool, name string) string {
+		if set {
+			return name
+		}
+		return "-"
+	}
+	fmt.Fprintf(d.out, "AF=%04X BC=%04X DE=%04X HL=%04X SP=%04X PC=%04X  %s%s%s%s IME=%d",
+		cpu.GetAF(), cpu.GetBC(), cpu.GetDE(), cpu.GetHL(), cpu.SP, cpu.PC,
+		flag(cpu.Flags.Z(), "Z"), flag(cpu.Flags.N(), "N"), flag(cpu.Flags.H(), "H"), flag(cpu.Flags.C(), "C"),
+		cpu.IME)
+	if cpu.Halted {
+		fmt.Fprint(d.out, " halted")
+	}
+	if cpu.Stopped {
+		fmt.Fprint(d.out, " stopped")
+	}
+	fmt.Fprintln(d.out)
+}
+
+func (d *Debugger) printInstruction(cpu *CPU) {
+	fmt.Fprintln(d.out, formatInstruction(cpu.BankAt(cpu.PC), cpu.Disassemble(cpu.PC), cpu.Label))
+}
+
+func (d *Debugger) disassemble(cpu *CPU, args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("usage: disas [ADDR] [N]")
+	}
+	address, count := cpu.PC, 10
+	if len(args) > 0 {
+		_, value, err := cpu.ParseLocation(args[0])
+		if err != nil {
+			return err
+		}
+		address = value
+	}
+	if len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid count %q", args[1])
+		}
+		count = n
+	}
+	for i := 0; i < count; i++ {
+		inst := cpu.Disassemble(address)
+		if label, ok := cpu.Label(address); ok {
+			fmt.Fprintf(d.out, "%s:\n", label)
+		}
+		fmt.Fprintln(d.out, formatInstruction(cpu.BankAt(address), inst, cpu.Label))
+		address += uint16(inst.Length())
+	}
+	return nil
+}
+
+func (d *Debugger) set(cpu *CPU, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set REG VALUE")
+	}
+	value, err := parseHex16(args[1])
+	if err != nil {
+		return err
+	}
+	name := strings.ToLower(args[0])
+
+	if reg, ok := registers8[name]; ok {
+		if value > 0xFF {
+			return fmt.Errorf("%s is an 8-bit register", name)
+		}
+		if reg == RegF {
+			cpu.Flags.SetValue(uint8(value))
+		} else {
+			cpu.Registers[reg] = uint8(value)
+		}
+		return nil
+	}
+	if pair, ok := registers16[name]; ok {
+		cpu.Registers[pair[0]] = uint8(value >> 8)
+		cpu.Registers[pair[1]] = uint8(value)
+		if pair[1] == RegF {
+			cpu.Flags.SetValue(uint8(value))
+		}
+		return nil
+	}
+
+	switch name {
+	case "sp":
+		cpu.SP = value
+	case "pc":
+		cpu.PC = value
+	case "ime":
+		cpu.IME = value & 1
+	case "zf":
+		cpu.Flags.SetZ(value != 0)
+	case "nf":
+		cpu.Flags.SetN(value != 0)
+	case "hf":
+		cpu.Flags.SetH(value != 0)
+	case "cf":
+		cpu.Flags.SetC(value != 0)
+	default:
+		return fmt.Errorf("unknown register %q", args[0])
+	}
+	return nil
+}
+
+func (d *Debugger) examine(cpu *CPU, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: x ADDR [N]")
+	}
+	_, address, err := cpu.ParseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	count := 64
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+			return fmt.Errorf("invalid byte count %q", args[1])
+		}
+	}
+
+	for row := 0; row < count; row += 16 {
+		fmt.Fprintf(d.out, "%04X:", address+uint16(row))
+		for i := row; i < row+16 && i < count; i++ {
+			fmt.Fprintf(d.out, " %02X", cpu.Peek(address+uint16(i)))
+		}
+		fmt.Fprintln(d.out)
+	}
+	return nil
+}
+
+func (d *Debugger) write(cpu *CPU, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: write ADDR BYTE...")
+	}
+	_, address, err := cpu.ParseLocation(args[0])
+	if err != nil {
+		return err
+	}
+	for i, arg := range args[1:] {
+		value, err := parseHex16(arg)
+		if err != nil || value > 0xFF {
+			return fmt.Errorf("invalid byte %q", arg)
+		}
+		cpu.Poke(address+uint16(i), uint8(value))
+	}
+	return nil
+}
+
+var registers8 = map[string]int{
+	"a": RegA, "f": RegF, "b": RegB, "c": RegC,
+	"d": RegD, "e": RegE, "h": RegH, "l": RegL,
+}
+
+var registers16 = map[string][2]int{
+	"af": {RegA, RegF}, "bc": {RegB, RegC}, "de": {RegD, RegE}, "hl": {RegH, RegL},
+}
+
+// parseHex16 parses a hex number with an optional 0x or $ prefix.
+func parseHex16(s string) (uint16, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(s), "0x"), "$")
+	value, err := strconv.ParseUint(digits, 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex number %q", s)
+	}
+	return uint16(value), nil
+}
+
+// parseBankAddress parses ADDR or BANK:ADDR. The bank is -1 if not given.
+func parseBankAddress(s string) (int, uint16, error) {
+	bankPart, addrPart, found := strings.Cut(s, ":")
+	if !found {
+		address, err := parseHex16(s)
+		return -1, address, err
+	}
+	bank, err := parseHex16(bankPart)
+	if err != nil {
+		return 0, 0, err
+	}
+	address, err := parseHex16(addrPart)
+	return int(bank), address, err
+}
+
+// Condition is a conjunction of register comparisons for conditional
+// breakpoints, e.g. "a == 3 && hl >= c000 && zf == 1".
+type Condition struct {
+	terms []conditionTerm
+	text  string
+}
+
+type conditionTerm struct {
+	register string
+	op       string
+	value    uint16
+}
+
+var conditionOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// ParseCondition parses a breakpoint condition. Values may be labels from
+// symbols, which may be nil.
+func ParseCondition(text string, symbols *SymbolTable) (*Condition, error) {
+	cond := &Condition{text: text}
+	for _, part := range strings.Split(text, "&&") {
+		part = strings.TrimSpace(part)
+		var term conditionTerm
+		for _, op := range conditionOps {
+			if left, right, found := strings.Cut(part, op); found {
+				term.register = strings.ToLower(strings.TrimSpace(left))
+				term.op = op
+				right = strings.TrimSpace(right)
+				if symbol, ok := symbols.Lookup(right); ok {
+					term.value = symbol.Address
+					break
+				}
+				value, err := parseHex16(right)
+				if err != nil {
+					return nil, err
+				}
+				term.value = value
+				break
+			}
+		}
+		if term.op == "" {
+			return nil, fmt.Errorf("invalid condition %q", part)
+		}
+		if !isRegisterName(term.register) {
+			return nil, fmt.Errorf("unknown register %q in condition", term.register)
+		}
+		cond.terms = append(cond.terms, term)
+	}
+	return cond, nil
+}
+
+func (c *Condition) Eval(cpu *CPU) bool {
+	for _, term := range c.terms {
+		value, _ := readRegister(cpu, term.register)
+		var ok bool
+		switch term.op {
+		case "==":
+			ok = value == term.value
+		case "!=":
+			ok = value != term.value
+		case "<":
+			ok = value < term.value
+		case "<=":
+			ok = value <= term.value
+		case ">":
+			ok = value > term.value
+		case ">=":
+			ok = value >= term.value
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Condition) String() string {
+	return c.text
+}
+
+func isRegisterName(name string) bool {
+	_, is8 := registers8[name]
+	_, is16 := registers16[name]
+	switch name {
+	case "sp", "pc", "ime", "zf", "nf", "hf", "cf":
+		return true
+	}
+	return is8 || is16
+}
+
+// readRegister returns a register, register pair or flag by name.
+func readRegister(cpu *CPU, name string) (uint16, bool) {
+	if reg, ok := registers8[name]; ok {
+		return uint16(cpu.Registers[reg]), true
+	}
+	if pair, ok := registers16[name]; ok {
+		return uint16(cpu.Registers[pair[0]])<<8 | uint16(cpu.Registers[pair[1]]), true
+	}
+	boolValue := func(b bool) uint16 {
+		if b {
+			return 1
+		}
+		return 0
+	}
+	switch name {
+	case "sp":
+		return cpu.SP, true
+	case "pc":
+		return cpu.PC, true
+	case "ime":
+		return cpu.IME, true
+	case "zf":
+		return boolValue(cpu.Flags.Z()), true
+	case "nf":
+		return boolValue(cpu.Flags.N()), true
+	case "hf":
+		return boolValue(cpu.Flags.H()), true
+	case "cf":
+		return boolValue(cpu.Flags.C()), true
+	}
+	return 0, false
+}
+
+// AttachDebugger attaches a debugger on stdin/stdout if there isn't one yet.
+func (cpu *CPU) AttachDebugger() *Debugger {
+	if cpu.Debugger == nil {
+		cpu.Debugger = NewDebugger(os.Stdin, os.Stdout)
+	}
+	return cpu.Debugger
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// countingProgram increments A forever and stores it to 0xC000 through a
+// subroutine: the CALL is at 0x0002 and the subroutine at 0x0010.
+var countingProgram = []byte{
+	0x3C,             // 0000 INC A
+	0x00,             // 0001 NOP
+	0xCD, 0x10, 0x00, // 0002 CALL 0x0010
+	0x18, 0xF9, // 0005 JR -7
+	0, 0, 0, 0, 0, 0, 0, 0, 0, // padding to 0x0010
+	0xEA, 0x00, 0xC0, // 0010 LD (0xC000), A
+	0xC9, // 0013 RET
+}
+
+func runDebugger(t *testing.T, script string, steps int) (*CPU, string) {
+	cpu := InitCPU()
+	copy(cpu.Memory, countingProgram)
+	var out bytes.Buffer
+	cpu.Debugger = NewDebugger(strings.NewReader(script), &out)
+	cpu.Debugger.Break("start")
+	for i := 0; i < steps && cpu.Debugger != nil; i++ {
+		cpu.Step()
+	}
+	return cpu, out.String()
+}
+
+func TestDebuggerBreakpointAndCondition(t *testing.T) {
+	_, out := runDebugger(t, "break 0010 if a == 3\ncontinue\n", 100)
+	if !strings.Contains(out, "Break (breakpoint 1) at 00:0010") {
+		t.Errorf("expected to stop at the breakpoint, output:\n%s", out)
+	}
+	// the prompt gets EOF after the breakpoint and execution continues, so
+	// just check where the breakpoint stopped
+	if !strings.Contains(out, "AF=0300") {
+		t.Errorf("expected the condition to hold when stopping, output:\n%s", out)
+	}
+}
+
+func TestDebuggerWriteWatchpoint(t *testing.T) {
+	_, out := runDebugger(t, "watch w c000\ncontinue\n", 100)
+	if !strings.Contains(out, "watchpoint 1: write 0x01 at 0xC000") {
+		t.Errorf("expected the write watchpoint to fire, output:\n%s", out)
+	}
+	if !strings.Contains(out, "at 00:0013") {
+		t.Errorf("expected to stop after the writing instruction, output:\n%s", out)
+	}
+}
+
+func TestDebuggerStepping(t *testing.T) {
+	// step onto the CALL and over it, then go round the loop, step into the
+	// CALL and back out
+	_, out := runDebugger(t, "step 2\nnext\nstep 3\nstep\nfinish\n", 100)
+	for _, want := range []string{
+		"Break (step) at 00:0002",
+		"Break (step over) at 00:0005",
+		"Break (step) at 00:0010",
+		"Break (step out) at 00:0005",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestDebuggerEditsRegistersAndMemory(t *testing.T) {
+	cpu, out := runDebugger(t, "set hl c123\nset cf 1\nwrite c000 de ad\nx c000 2\nquit\n", 1)
+	if cpu.GetHL() != 0xC123 || !cpu.Flags.C() {
+		t.Errorf("expected HL=C123 and carry set, got HL=%04X F=%02X", cpu.GetHL(), cpu.Flags.Value())
+	}
+	if cpu.Memory[0xC000] != 0xDE || cpu.Memory[0xC001] != 0xAD {
+		t.Errorf("memory write failed")
+	}
+	if !strings.Contains(out, "C000: DE AD") {
+		t.Errorf("expected memory dump, output:\n%s", out)
+	}
+	if !cpu.Quit {
+		t.Errorf("expected quit to stop the emulator")
+	}
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"github.com/NickSavage/gopherboy/src/sm83"
+)
+
+// instruction is an entry of the decode table: the opcode as the sm83
+// package describes it, with its length and cycles, and the function that
+// executes it. execute is called with PC already past the instruction and
+// its immediate operand, and returns whether the condition of a
+// conditional instruction held.
+type instruction struct {
+	sm83.Opcode
+	execute func(cpu *CPU, operand uint16) bool
+}
+
+var instructions, cbInstructions [256]instruction
+
+// ParseNextOpcode executes the instruction at PC. The opcode and its
+// operand bytes are read in order and PC moves past them, so handlers only
+// do the work of the instruction. Every memory access ticks the rest of the
+// system by an M-cycle; the internal cycles left over at the end are ticked
+// here to make up the count in the table. Handlers tick the ones that come
+// before a memory access themselves.
+func (cpu *CPU) ParseNextOpcode() {
+	start := cpu.Clock
+	opcode := cpu.ReadMemory(cpu.PC)
+	inst := &instructions[opcode]
+	if inst.execute == nil {
+		cpu.lockUp(opcode)
+		return
+	}
+
+	var operand uint16
+	switch inst.Length {
+	case 2:
+		operand = uint16(cpu.ReadMemory(cpu.PC + 1))
+	case 3:
+		operand = uint16(cpu.ReadMemory(cpu.PC+1)) | (uint16(cpu.ReadMemory(cpu.PC+2)) << 8)
+	}
+	if opcode == 0xCB {
+		inst = &cbInstructions[operand]
+	}
+
+	cpu.PC += uint16(inst.Length)
+	cycles := inst.Cycles
+	if inst.execute(cpu, operand) {
+		cycles = inst.BranchCycles
+	}
+	for cpu.Clock-start < cpu.cpuCycles(uint64(cycles)) {
+		cpu.tick()
+	}
+}
+
+// The register field of an opcode, bits 0-2 or 3-5, numbers the operands
+// B, C, D, E, H, L, (HL), A.
+const fieldHL = 6
+
+var fieldRegisters = [8]uint8{RegB, RegC, RegD, RegE, RegH, RegL, 0, RegA}
+
+func (cpu *CPU) readField(field uint8) uint8 {
+	if field == fieldHL {
+		return cpu.ReadMemory(cpu.GetHL())
+	}
+	return cpu.Registers[fieldRegisters[field]]
+}
+
+func (cpu *CPU) writeField(field uint8, value uint8) {
+	if field == fieldHL {
+		cpu.WriteMemory(cpu.GetHL(), value)
+		return
+	}
+	cpu.Registers[fieldRegisters[field]] = value
+}
+
+// The pair field, bits 4-5, numbers BC, DE, HL and SP, or AF instead of SP
+// for PUSH and POP.
+var pairRegisters = [4][2]uint8{{RegB, RegC}, {RegD, RegE}, {RegH, RegL}, {RegA, RegF}}
+
+func (cpu *CPU) readPair(pair uint8) uint16 {
+	if pair == 3 {
+		return cpu.SP
+	}
+	r := pairRegisters[pair]
+	return uint16(cpu.Registers[r[0]])<<8 | uint16(cpu.Registers[r[1]])
+}
+
+func (cpu *CPU) writePair(pair uint8, value uint16) {
+	if pair == 3 {
+		cpu.SP = value
+		return
+	}
+	cpu.LoadImmediateU16(pairRegisters[pair][0], pairRegisters[pair][1], value)
+}
+
+// condition evaluates the condition field, bits 3-4: NZ, Z, NC, C.
+func (cpu *CPU) condition(cc uint8) bool {
+	switch cc {
+	case 0:
+		return !cpu.Flags.Z()
+	case 1:
+		return cpu.Flags.Z()
+	case 2:
+		return !cpu.Flags.C()
+	}
+	return cpu.Flags.C()
+}
+
+// call pushes PC and jumps, after the internal M-cycle that comes first.
+func (cpu *CPU) call(address uint16) {
+	cpu.tick()
+	cpu.SP--
+	cpu.WriteMemory(cpu.SP, uint8(cpu.PC>>8))
+	cpu.SP--
+	cpu.WriteMemory(cpu.SP, uint8(cpu.PC&0xFF))
+	cpu.PC = address
+}
+
+func (cpu *CPU) ret() {
+	low := cpu.ReadMemory(cpu.SP)
+	cpu.SP++
+	high := cpu.ReadMemory(cpu.SP)
+	cpu.SP++
+	cpu.PC = uint16(high)<<8 | uint16(low)
+}
+
+// aluOps are the operations of the 0x80-0xBF block and the u8 forms at
+// 0xC6-0xFE, in the order of bits 3-5.
+var aluOps = [8]func(cpu *CPU, value uint8){
+	(*CPU).AddU8, (*CPU).AdcU8, (*CPU).SubU8, (*CPU).SbcU8,
+	(*CPU).AndU8, (*CPU).XorU8, (*CPU).OrU8, (*CPU).CpU8,
+}
+
+// shiftOps are the CB 0x00-0x3F operations, in the order of bits 3-5. They
+// set the flags and return the result.
+var shiftOps = [8]func(cpu *CPU, value uint8) uint8{
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(RLC(value)) },
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(RRC(value)) },
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(RL(value, cpu.Flags.C())) },
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(RR(value, cpu.Flags.C())) },
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(SLA(value)) },
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(SRA(value)) },
+	(*CPU).Swap,
+	func(cpu *CPU, value uint8) uint8 { return cpu.setFlags(SRL(value)) },
+}
+
+func (cpu *CPU) setFlags(result, flags uint8) uint8 {
+	cpu.Flags.SetValue(flags)
+	return result
+}
+
+func init() {
+	for op := range instructions {
+		instructions[op].Opcode = sm83.Opcodes[op]
+		cbInstructions[op].Opcode = sm83.CBOpcodes[op]
+	}
+	set := func(op int, execute func(cpu *CPU, operand uint16) bool) {
+		instructions[op].execute = execute
+	}
+
+	// blocks decoded from the register, pair and condition fields
+	for op := 0x40; op < 0xC0; op++ {
+		dst, src := uint8(op>>3&7), uint8(op&7)
+		if op < 0x80 {
+			set(op, func(cpu *CPU, _ uint16) bool { // LD r, r
+				cpu.writeField(dst, cpu.readField(src))
+				return false
+			})
+		} else {
+			alu := aluOps[dst]
+			set(op, func(cpu *CPU, _ uint16) bool { // ALU A, r
+				alu(cpu, cpu.readField(src))
+				return false
+			})
+		}
+	}
+	for field := uint8(0); field < 8; field++ {
+		field := field
+		set(0x04|int(field)<<3, func(cpu *CPU, _ uint16) bool { // INC r
+			result, flags := cpu.IncrementU8(cpu.readField(field))
+			cpu.writeField(field, result)
+			cpu.Flags.SetValue(flags)
+			return false
+		})
+		set(0x05|int(field)<<3, func(cpu *CPU, _ uint16) bool { // DEC r
+			result, flags := cpu.DecrementU8(cpu.readField(field))
+			cpu.writeField(field, result)
+			cpu.Flags.SetValue(flags)
+			return false
+		})
+		set(0x06|int(field)<<3, func(cpu *CPU, operand uint16) bool { // LD r, u8
+			cpu.writeField(field, uint8(operand))
+			return false
+		})
+		alu := aluOps[field]
+		set(0xC6|int(field)<<3, func(cpu *CPU, operand uint16) bool { // ALU A, u8
+			alu(cpu, uint8(operand))
+			return false
+		})
+		vector := uint16(field) << 3
+		set(0xC7|int(field)<<3, func(cpu *CPU, _ uint16) bool { // RST
+			cpu.call(vector)
+			return false
+		})
+	}
+	for pair := uint8(0); pair < 4; pair++ {
+		pair := pair
+		set(0x01|int(pair)<<4, func(cpu *CPU, operand uint16) bool { // LD rr, u16
+			cpu.writePair(pair, operand)
+			return false
+		})
+		set(0x03|int(pair)<<4, func(cpu *CPU, _ uint16) bool { // INC rr
+			cpu.writePair(pair, cpu.readPair(pair)+1)
+			return false
+		})
+		set(0x09|int(pair)<<4, func(cpu *CPU, _ uint16) bool { // ADD HL, rr
+			cpu.AddU16Register(RegH, RegL, cpu.readPair(pair))
+			return false
+		})
+		set(0x0B|int(pair)<<4, func(cpu *CPU, _ uint16) bool { // DEC rr
+			cpu.writePair(pair, cpu.readPair(pair)-1)
+			return false
+		})
+		high, low := pairRegisters[pair][0], pairRegisters[pair][1]
+		set(0xC1|int(pair)<<4, func(cpu *CPU, _ uint16) bool { // POP rr
+			cpu.PopU16(high, low)
+			return false
+		})
+		set(0xC5|int(pair)<<4, func(cpu *CPU, _ uint16) bool { // PUSH rr
+			cpu.tick()
+			cpu.PushU16(high, low)
+			return false
+		})
+	}
+	for cc := uint8(0); cc < 4; cc++ {
+		cc := cc
+		set(0x20|int(cc)<<3, func(cpu *CPU, operand uint16) bool { // JR cc, i8
+			if !cpu.condition(cc) {
+				return false
+			}
+			cpu.PC += uint16(int8(operand))
+			return true
+		})
+		set(0xC0|int(cc)<<3, func(cpu *CPU, _ uint16) bool { // RET cc
+			cpu.tick() // checking the condition
+			if !cpu.condition(cc) {
+				return false
+			}
+			cpu.ret()
+			return true
+		})
+		set(0xC2|int(cc)<<3, func(cpu *CPU, operand uint16) bool { // JP cc, u16
+			if !cpu.condition(cc) {
+				return false
+			}
+			cpu.PC = operand
+			return true
+		})
+		set(0xC4|int(cc)<<3, func(cpu *CPU, operand uint16) bool { // CALL cc, u16
+			if !cpu.condition(cc) {
+				return false
+			}
+			cpu.call(operand)
+			return true
+		})
+	}
+
+	// everything else
+	set(0x00, func(cpu *CPU, _ uint16) bool { return false }) // NOP
+
+	set(0x02, func(cpu *CPU, _ uint16) bool { // LD (BC), A
+		cpu.LoadMemory(cpu.GetBC(), RegA)
+		return false
+	})
+	set(0x12, func(cpu *CPU, _ uint16) bool { // LD (DE), A
+		cpu.LoadMemory(cpu.GetDE(), RegA)
+		return false
+	})
+	set(0x22, func(cpu *CPU, _ uint16) bool { // LD (HL+), A
+		cpu.LoadMemory(cpu.GetHL(), RegA)
+		cpu.IncrementU16Register(RegH, RegL)
+		return false
+	})
+	set(0x32, func(cpu *CPU, _ uint16) bool { // LD (HL-), A
+		cpu.LoadMemory(cpu.GetHL(), RegA)
+		cpu.DecrementU16Register(RegH, RegL)
+		return false
+	})
+	set(0x0A, func(cpu *CPU, _ uint16) bool { // LD A, (BC)
+		cpu.LoadFromMemory(RegA, cpu.GetBC())
+		return false
+	})
+	set(0x1A, func(cpu *CPU, _ uint16) bool { // LD A, (DE)
+		cpu.LoadFromMemory(RegA, cpu.GetDE())
+		return false
+	})
+	set(0x2A, func(cpu *CPU, _ uint16) bool { // LD A, (HL+)
+		cpu.LoadFromMemory(RegA, cpu.GetHL())
+		cpu.IncrementU16Register(RegH, RegL)
+		return false
+	})
+	set(0x3A, func(cpu *CPU, _ uint16) bool { // LD A, (HL-)
+		cpu.LoadFromMemory(RegA, cpu.GetHL())
+		cpu.DecrementU16Register(RegH, RegL)
+		return false
+	})
+	set(0x07, func(cpu *CPU, _ uint16) bool { // RLCA
+		cpu.Registers[RegA] = cpu.setFlags(RLC(cpu.Registers[RegA]))
+		cpu.Flags.SetZ(false)
+		return false
+	})
+	set(0x0F, func(cpu *CPU, _ uint16) bool { // RRCA
+		cpu.Registers[RegA] = cpu.setFlags(RRC(cpu.Registers[RegA]))
+		cpu.Flags.SetZ(false)
+		return false
+	})
+	set(0x17, func(cpu *CPU, _ uint16) bool { // RLA
+		cpu.Registers[RegA] = cpu.setFlags(RL(cpu.Registers[RegA], cpu.Flags.C()))
+		cpu.Flags.SetZ(false)
+		return false
+	})
+	set(0x1F, func(cpu *CPU, _ uint16) bool { // RRA
+		cpu.Registers[RegA] = cpu.setFlags(RR(cpu.Registers[RegA], cpu.Flags.C()))
+		cpu.Flags.SetZ(false)
+		return false
+	})
+	set(0x08, func(cpu *CPU, operand uint16) bool { // LD (u16), SP
+		cpu.WriteMemory(operand, uint8(cpu.SP&0xFF))
+		cpu.WriteMemory(operand+1, uint8(cpu.SP>>8))
+		return false
+	})
+	set(0x10, func(cpu *CPU, _ uint16) bool { // STOP
+		cpu.stop()
+		return false
+	})
+	set(0x18, func(cpu *CPU, operand uint16) bool { // JR i8
+		cpu.PC += uint16(int8(operand))
+		return false
+	})
+	set(0x27, func(cpu *CPU, _ uint16) bool { // DAA
+		a := cpu.Registers[RegA]
+		var adjust uint8
+		if cpu.Flags.H() || (!cpu.Flags.N() && (a&0x0F) > 9) {
+			adjust |= 0x06
+		}
+		if cpu.Flags.C() || (!cpu.Flags.N() && a > 0x99) {
+			adjust |= 0x60
+			cpu.Flags.SetC(true)
+		} else {
+			cpu.Flags.SetC(false)
+		}
+		if cpu.Flags.N() {
+			cpu.Registers[RegA] -= adjust
+		} else {
+			cpu.Registers[RegA] += adjust
+		}
+		cpu.Flags.SetZ(cpu.Registers[RegA] == 0)
+		cpu.Flags.SetH(false)
+		return false
+	})
+	set(0x2F, func(cpu *CPU, _ uint16) bool { // CPL
+		cpu.Registers[RegA] = ^cpu.Registers[RegA]
+		cpu.Flags.SetN(true)
+		cpu.Flags.SetH(true)
+		return false
+	})
+	set(0x37, func(cpu *CPU, _ uint16) bool { // SCF
+		cpu.Flags.SetN(false)
+		cpu.Flags.SetH(false)
+		cpu.Flags.SetC(true)
+		return false
+	})
+	set(0x3F, func(cpu *CPU, _ uint16) bool { // CCF
+		cpu.Flags.SetN(false)
+		cpu.Flags.SetH(false)
+		cpu.Flags.SetC(!cpu.Flags.C())
+		return false
+	})
+	set(0x76, func(cpu *CPU, _ uint16) bool { // HALT
+		cpu.Halt()
+		return false
+	})
+	set(0xC3, func(cpu *CPU, operand uint16) bool { // JP u16
+		cpu.PC = operand
+		return false
+	})
+	set(0xC9, func(cpu *CPU, _ uint16) bool { // RET
+		cpu.ret()
+		return false
+	})
+	set(0xD9, func(cpu *CPU, _ uint16) bool { // RETI
+		cpu.ret()
+		cpu.IME = 1
+		return false
+	})
+	set(0xCD, func(cpu *CPU, operand uint16) bool { // CALL u16
+		cpu.call(operand)
+		return false
+	})
+	set(0xCB, func(cpu *CPU, _ uint16) bool { return false }) // decoded by ParseNextOpcode
+
+	set(0xE0, func(cpu *CPU, operand uint16) bool { // LD (0xFF00 + u8), A
+		address := 0xFF00 + operand
+		cpu.WriteMemory(address, cpu.Registers[RegA])
+		if address == 0xFF50 {
+			copy(cpu.Memory[0x0000:0x0150], cpu.ROM[0x0000:0x0150])
+		}
+		return false
+	})
+	set(0xF0, func(cpu *CPU, operand uint16) bool { // LD A, (0xFF00 + u8)
+		cpu.LoadFromMemory(RegA, 0xFF00+operand)
+		return false
+	})
+	set(0xE2, func(cpu *CPU, _ uint16) bool { // LD (0xFF00 + C), A
+		cpu.LoadMemory(0xFF00+uint16(cpu.Registers[RegC]), RegA)
+		return false
+	})
+	set(0xF2, func(cpu *CPU, _ uint16) bool { // LD A, (0xFF00 + C)
+		cpu.LoadFromMemory(RegA, 0xFF00+uint16(cpu.Registers[RegC]))
+		return false
+	})
+	set(0xE8, func(cpu *CPU, operand uint16) bool { // ADD SP, s8
+		offset := uint16(int8(operand))
+		cpu.Flags.SetC((cpu.SP&0xFF)+(offset&0xFF) > 0xFF)
+		cpu.Flags.SetH((cpu.SP&0xF)+(offset&0xF) > 0xF)
+		cpu.SP += offset
+		cpu.Flags.SetN(false)
+		cpu.Flags.SetZ(false)
+		return false
+	})
+	set(0xF8, func(cpu *CPU, operand uint16) bool { // LD HL, SP + s8
+		offset := uint16(int8(operand))
+		cpu.LoadImmediateU16(RegH, RegL, cpu.SP+offset)
+		// the flags come from adding the low bytes
+		cpu.Flags.SetH((cpu.SP&0xF)+(offset&0xF) > 0xF)
+		cpu.Flags.SetC((cpu.SP&0xFF)+(offset&0xFF) > 0xFF)
+		cpu.Flags.SetZ(false)
+		cpu.Flags.SetN(false)
+		return false
+	})
+	set(0xE9, func(cpu *CPU, _ uint16) bool { // JP HL
+		cpu.PC = cpu.GetHL()
+		return false
+	})
+	set(0xF9, func(cpu *CPU, _ uint16) bool { // LD SP, HL
+		cpu.SP = cpu.GetHL()
+		return false
+	})
+	set(0xEA, func(cpu *CPU, operand uint16) bool { // LD (u16), A
+		cpu.LoadMemory(operand, RegA)
+		return false
+	})
+	set(0xFA, func(cpu *CPU, operand uint16) bool { // LD A, (u16)
+		cpu.LoadFromMemory(RegA, operand)
+		return false
+	})
+	set(0xF3, func(cpu *CPU, _ uint16) bool { // DI
+		cpu.IME = 0
+		return false
+	})
+	set(0xFB, func(cpu *CPU, _ uint16) bool { // EI
+		cpu.IME = 1
+		return false
+	})
+
+	// CB prefixed, decoded from the operation, bit and register fields
+	for op := 0; op < 256; op++ {
+		bit, field := uint8(op>>3&7), uint8(op&7)
+		var execute func(cpu *CPU, _ uint16) bool
+		switch op >> 6 {
+		case 0:
+			shift := shiftOps[bit]
+			execute = func(cpu *CPU, _ uint16) bool {
+				cpu.writeField(field, shift(cpu, cpu.readField(field)))
+				return false
+			}
+		case 1:
+			execute = func(cpu *CPU, _ uint16) bool {
+				cpu.Bit(bit, cpu.readField(field))
+				return false
+			}
+		case 2:
+			execute = func(cpu *CPU, _ uint16) bool {
+				cpu.writeField(field, Res(bit, cpu.readField(field)))
+				return false
+			}
+		case 3:
+			execute = func(cpu *CPU, _ uint16) bool {
+				cpu.writeField(field, Set(bit, cpu.readField(field)))
+				return false
+			}
+		}
+		cbInstructions[op].execute = execute
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// BenchmarkDecoder times the decode table on the instructions a bundled ROM
+// runs.
+func BenchmarkDecoder(b *testing.B) {
+	cpu := InitCPU()
+	if err := LoadROM(cpu, filepath.Join("tests", "roms", "grid-collision.gb")); err != nil {
+		b.Fatal(err)
+	}
+	cpu.ResetPostBoot()
+	start := *cpu
+	memory := append([]uint8(nil), cpu.Memory...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// without interrupts the ROM eventually runs off into data,
+		// so start over every frame's worth of instructions
+		if i%20000 == 0 {
+			registers := cpu.Registers
+			*cpu = start
+			copy(registers, start.Registers)
+			cpu.Registers = registers
+			copy(cpu.Memory, memory)
+		}
+		cpu.ParseNextOpcode()
+		cpu.Halted = false
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/NickSavage/gopherboy/src/sm83"
+)
+
+// Disassemble decodes the instruction at an address as the CPU sees it. It
+// reads memory directly so it doesn't trigger watchpoints.
+func (cpu *CPU) Disassemble(address uint16) sm83.Instruction {
+	return sm83.Disassemble(func(a uint16) uint8 { return cpu.Memory[a] }, address)
+}
+
+// formatInstruction gives the listing line used by the debugger and -disasm,
+// e.g. "01:4000  C3 50 01  JP $0150", with addresses named by label.
+func formatInstruction(bank int, inst sm83.Instruction, label func(uint16) (string, bool)) string {
+	bytes := make([]string, len(inst.Bytes))
+	for i, b := range inst.Bytes {
+		bytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return fmt.Sprintf("%02X:%04X  %-9s %s", bank, inst.Address, strings.Join(bytes, " "), inst.Symbolize(label))
+}
+
+// romOffset returns where a bank qualified address lives in the ROM file.
+// Bank 0 is 0x0000-0x3FFF and every other bank is mapped at 0x4000-0x7FFF.
+func romOffset(bank int, address uint16) (int, error) {
+	if address >= 0x8000 {
+		return 0, fmt.Errorf("address %04X is outside ROM", address)
+	}
+	if bank == 0 || (bank < 0 && address < 0x4000) {
+		if address >= 0x4000 {
+			return 0, fmt.Errorf("address %04X is not in bank 0", address)
+		}
+		return int(address), nil
+	}
+	if bank < 0 {
+		bank = 1
+	}
+	if address < 0x4000 {
+		return 0, fmt.Errorf("address %04X is in bank 0, not bank %d", address, bank)
+	}
+	return bank*0x4000 + int(address-0x4000), nil
+}
+
+// DisassembleROM lists the ROM between two bank qualified addresses or
+// labels, e.g. "0150-01FF", "02:4000-02:7FFF" or "Main-VBlankHandler". An
+// empty range or "all" lists the whole ROM. The listing reads the ROM file,
+// not memory, so it works before the boot ROM has run and covers banks that
+// aren't mapped in.
+func (cpu *CPU) DisassembleROM(w io.Writer, spec string) error {
+	start, end := 0, len(cpu.ROM)-1
+	if spec != "" && spec != "all" {
+		first, last, found := strings.Cut(spec, "-")
+		bank, address, err := cpu.ParseLocation(first)
+		if err != nil {
+			return err
+		}
+		if start, err = romOffset(bank, address); err != nil {
+			return err
+		}
+		end = start
+		if found {
+			lastBank, lastAddress, err := cpu.ParseLocation(last)
+			if err != nil {
+				return err
+			}
+			if lastBank < 0 {
+				lastBank = bank
+			}
+			if end, err = romOffset(lastBank, lastAddress); err != nil {
+				return err
+			}
+		}
+		if end < start {
+			return fmt.Errorf("empty range %s", spec)
+		}
+	}
+	if start >= len(cpu.ROM) {
+		return fmt.Errorf("range %s is past the end of the %d byte ROM", spec, len(cpu.ROM))
+	}
+	if end >= len(cpu.ROM) {
+		end = len(cpu.ROM) - 1
+	}
+
+	for offset := start; offset <= end; {
+		bank := offset / 0x4000
+		address := uint16(offset)
+		if bank > 0 {
+			address = uint16(0x4000 + offset%0x4000)
+		}
+		// labels are looked up as if this bank was mapped in
+		label := func(a uint16) (string, bool) {
+			if a >= 0x4000 && a < 0x8000 {
+				return cpu.Symbols.Label(bank, a)
+			}
+			return cpu.Symbols.Label(cpu.BankAt(a), a)
+		}
+		if name, ok := label(address); ok {
+			if _, err := fmt.Fprintf(w, "%s:\n", name); err != nil {
+				return err
+			}
+		}
+		inst := sm83.DisassembleBytes(cpu.ROM[offset:], address)
+		if _, err := fmt.Fprintln(w, formatInstruction(bank, inst, label)); err != nil {
+			return err
+		}
+		offset += inst.Length()
+	}
+	return nil
+}
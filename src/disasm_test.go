@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/NickSavage/gopherboy/src/sm83"
+)
+
+// changesPC reports whether an instruction sets PC itself, so its length
+// can't be checked by watching PC advance.
+func changesPC(mnemonic string) bool {
+	for _, prefix := range []string{"JP", "JR", "CALL", "RET", "RST", "HALT", "STOP"} {
+		if strings.HasPrefix(mnemonic, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// TestDisassemblerMatchesInterpreter executes every opcode and checks the
+// interpreter consumes as many bytes as the disassembler's table says.
+func TestDisassemblerMatchesInterpreter(t *testing.T) {
+	const start = 0xC100
+	run := func(code ...uint8) uint16 {
+		cpu := InitCPU()
+		cpu.PC = start
+		cpu.SP = 0xDFF0
+		cpu.Registers[RegH] = 0xC8
+		copy(cpu.Memory[start:], code)
+		cpu.ParseNextOpcode()
+		return cpu.PC - start
+	}
+
+	for opcode, op := range sm83.Opcodes {
+		if !op.Valid() || changesPC(op.Mnemonic) || opcode == 0xCB {
+			continue
+		}
+		if length := run(uint8(opcode), 0x01, 0xC8); int(length) != op.Length {
+			t.Errorf("%02X %s: interpreter advanced PC by %d, table says %d", opcode, op.Mnemonic, length, op.Length)
+		}
+	}
+	for opcode, op := range sm83.CBOpcodes {
+		if length := run(0xCB, uint8(opcode)); int(length) != op.Length {
+			t.Errorf("CB %02X %s: interpreter advanced PC by %d, table says %d", opcode, op.Mnemonic, length, op.Length)
+		}
+	}
+}
+
+func TestDisassembleROMRange(t *testing.T) {
+	cpu := InitCPU()
+	cpu.ROM = make([]uint8, 0x10000)
+	copy(cpu.ROM[0x0150:], []uint8{0x3E, 0x01, 0xEA, 0x00, 0xC0, 0x18, 0xF9})
+	copy(cpu.ROM[0x8000:], []uint8{0xCB, 0x7C, 0xC9})
+
+	var out strings.Builder
+	if err := cpu.DisassembleROM(&out, "0150-0155"); err != nil {
+		t.Fatalf("DisassembleROM: %v", err)
+	}
+	expected := "00:0150  3E 01     LD A, $01\n" +
+		"00:0152  EA 00 C0  LD ($C000), A\n" +
+		"00:0155  18 F9     JR $0150\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := cpu.DisassembleROM(&out, "02:4000-4002"); err != nil {
+		t.Fatalf("DisassembleROM: %v", err)
+	}
+	expected = "02:4000  CB 7C     BIT 7, H\n" +
+		"02:4002  C9        RET\n"
+	if out.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	if err := cpu.DisassembleROM(&out, "00:4000"); err == nil {
+		t.Errorf("expected an error for an address outside bank 0")
+	}
+}
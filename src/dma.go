@@ -0,0 +1,18 @@
+package main
+
+// DMATransferCycles is how long an OAM DMA transfer takes: a byte per
+// M-cycle for the 160 bytes of OAM.
+const DMATransferCycles = 160 * 4
+
+// startDMA is called after a write to DMA (0xFF46). The transfer is done in
+// one go when it completes, with the EventDMA.
+func (cpu *CPU) startDMA(value uint8) {
+	cpu.DMAActive = true
+	cpu.DMASourceBase = uint16(value) << 8
+	cpu.Events.Schedule(EventDMA, cpu.Clock+cpu.cpuCycles(DMATransferCycles))
+}
+
+func (cpu *CPU) dmaEvent() {
+	copy(cpu.Memory[0xFE00:0xFEA0], cpu.Memory[cpu.DMASourceBase:cpu.DMASourceBase+0xA0])
+	cpu.DMAActive = false
+}
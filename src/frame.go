@@ -0,0 +1,241 @@
+package main
+
+import (
+	"runtime"
+	"time"
+)
+
+// Game Boy timing constants. All cycle counts are in T-cycles (the 4.194304 MHz
+// master clock), matching the units cpu.Clock is advanced in.
+const (
+	CPUFrequency   = 4194304
+	CyclesPerLine  = 456
+	LinesPerFrame  = 154
+	VBlankLine     = 144
+	CyclesPerFrame = CyclesPerLine * LinesPerFrame // 70224
+
+	// the first two PPU modes of a visible line; HBlank is the rest of it
+	OAMScanCycles  = 80
+	TransferCycles = 172
+)
+
+// FrameRate is the native DMG refresh rate, roughly 59.73 Hz.
+const FrameRate = float64(CPUFrequency) / CyclesPerFrame
+
+// Step executes a single instruction (or services an interrupt) and returns
+// the number of T-cycles it took. The rest of the system is advanced along
+// with every M-cycle of the instruction, see tick.
+func (cpu *CPU) Step() int {
+	if cpu.Stopped {
+		// the clock is stopped until a button is pressed, see stop
+		if cpu.GDB != nil {
+			cpu.GDB.Poll(cpu)
+		}
+		return 0
+	}
+	before := cpu.Clock
+
+	if cpu.Lockup == nil {
+		cpu.HandleInterrupts()
+	}
+	if !cpu.Halted && cpu.Lockup == nil {
+		if cpu.Sanitizer != nil {
+			cpu.Sanitizer.Execute(cpu)
+		}
+		if cpu.Conventions != nil {
+			cpu.Conventions.Check(cpu)
+		}
+		if cpu.Debugger != nil {
+			cpu.Debugger.Check(cpu)
+		}
+		if cpu.GDB != nil {
+			cpu.GDB.Check(cpu)
+		}
+		if cpu.Tracer != nil {
+			cpu.Tracer.Trace(cpu)
+		}
+		cpu.History.Record(cpu.PC)
+		cpu.ParseNextOpcode()
+	} else if cpu.GDB != nil {
+		cpu.GDB.Poll(cpu)
+	}
+
+	if cpu.Clock == before {
+		// a halted CPU waits for an interrupt, and nothing can request one
+		// before the next event; a locked up one waits forever
+		if next := cpu.Events.Next(); next != NoEvent {
+			cpu.Clock = max(cpu.Clock, next-cpu.cpuCycles(4))
+		}
+		cpu.tick()
+	}
+	return int(cpu.Clock - before)
+}
+
+// tick advances everything but the CPU by one M-cycle (4 T-cycles). The CPU
+// calls it for each memory access and internal cycle of an instruction, so
+// the timer, DMA, serial port and LCD see a write on the cycle it happens
+// rather than after the instruction. They only do anything when one of
+// their events is due.
+func (cpu *CPU) tick() {
+	cpu.Clock += cpu.cpuCycles(4)
+	if cpu.Events.next <= cpu.Clock {
+		cpu.runEvents()
+	}
+}
+
+// cpuCycles converts CPU T-cycles to Clock time. In CGB double speed mode
+// the CPU, and the timer, serial port and OAM DMA it clocks, run twice as
+// fast as the LCD, whose speed Clock keeps.
+func (cpu *CPU) cpuCycles(cycles uint64) uint64 {
+	if cpu.DoubleSpeed {
+		return cycles / 2
+	}
+	return cycles
+}
+
+// FrameCycles returns the T-cycles elapsed in the current frame.
+func (cpu *CPU) FrameCycles() int {
+	return int(cpu.Clock - cpu.FrameStart)
+}
+
+// lcdEvent moves the PPU to the mode that starts at time at, keeping LY and
+// the STAT mode and coincidence bits in step and requesting VBlank when
+// line 144 is reached and copying a block of any HBlank DMA (see hdma.go)
+// as HBlank starts, then schedules the next mode change. Each visible
+// line is OAM scan (mode 2), pixel transfer (mode 3) and HBlank (mode 0);
+// the VBlank lines are mode 1 throughout.
+func (cpu *CPU) lcdEvent(at uint64) {
+	position := int(at - cpu.FrameStart)
+	line, dot := position/CyclesPerLine%LinesPerFrame, position%CyclesPerLine
+
+	var mode uint8
+	next := CyclesPerLine
+	switch {
+	case line >= VBlankLine:
+		mode = 1
+		if line == VBlankLine && dot == 0 {
+			cpu.RequestVBlank()
+		}
+	case dot < OAMScanCycles:
+		mode, next = 2, OAMScanCycles
+	case dot < OAMScanCycles+TransferCycles:
+		mode, next = 3, OAMScanCycles+TransferCycles
+	default:
+		cpu.hblankDMA()
+	}
+
+	cpu.Memory[0xFF44] = uint8(line)
+	stat := cpu.Memory[0xFF41]&^0x07 | mode
+	if cpu.Memory[0xFF44] == cpu.Memory[0xFF45] {
+		stat |= 0x04
+	}
+	cpu.Memory[0xFF41] = stat
+	cpu.Events.Schedule(EventLCD, at+uint64(next-dot))
+}
+
+// writeSTAT stores a write to STAT, whose mode and LY=LYC bits are read
+// only. On the DMG, the write enables every STAT interrupt source for a
+// moment, so a STAT interrupt is requested if the LCD is in HBlank or
+// VBlank or LY=LYC. Road Rash and Zerd no Densetsu depend on it.
+func (cpu *CPU) writeSTAT(value uint8) {
+	stat := cpu.Memory[0xFF41]
+	cpu.Memory[0xFF41] = 0x80 | value&0x78 | stat&0x07
+	if !cpu.Model.IsCGB() && (stat&0x03 < 2 || stat&0x04 != 0) {
+		cpu.RequestStatInterrupt()
+	}
+}
+
+// RunFrame runs the CPU for one full frame (70224 T-cycles). It stops early
+// and returns an error if a test ROM failure signature is detected, or the
+// Lockup if the CPU locks up under IllegalOpcodeError. While the CPU is
+// stopped, no time passes and the frame is left unfinished: it carries on
+// from where it was once a button is pressed.
+func (cpu *CPU) RunFrame() error {
+	for cpu.FrameCycles() < CyclesPerFrame {
+		if cpu.Stopped {
+			return nil
+		}
+		cpu.Step()
+		if err := cpu.CheckError(); err != nil {
+			return err
+		}
+		if cpu.Lockup != nil && cpu.IllegalOpcodes == IllegalOpcodeError {
+			return cpu.Lockup
+		}
+	}
+	cpu.endFrame()
+	return nil
+}
+
+// endFrame carries the cycles past the end of a frame over into the next.
+func (cpu *CPU) endFrame() {
+	cpu.FrameStart += CyclesPerFrame
+	cpu.Frames++
+}
+
+// FrameLimiter throttles the main loop to a target frame rate using the
+// monotonic clock, and keeps track of the rate actually achieved.
+type FrameLimiter struct {
+	Target time.Duration
+
+	next time.Time
+
+	windowStart  time.Time
+	windowFrames int
+	fps          float64
+}
+
+func NewFrameLimiter(fps float64) *FrameLimiter {
+	now := time.Now()
+	l := &FrameLimiter{
+		next:        now,
+		windowStart: now,
+	}
+	l.SetRate(fps)
+	return l
+}
+
+// SetRate changes the target frame rate, e.g. when the speed multiplier changes.
+func (l *FrameLimiter) SetRate(fps float64) {
+	l.Target = time.Duration(float64(time.Second) / fps)
+}
+
+// Wait blocks until the next frame is due. Sleeping is only accurate to about
+// a millisecond, so the last stretch is spent yielding in a loop instead.
+func (l *FrameLimiter) Wait() {
+	l.next = l.next.Add(l.Target)
+
+	now := time.Now()
+	if l.next.Before(now) {
+		// we've fallen more than a frame behind (slow host, window dragged,
+		// fast-forward just released), so don't try to catch up with a burst
+		// of frames
+		if now.Sub(l.next) > l.Target {
+			l.next = now
+		}
+		return
+	}
+
+	if remaining := l.next.Sub(now); remaining > 2*time.Millisecond {
+		time.Sleep(remaining - 2*time.Millisecond)
+	}
+	for time.Now().Before(l.next) {
+		runtime.Gosched()
+	}
+}
+
+// Tally counts an emulated frame and recomputes the achieved frame rate
+// about once a second.
+func (l *FrameLimiter) Tally() {
+	l.windowFrames++
+	if elapsed := time.Since(l.windowStart); elapsed >= time.Second {
+		l.fps = float64(l.windowFrames) / elapsed.Seconds()
+		l.windowFrames = 0
+		l.windowStart = time.Now()
+	}
+}
+
+// FPS returns the frame rate measured over the last second.
+func (l *FrameLimiter) FPS() float64 {
+	return l.fps
+}
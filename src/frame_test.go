@@ -0,0 +1,107 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// BenchmarkRunFrame runs each bundled ROM headless, a frame per iteration.
+func BenchmarkRunFrame(b *testing.B) {
+	roms, err := filepath.Glob(filepath.Join("tests", "roms", "*.gb"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, rom := range roms {
+		name := strings.TrimSuffix(filepath.Base(rom), ".gb")
+		b.Run(name, func(b *testing.B) {
+			cpu := InitCPU()
+			if err := LoadROM(cpu, rom); err != nil {
+				b.Fatal(err)
+			}
+			cpu.ResetPostBoot()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// CheckError only looks for a failure signature; carry on
+				// regardless, a stuck ROM is still worth timing
+				cpu.RunFrame()
+			}
+			b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "frames/s")
+		})
+	}
+}
+
+func TestRunFrameCarriesCycles(t *testing.T) {
+	cpu := InitCPU()
+	// a 20 T-cycle loop, which doesn't divide a frame, so frames end part
+	// way through an instruction
+	copy(cpu.Memory, []byte{
+		0x7E,       // 0000 LD A, (HL)
+		0x18, 0xFD, // 0001 JR -3
+	})
+	carried := false
+	for frame := uint64(1); frame <= 5; frame++ {
+		if err := cpu.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if cpu.Frames != frame || cpu.FrameStart != frame*CyclesPerFrame {
+			t.Fatalf("frame %d: %d frames done, frame started at %d", frame, cpu.Frames, cpu.FrameStart)
+		}
+		// the overshoot counts towards the next frame instead of being lost
+		over := cpu.FrameCycles()
+		if over < 0 || over >= 20 || cpu.Clock != cpu.FrameStart+uint64(over) {
+			t.Errorf("frame %d: ran %d cycles into the next frame", frame, over)
+		}
+		if ly := cpu.Memory[0xFF44]; ly != 0 {
+			t.Errorf("frame %d: LY is %d at the start of the next frame", frame, ly)
+		}
+		carried = carried || over > 0
+	}
+	if !carried {
+		t.Errorf("no frame ended part way through an instruction")
+	}
+}
+
+func TestFrameLimiterSetRate(t *testing.T) {
+	l := NewFrameLimiter(60)
+	if l.Target != time.Second/60 {
+		t.Errorf("target %v at 60 fps, expected %v", l.Target, time.Second/60)
+	}
+	l.SetRate(120)
+	if l.Target != time.Second/120 {
+		t.Errorf("target %v at 120 fps, expected %v", l.Target, time.Second/120)
+	}
+}
+
+func TestFrameLimiterWait(t *testing.T) {
+	start := time.Now()
+	l := NewFrameLimiter(50)
+	l.Wait()
+	if elapsed := time.Since(start); elapsed < l.Target {
+		t.Errorf("first frame took %v, expected at least %v", elapsed, l.Target)
+	}
+
+	// less than a frame behind: no wait, and the lost time is made up
+	before := time.Now()
+	l.next = before.Add(-l.Target * 3 / 2)
+	l.Wait()
+	if elapsed := time.Since(before); elapsed >= l.Target/2 {
+		t.Errorf("waited %v while behind", elapsed)
+	}
+	if !l.next.Before(before) {
+		t.Errorf("gave up a frame that was only half a frame late")
+	}
+
+	// far behind: no wait, and no burst of frames to catch up
+	before = time.Now()
+	l.next = before.Add(-10 * l.Target)
+	l.Wait()
+	if l.next.Before(before) {
+		t.Errorf("still %v behind after falling ten frames behind", before.Sub(l.next))
+	}
+	l.Wait()
+	if elapsed := time.Since(before); elapsed < l.Target {
+		t.Errorf("frame after catching up took %v, expected at least %v", elapsed, l.Target)
+	}
+}
@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// GDBServer lets an external debugger attach over the GDB Remote Serial
+// Protocol. Like the command-line debugger it is consulted before every
+// instruction (cpu.GDB), polled while the CPU isn't running any, and holds
+// the emulator while the target is stopped, serving requests from the
+// connection until it is told to continue.
+//
+// Registers are exposed as six 16-bit little endian values in the order
+// af, bc, de, hl, sp, pc, which is also described by the target.xml sent
+// to the debugger.
+type GDBServer struct {
+	listener net.Listener
+
+	mu       sync.Mutex // guards conn and writes to it
+	conn     net.Conn
+	incoming chan net.Conn
+	noAck    atomic.Bool
+	packets  chan string // nil while no debugger is attached
+
+	interrupt atomic.Bool // set by Ctrl-C, a new connection or a disconnect
+	attached  bool        // stop without a stop reply, the debugger asks with '?'
+
+	breakpoints map[uint16]bool
+	watchpoints []*Watchpoint
+
+	stepping bool
+	lockedUp bool   // the lock-up has been reported
+	pending  string // stop reply for a watchpoint hit in the last instruction
+	last     string // last stop reply, for '?'
+	serving  bool   // handling requests; accesses don't trigger watchpoints
+}
+
+const gdbTargetXML = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.gopherboy.sm83">
+    <reg name="af" bitsize="16" type="int"/>
+    <reg name="bc" bitsize="16" type="int"/>
+    <reg name="de" bitsize="16" type="int"/>
+    <reg name="hl" bitsize="16" type="data_ptr"/>
+    <reg name="sp" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+  </feature>
+</target>`
+
+func NewGDBServer() *GDBServer {
+	return &GDBServer{
+		breakpoints: map[uint16]bool{},
+		incoming:    make(chan net.Conn, 1),
+		last:        "S05",
+	}
+}
+
+// Listen accepts debugger connections on addr, e.g. "localhost:2345". Only
+// one debugger is served at a time; a new connection stops the target.
+func (s *GDBServer) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening for gdb: %v", err)
+	}
+	s.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.accept(conn)
+		}
+	}()
+	return nil
+}
+
+// accept hands a new connection to the emulator, which picks it up before
+// the next instruction.
+func (s *GDBServer) accept(conn net.Conn) {
+	s.mu.Lock()
+	busy := s.conn != nil
+	if !busy {
+		s.conn = conn
+	}
+	s.mu.Unlock()
+	if busy {
+		log.Printf("gdb connection from %s refused, a debugger is already attached", conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	log.Printf("gdb connected from %s", conn.RemoteAddr())
+	s.incoming <- conn
+	s.interrupt.Store(true)
+}
+
+func (s *GDBServer) Close() error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		return s.conn.Close()
+	}
+	return nil
+}
+
+// start serves a new connection. It runs on the emulator's goroutine, and
+// the target stops as soon as the debugger is attached.
+func (s *GDBServer) start(conn net.Conn) {
+	s.noAck.Store(false)
+	s.packets = make(chan string, 16)
+	s.attached = true
+	go s.read(conn, s.packets)
+}
+
+// read splits the byte stream into packets, acknowledging each one, and
+// turns a bare Ctrl-C into an interrupt request.
+func (s *GDBServer) read(conn net.Conn, packets chan<- string) {
+	defer func() {
+		close(packets)
+		s.interrupt.Store(true)
+	}()
+	r := bufio.NewReader(conn)
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		switch c {
+		case 0x03:
+			s.interrupt.Store(true)
+		case '$':
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			var sum [2]byte
+			if _, err := r.Read(sum[:1]); err != nil {
+				return
+			}
+			if _, err := r.Read(sum[1:]); err != nil {
+				return
+			}
+			data = strings.TrimSuffix(data, "#")
+			if checksum, err := strconv.ParseUint(string(sum[:]), 16, 8); err != nil || uint8(checksum) != gdbChecksum(data) {
+				s.writeRaw("-")
+				continue
+			}
+			if !s.noAck.Load() {
+				s.writeRaw("+")
+			}
+			packets <- data
+		}
+	}
+}
+
+func gdbChecksum(data string) uint8 {
+	var sum uint8
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+	return sum
+}
+
+func (s *GDBServer) writeRaw(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Write([]byte(data))
+	}
+}
+
+func (s *GDBServer) send(data string) {
+	s.writeRaw(fmt.Sprintf("$%s#%02x", data, gdbChecksum(data)))
+}
+
+// poll picks up a new connection, and reports whether the target has been
+// asked to stop since it was last called.
+func (s *GDBServer) poll() bool {
+	interrupted := s.interrupt.Swap(false)
+	if interrupted && s.packets == nil {
+		select {
+		case conn := <-s.incoming:
+			s.start(conn)
+		default:
+		}
+	}
+	return interrupted
+}
+
+// Check is called before every instruction and serves the debugger if the
+// target should stop there.
+func (s *GDBServer) Check(cpu *CPU) {
+	interrupted := s.poll()
+	s.lockedUp = false
+	if s.packets == nil {
+		return
+	}
+
+	reply := s.pending
+	s.pending = ""
+	switch {
+	case s.attached:
+		reply = "S05"
+	case interrupted:
+		reply = "S02"
+	case reply != "":
+	case s.stepping:
+		reply = "S05"
+	case s.breakpoints[cpu.PC]:
+		reply = "T05swbreak:;"
+	default:
+		for _, wp := range s.watchpoints {
+			if wp.Kind&WatchExecute != 0 && cpu.PC >= wp.Start && cpu.PC <= wp.End {
+				reply = "T05hwbreak:;"
+			}
+		}
+	}
+	s.stop(cpu, reply)
+}
+
+// Poll is called while the CPU isn't running instructions, because it is
+// locked up, stopped, halted or paused, and once a frame. Check isn't
+// reached then, so this is what answers a debugger attaching or a Ctrl-C.
+// A lock-up stops the target once, and so does a step that has no
+// instruction to run.
+func (s *GDBServer) Poll(cpu *CPU) {
+	interrupted := s.poll()
+	if s.packets == nil {
+		return
+	}
+
+	idle := cpu.Lockup != nil || cpu.Stopped || cpu.Halted
+	reply := ""
+	switch {
+	case s.attached:
+		reply = "S05"
+	case interrupted:
+		reply = "S02"
+	case s.stepping && idle:
+		reply = "S05"
+	case cpu.Lockup != nil && !s.lockedUp:
+		reply = "S05"
+	}
+	s.stop(cpu, reply)
+}
+
+// stop sends a stop reply, unless there is none, and serves the debugger
+// until it resumes the target.
+func (s *GDBServer) stop(cpu *CPU, reply string) {
+	if reply == "" {
+		return
+	}
+
+	s.lockedUp = cpu.Lockup != nil
+	s.stepping = false
+	s.last = reply
+	if s.attached {
+		s.attached = false
+	} else {
+		s.send(reply)
+	}
+	s.serve(cpu)
+}
+
+// Attached reports whether a debugger is connected.
+func (s *GDBServer) Attached() bool {
+	return s.packets != nil
+}
+
+// Break stops the target before the current instruction, as if it had hit
+// a breakpoint.
+func (s *GDBServer) Break() {
+	s.pending = "T05swbreak:;"
+}
+
+// Access is called by ReadMemory and WriteMemory. A watchpoint hit stops
+// the target once the current instruction has finished.
+func (s *GDBServer) Access(address uint16, write bool) {
+	if s.serving {
+		return
+	}
+	kind, name := WatchRead, "rwatch"
+	if write {
+		kind, name = WatchWrite, "watch"
+	}
+	for _, wp := range s.watchpoints {
+		if wp.Kind&kind != 0 && address >= wp.Start && address <= wp.End {
+			if wp.Kind == WatchRead|WatchWrite {
+				name = "awatch"
+			}
+			s.pending = fmt.Sprintf("T05%s:%04x;", name, address)
+			return
+		}
+	}
+}
+
+// serve handles requests until the debugger resumes the target or goes
+// away.
+func (s *GDBServer) serve(cpu *CPU) {
+	s.serving = true
+	defer func() { s.serving = false }()
+
+	for packet := range s.packets {
+		reply, resume := s.handle(cpu, packet)
+		if reply != "" || !resume {
+			s.send(reply)
+		}
+		if resume {
+			return
+		}
+	}
+
+	// the connection went away: drop everything and carry on running
+	log.Printf("gdb disconnected")
+	s.mu.Lock()
+	s.conn.Close()
+	s.conn = nil
+	s.mu.Unlock()
+	s.packets = nil
+	s.breakpoints = map[uint16]bool{}
+	s.watchpoints = nil
+}
+
+// handle answers one request, and reports whether the target should resume.
+func (s *GDBServer) handle(cpu *CPU, packet string) (string, bool) {
+	if packet == "" {
+		return "", false
+	}
+	args := packet[1:]
+	switch packet[0] {
+	case '?':
+		return s.last, false
+	case 'g':
+		return s.readRegisters(cpu), false
+	case 'G':
+		return s.writeRegisters(cpu, args), false
+	case 'p':
+		n, err := strconv.ParseUint(args, 16, 8)
+		if err != nil || n >= 6 {
+			return "E01", false
+		}
+		return s.readRegisters(cpu)[n*4 : n*4+4], false
+	case 'P':
+		reg, value, _ := strings.Cut(args, "=")
+		n, err := strconv.ParseUint(reg, 16, 8)
+		if err != nil || n >= 6 {
+			return "E01", false
+		}
+		v, err := gdbDecode16(value)
+		if err != nil {
+			return "E01", false
+		}
+		setRegisterPair(cpu, int(n), v)
+		return "OK", false
+	case 'm':
+		address, length, err := gdbAddressLength(args)
+		if err != nil {
+			return "E01", false
+		}
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = cpu.Peek(address + uint16(i))
+		}
+		return hex.EncodeToString(data), false
+	case 'M':
+		spec, values, _ := strings.Cut(args, ":")
+		address, length, err := gdbAddressLength(spec)
+		if err != nil {
+			return "E01", false
+		}
+		data, err := hex.DecodeString(values)
+		if err != nil || len(data) != length {
+			return "E01", false
+		}
+		for i, b := range data {
+			cpu.Poke(address+uint16(i), b)
+		}
+		return "OK", false
+	case 'Z', 'z':
+		return s.setBreakpoint(args, packet[0] == 'Z'), false
+	case 'c':
+		if args != "" {
+			address, err := strconv.ParseUint(args, 16, 16)
+			if err != nil {
+				return "E01", false
+			}
+			cpu.PC = uint16(address)
+		}
+		return "", true
+	case 's':
+		s.stepping = true
+		return "", true
+	case 'v':
+		switch {
+		case args == "Cont?":
+			return "vCont;c;C;s;S", false
+		case strings.HasPrefix(args, "Cont;"):
+			action := strings.TrimPrefix(args, "Cont;")
+			if action == "" {
+				return "E01", false
+			}
+			if action[0] == 's' || action[0] == 'S' {
+				s.stepping = true
+			}
+			return "", true
+		}
+	case 'H', 'T':
+		return "OK", false
+	case 'D':
+		s.breakpoints = map[uint16]bool{}
+		s.watchpoints = nil
+		s.send("OK")
+		return "", true
+	case 'k':
+		cpu.Exit()
+		return "", true
+	case 'q':
+		return s.query(args), false
+	case 'Q':
+		if args == "StartNoAckMode" {
+			s.send("OK")
+			s.noAck.Store(true)
+			return "", false
+		}
+	}
+	// an empty reply means the request isn't supported
+	return "", false
+}
+
+func (s *GDBServer) query(args string) string {
+	switch {
+	case strings.HasPrefix(args, "Supported"):
+		return "PacketSize=1000;qXfer:features:read+;swbreak+;hwbreak+;QStartNoAckMode+"
+	case args == "Attached":
+		return "1"
+	case args == "C":
+		return "QC1"
+	case args == "fThreadInfo":
+		return "m1"
+	case args == "sThreadInfo":
+		return "l"
+	case strings.HasPrefix(args, "Xfer:features:read:target.xml:"):
+		var offset, length int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(args, "Xfer:features:read:target.xml:"), "%x,%x", &offset, &length); err != nil {
+			return "E01"
+		}
+		if offset >= len(gdbTargetXML) {
+			return "l"
+		}
+		if offset+length >= len(gdbTargetXML) {
+			return "l" + gdbTargetXML[offset:]
+		}
+		return "m" + gdbTargetXML[offset:offset+length]
+	}
+	return ""
+}
+
+// setBreakpoint handles Z and z requests: 0 and 1 are breakpoints, 2, 3 and
+// 4 write, read and access watchpoints.
+func (s *GDBServer) setBreakpoint(args string, insert bool) string {
+	parts := strings.Split(args, ",")
+	if len(parts) < 3 {
+		return "E01"
+	}
+	address, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "E01"
+	}
+	length, err := strconv.ParseUint(parts[2], 16, 16)
+	if err != nil || length == 0 {
+		length = 1
+	}
+
+	var kind int
+	switch parts[0] {
+	case "0", "1":
+		if insert {
+			s.breakpoints[uint16(address)] = true
+		} else {
+			delete(s.breakpoints, uint16(address))
+		}
+		return "OK"
+	case "2":
+		kind = WatchWrite
+	case "3":
+		kind = WatchRead
+	case "4":
+		kind = WatchRead | WatchWrite
+	default:
+		return ""
+	}
+
+	start, end := uint16(address), uint16(address+length-1)
+	for i, wp := range s.watchpoints {
+		if wp.Kind == kind && wp.Start == start && wp.End == end {
+			if !insert {
+				s.watchpoints = append(s.watchpoints[:i], s.watchpoints[i+1:]...)
+			}
+			return "OK"
+		}
+	}
+	if insert {
+		s.watchpoints = append(s.watchpoints, &Watchpoint{Kind: kind, Start: start, End: end})
+	}
+	return "OK"
+}
+
+func (s *GDBServer) readRegisters(cpu *CPU) string {
+	var b strings.Builder
+	for _, value := range []uint16{cpu.GetAF(), cpu.GetBC(), cpu.GetDE(), cpu.GetHL(), cpu.SP, cpu.PC} {
+		fmt.Fprintf(&b, "%02x%02x", uint8(value), uint8(value>>8))
+	}
+	return b.String()
+}
+
+func (s *GDBServer) writeRegisters(cpu *CPU, values string) string {
+	if len(values) != 6*4 {
+		return "E01"
+	}
+	for n := 0; n < 6; n++ {
+		value, err := gdbDecode16(values[n*4 : n*4+4])
+		if err != nil {
+			return "E01"
+		}
+		setRegisterPair(cpu, n, value)
+	}
+	return "OK"
+}
+
+// gdbDecode16 decodes a 16-bit register value sent in target (little
+// endian) byte order.
+func gdbDecode16(s string) (uint16, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil || len(data) != 2 {
+		return 0, fmt.Errorf("invalid register value %q", s)
+	}
+	return uint16(data[1])<<8 | uint16(data[0]), nil
+}
+
+func gdbAddressLength(s string) (uint16, int, error) {
+	addr, length, found := strings.Cut(s, ",")
+	if !found {
+		return 0, 0, fmt.Errorf("missing length")
+	}
+	a, err := strconv.ParseUint(addr, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	n, err := strconv.ParseUint(length, 16, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint16(a), int(n), nil
+}
+
+// setRegisterPair sets register n in the af, bc, de, hl, sp, pc order.
+func setRegisterPair(cpu *CPU, n int, value uint16) {
+	high, low := uint8(value>>8), uint8(value)
+	switch n {
+	case 0:
+		cpu.Registers[RegA] = high
+		cpu.Flags.SetValue(low)
+	case 1:
+		cpu.Registers[RegB], cpu.Registers[RegC] = high, low
+	case 2:
+		cpu.Registers[RegD], cpu.Registers[RegE] = high, low
+	case 3:
+		cpu.Registers[RegH], cpu.Registers[RegL] = high, low
+	case 4:
+		cpu.SP = value
+	case 5:
+		cpu.PC = value
+	}
+}
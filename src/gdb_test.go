@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// gdbClient is the debugger end of a connection to a GDBServer.
+type gdbClient struct {
+	t       *testing.T
+	conn    net.Conn
+	replies chan string
+}
+
+func newGDBClient(t *testing.T, conn net.Conn) *gdbClient {
+	c := &gdbClient{t: t, conn: conn, replies: make(chan string, 16)}
+	go func() {
+		defer close(c.replies)
+		r := bufio.NewReader(conn)
+		for {
+			b, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			if b != '$' {
+				continue // acks
+			}
+			data, err := r.ReadString('#')
+			if err != nil {
+				return
+			}
+			r.Discard(2)
+			c.replies <- strings.TrimSuffix(data, "#")
+		}
+	}()
+	return c
+}
+
+func (c *gdbClient) send(packet string) {
+	fmt.Fprintf(c.conn, "$%s#%02x", packet, gdbChecksum(packet))
+}
+
+func (c *gdbClient) request(packet string) string {
+	c.send(packet)
+	return <-c.replies
+}
+
+func (c *gdbClient) expect(packet, reply string) {
+	c.t.Helper()
+	if got := c.request(packet); got != reply {
+		c.t.Errorf("%s: expected %q, got %q", packet, reply, got)
+	}
+}
+
+// runGDB runs countingProgram with a debugger attached.
+func runGDB(t *testing.T, session func(c *gdbClient, cpu *CPU)) {
+	cpu := InitCPU()
+	copy(cpu.Memory, countingProgram)
+	serveGDB(t, cpu, session)
+}
+
+// serveGDB attaches a debugger to cpu, stepping the CPU on its own
+// goroutine until the client is done with it.
+func serveGDB(t *testing.T, cpu *CPU, session func(c *gdbClient, cpu *CPU)) {
+	cpu.GDB = NewGDBServer()
+
+	server, client := net.Pipe()
+	cpu.GDB.accept(server)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000000 && !cpu.Quit; i++ {
+			cpu.Step()
+		}
+	}()
+	session(newGDBClient(t, client), cpu)
+	client.Close()
+	<-done
+}
+
+func TestGDBRegistersAndMemory(t *testing.T) {
+	runGDB(t, func(c *gdbClient, cpu *CPU) {
+		c.expect("?", "S05")
+		c.expect("g", "0000000000000000feff0000")
+		c.expect("P1=3412", "OK")
+		c.expect("p1", "3412")
+		c.expect("m0,5", "3c00cd1000")
+		c.expect("Mc000,2:abcd", "OK")
+		c.expect("mc000,2", "abcd")
+		if !strings.Contains(c.request("qXfer:features:read:target.xml:0,1000"), `name="pc"`) {
+			t.Errorf("target.xml doesn't describe pc")
+		}
+		c.send("k")
+	})
+}
+
+// Memory is read and written the way the CPU sees it, so DIV counts and
+// writing it resets it.
+func TestGDBIORegisters(t *testing.T) {
+	runGDB(t, func(c *gdbClient, cpu *CPU) {
+		c.expect("?", "S05")
+		c.expect("Z0,10,1", "OK")
+		for i := 0; i < 20; i++ {
+			c.expect("c", "T05swbreak:;")
+		}
+		div := uint8((cpu.Clock - cpu.DivStart) >> 8)
+		if div == 0 {
+			t.Fatalf("DIV hasn't counted yet")
+		}
+		c.expect("mff04,1", fmt.Sprintf("%02x", div))
+		c.expect("Mff04,1:12", "OK")
+		c.expect("mff04,1", "00")
+		c.send("k")
+	})
+}
+
+// A bad packet from the debugger gets an error, or is thrown away if its
+// checksum is wrong, rather than taking the emulator down.
+func TestGDBMalformedPackets(t *testing.T) {
+	runGDB(t, func(c *gdbClient, cpu *CPU) {
+		c.expect("vCont;", "E01")
+		fmt.Fprintf(c.conn, "$g#00")
+		c.expect("?", "S05")
+		c.send("k")
+	})
+}
+
+func TestGDBBreakpointsAndStepping(t *testing.T) {
+	runGDB(t, func(c *gdbClient, cpu *CPU) {
+		c.expect("Z0,10,1", "OK")
+		c.expect("c", "T05swbreak:;")
+		c.expect("p5", "1000")
+		c.expect("s", "S05")
+		c.expect("p5", "1300")
+		c.expect("z0,10,1", "OK")
+
+		c.expect("Z2,c000,1", "OK")
+		c.expect("c", "T05watch:c000;")
+		c.expect("mc000,1", "02")
+
+		// interrupt a running target
+		c.expect("z2,c000,1", "OK")
+		c.send("c")
+		c.conn.Write([]byte{0x03})
+		if reply := <-c.replies; reply != "S02" {
+			t.Errorf("expected an interrupt stop, got %q", reply)
+		}
+		c.send("k")
+	})
+}
+
+// A locked up CPU runs no instructions, but a debugger can still attach to
+// it and interrupt it, and one that is attached hears about the lock-up.
+func TestGDBLockedUp(t *testing.T) {
+	lockingProgram := []byte{0x00, 0xD3} // NOP, then an illegal opcode
+
+	cpu := InitCPU()
+	copy(cpu.Memory, lockingProgram)
+	cpu.Step()
+	cpu.Step()
+	if cpu.Lockup == nil {
+		t.Fatalf("CPU didn't lock up")
+	}
+	serveGDB(t, cpu, func(c *gdbClient, cpu *CPU) {
+		c.expect("?", "S05")
+		c.expect("p5", "0100")
+		c.send("c")
+		c.conn.Write([]byte{0x03})
+		if reply := <-c.replies; reply != "S02" {
+			t.Errorf("expected an interrupt stop, got %q", reply)
+		}
+		c.send("k")
+	})
+
+	cpu = InitCPU()
+	copy(cpu.Memory, lockingProgram)
+	serveGDB(t, cpu, func(c *gdbClient, cpu *CPU) {
+		c.expect("?", "S05")
+		c.expect("c", "S05")
+		c.expect("p5", "0100")
+		c.send("k")
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the reference screenshots in testdata/golden")
+
+// goldenROMs are the bundled ROMs with the number of frames each is run for
+// before the screen is compared. dmg-acid2 isn't among them: it isn't
+// bundled, and it draws half its face with the window, which the renderer
+// doesn't draw yet.
+var goldenROMs = []struct {
+	name   string
+	frames int
+}{
+	{"background-tile", 30},
+	{"background-tilemap", 30},
+	{"background-fullscreen", 30},
+	{"vblank", 120},
+	{"grid-collision", 120},
+}
+
+// TestGoldenScreenshots runs each ROM headless and compares the screen with
+// testdata/golden/NAME.png. On a mismatch the screen is written to
+// NAME-actual.png, and NAME-diff.png shows the reference faded with the
+// pixels that differ in red.
+func TestGoldenScreenshots(t *testing.T) {
+	for _, rom := range goldenROMs {
+		t.Run(rom.name, func(t *testing.T) {
+			cpu := InitCPU()
+			if err := LoadROM(cpu, filepath.Join("tests", "roms", rom.name+".gb")); err != nil {
+				t.Fatal(err)
+			}
+			cpu.ResetPostBoot()
+			for i := 0; i < rom.frames; i++ {
+				if err := cpu.RunFrame(); err != nil {
+					t.Fatalf("frame %d: %v", i, err)
+				}
+			}
+			actual := cpu.FrameImage()
+
+			golden := filepath.Join("testdata", "golden", rom.name+".png")
+			if *updateGolden {
+				if err := writePNG(golden, actual); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := readPNG(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update-golden to create it)", err)
+			}
+
+			diff, count := diffImages(expected, actual)
+			if count == 0 {
+				return
+			}
+			base := filepath.Join("testdata", "golden", rom.name)
+			if err := writePNG(base+"-actual.png", actual); err != nil {
+				t.Fatal(err)
+			}
+			if err := writePNG(base+"-diff.png", diff); err != nil {
+				t.Fatal(err)
+			}
+			t.Errorf("%d pixels differ from %s, see %s-actual.png and %s-diff.png", count, golden, base, base)
+		})
+	}
+}
+
+func readPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// diffImages returns an image of the differences and how many pixels
+// differ. Pixels outside either image count as different.
+func diffImages(expected, actual image.Image) (*image.RGBA, int) {
+	bounds := expected.Bounds().Union(actual.Bounds())
+	diff := image.NewRGBA(bounds)
+	count := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			p := image.Pt(x, y)
+			if p.In(expected.Bounds()) && p.In(actual.Bounds()) &&
+				color.RGBAModel.Convert(expected.At(x, y)) == color.RGBAModel.Convert(actual.At(x, y)) {
+				gray := color.GrayModel.Convert(expected.At(x, y)).(color.Gray)
+				faded := 0xC0 + gray.Y/4
+				diff.Set(x, y, color.RGBA{faded, faded, faded, 0xFF})
+				continue
+			}
+			diff.Set(x, y, color.RGBA{0xFF, 0x00, 0x00, 0xFF})
+			count++
+		}
+	}
+	return diff, count
+}
@@ -0,0 +1,64 @@
+package main
+
+// VRAM DMA copies from ROM or RAM to VRAM in blocks of 16 bytes. HDMA1/2
+// (0xFF51/0xFF52) are the source and HDMA3/4 (0xFF53/0xFF54) the
+// destination in VRAM, both with the low 4 bits ignored. Writing HDMA5
+// (0xFF55) starts a transfer of (bits 0-6 + 1) blocks: with bit 7 clear it
+// is a general purpose DMA, done all at once while the CPU waits; with bit 7
+// set it is an HBlank DMA, a block at the start of each HBlank. While an
+// HBlank DMA runs HDMA5 reads the blocks left minus one, and writing it with
+// bit 7 clear stops the transfer. It reads 0xFF once a transfer is done.
+//
+// The CPU isn't held up during the blocks of an HBlank DMA.
+
+// HDMABlockCycles is how long a block of a general purpose DMA takes: 8
+// M-cycles, or 16 in double speed mode.
+const HDMABlockCycles = 32
+
+func (cpu *CPU) writeHDMA(value uint8) {
+	if cpu.HDMABlocks > 0 {
+		if value&0x80 == 0 {
+			cpu.Memory[0xFF55] = 0x80 | uint8(cpu.HDMABlocks-1)
+			cpu.HDMABlocks = 0
+		}
+		return
+	}
+
+	cpu.HDMASource = (uint16(cpu.Memory[0xFF51])<<8 | uint16(cpu.Memory[0xFF52])) & 0xFFF0
+	cpu.HDMADest = 0x8000 | (uint16(cpu.Memory[0xFF53])<<8|uint16(cpu.Memory[0xFF54]))&0x1FF0
+	cpu.HDMABlocks = int(value&0x7F) + 1
+	cpu.Memory[0xFF55] = value & 0x7F
+	if value&0x80 != 0 {
+		return
+	}
+	for cpu.HDMABlocks > 0 {
+		cpu.hdmaBlock()
+		for end := cpu.Clock + HDMABlockCycles; cpu.Clock < end; {
+			cpu.tick()
+		}
+	}
+}
+
+// hblankDMA copies a block of an HBlank DMA. The LCD calls it at the start
+// of HBlank on each visible line.
+func (cpu *CPU) hblankDMA() {
+	if cpu.HDMABlocks > 0 {
+		cpu.hdmaBlock()
+	}
+}
+
+func (cpu *CPU) hdmaBlock() {
+	copy(cpu.Memory[cpu.HDMADest:cpu.HDMADest+16], cpu.Memory[cpu.HDMASource:uint32(cpu.HDMASource)+16])
+	cpu.HDMASource += 16
+	cpu.HDMADest += 16
+	cpu.HDMABlocks--
+	if cpu.HDMADest == 0xA000 {
+		// the destination doesn't wrap around
+		cpu.HDMABlocks = 0
+	}
+	if cpu.HDMABlocks == 0 {
+		cpu.Memory[0xFF55] = 0xFF
+	} else {
+		cpu.Memory[0xFF55] = uint8(cpu.HDMABlocks - 1)
+	}
+}
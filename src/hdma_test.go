@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func startHDMA(cpu *CPU, source, dest uint16, hdma5 uint8) {
+	cpu.WriteMemory(0xFF51, uint8(source>>8))
+	cpu.WriteMemory(0xFF52, uint8(source))
+	cpu.WriteMemory(0xFF53, uint8(dest>>8))
+	cpu.WriteMemory(0xFF54, uint8(dest))
+	cpu.WriteMemory(0xFF55, hdma5)
+}
+
+func TestGeneralPurposeDMA(t *testing.T) {
+	cpu := newCGBTestCPU()
+	for i := 0; i < 0x40; i++ {
+		cpu.Memory[0xC100+i] = uint8(i + 1)
+	}
+	cpu.WriteMemory(0xFF4F, 0x01)
+	start := cpu.Clock
+	startHDMA(cpu, 0xC10F, 0x8205, 0x03) // low bits ignored, 4 blocks
+	if elapsed := cpu.Clock - start; elapsed < 4*HDMABlockCycles {
+		t.Errorf("took %d cycles, expected at least %d", elapsed, 4*HDMABlockCycles)
+	}
+	if !bytes.Equal(cpu.vram(1)[0x200:0x240], cpu.Memory[0xC100:0xC140]) {
+		t.Errorf("VRAM bank 1 has % X", cpu.vram(1)[0x200:0x240])
+	}
+	if cpu.vram(0)[0x200] != 0 {
+		t.Errorf("copied to bank 0")
+	}
+	if hdma5 := cpu.ReadMemory(0xFF55); hdma5 != 0xFF {
+		t.Errorf("HDMA5 reads %02X when done", hdma5)
+	}
+}
+
+func TestHBlankDMA(t *testing.T) {
+	cpu := newCGBTestCPU()
+	for i := 0; i < 0x30; i++ {
+		cpu.Memory[0xC000+i] = uint8(i + 1)
+	}
+	startHDMA(cpu, 0xC000, 0x8000, 0x80|0x02) // 3 blocks
+	if cpu.Memory[0x8000] != 0 || cpu.ReadMemory(0xFF55) != 0x02 {
+		t.Fatalf("copied before HBlank, HDMA5 %02X", cpu.Memory[0xFF55])
+	}
+
+	blocksAfterLine := []uint8{0x01, 0x00, 0xFF}
+	for line, hdma5 := range blocksAfterLine {
+		for cpu.FrameCycles() < line*CyclesPerLine+OAMScanCycles+TransferCycles+4 {
+			cpu.tick()
+		}
+		if value := cpu.Memory[0xFF55]; value != hdma5 {
+			t.Errorf("line %d: HDMA5 %02X, expected %02X", line, value, hdma5)
+		}
+	}
+	if !bytes.Equal(cpu.Memory[0x8000:0x8030], cpu.Memory[0xC000:0xC030]) {
+		t.Errorf("VRAM has % X", cpu.Memory[0x8000:0x8030])
+	}
+}
+
+func TestHBlankDMAStopped(t *testing.T) {
+	cpu := newCGBTestCPU()
+	startHDMA(cpu, 0xC000, 0x8000, 0x80|0x05)
+	for cpu.FrameCycles() < OAMScanCycles+TransferCycles+4 {
+		cpu.tick()
+	}
+	cpu.WriteMemory(0xFF55, 0x00)
+	if hdma5 := cpu.ReadMemory(0xFF55); hdma5 != 0x84 || cpu.HDMABlocks != 0 {
+		t.Errorf("HDMA5 reads %02X after stopping, expected 84", hdma5)
+	}
+}
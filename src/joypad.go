@@ -0,0 +1,89 @@
+package main
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Joypad buttons, one bit each in cpu.Joypad (set = pressed). The low nibble
+// is the d-pad and the high nibble the action buttons, in the same order as
+// the lines of the P1 register.
+const (
+	ButtonRight uint8 = 1 << iota
+	ButtonLeft
+	ButtonUp
+	ButtonDown
+	ButtonA
+	ButtonB
+	ButtonSelect
+	ButtonStart
+)
+
+var joypadKeys = map[sdl.Keycode]uint8{
+	sdl.K_RIGHT:  ButtonRight,
+	sdl.K_LEFT:   ButtonLeft,
+	sdl.K_UP:     ButtonUp,
+	sdl.K_DOWN:   ButtonDown,
+	sdl.K_x:      ButtonA,
+	sdl.K_z:      ButtonB,
+	sdl.K_RSHIFT: ButtonSelect,
+	sdl.K_RETURN: ButtonStart,
+}
+
+// SetJoypad replaces the set of pressed buttons, requesting the joypad
+// interrupt and ending STOP if a button on a selected line goes down.
+func (cpu *CPU) SetJoypad(buttons uint8) {
+	before := cpu.joypadLines()
+	cpu.Joypad = buttons
+	after := cpu.joypadLines()
+
+	// lines are active low, so a press is a 1 -> 0 transition
+	if before&^after != 0 {
+		cpu.Memory[0xFF0F] |= 1 << 4
+		cpu.Stopped = false
+	}
+}
+
+// joypadLines returns the low nibble of P1: the buttons on the lines the game
+// has selected by clearing bit 4 (d-pad) and/or bit 5 (buttons).
+func (cpu *CPU) joypadLines() uint8 {
+	selectBits := cpu.Memory[0xFF00]
+	buttons := cpu.Joypad
+	if cpu.SGB.Player != 0 {
+		// only player 1 has a joypad
+		buttons = 0
+	}
+	pressed := uint8(0)
+	if selectBits&0x10 == 0 {
+		pressed |= buttons & 0x0F
+	}
+	if selectBits&0x20 == 0 {
+		pressed |= buttons >> 4
+	}
+	return ^pressed & 0x0F
+}
+
+// readJoypad returns the value of the P1 register (0xFF00). With neither
+// line selected, the SGB answers with the player whose joypad is read next
+// after MLT_REQ: 0x0F for player 1, 0x0E for player 2 and so on.
+func (cpu *CPU) readJoypad() uint8 {
+	p1 := 0xC0 | cpu.Memory[0xFF00]&0x30
+	if cpu.Model.IsSGB() && p1&0x30 == 0x30 {
+		return p1 | (0x0F - cpu.SGB.Player)
+	}
+	return p1 | cpu.joypadLines()
+}
+
+// handleJoypadKey tracks the keyboard's button state. It is only latched
+// into the joypad once per frame, so that a recorded movie (one byte per
+// frame) replays exactly the same input transitions.
+func (cpu *CPU) handleJoypadKey(keyEvent *sdl.KeyboardEvent) {
+	button, ok := joypadKeys[keyEvent.Keysym.Sym]
+	if !ok || keyEvent.Repeat != 0 {
+		return
+	}
+	if keyEvent.Type == sdl.KEYDOWN {
+		cpu.KeyboardButtons |= button
+	} else {
+		cpu.KeyboardButtons &^= button
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestJoypadInterrupt(t *testing.T) {
+	cpu := InitCPU()
+	copy(cpu.Memory[0xC000:], []uint8{0x18, 0xFE}) // JR -2
+	copy(cpu.Memory[0x0060:], []uint8{0x18, 0xFE}) // the handler loops too
+	cpu.PC = 0xC000
+	cpu.IME = 1
+	cpu.Memory[0xFFFF] = 0x10
+	cpu.Memory[0xFF00] = 0x10 // only the buttons selected
+
+	// a direction isn't on a selected line
+	cpu.SetJoypad(ButtonUp)
+	cpu.Step()
+	if cpu.Memory[0xFF0F]&0x10 != 0 || cpu.PC != 0xC000 {
+		t.Fatalf("pressing Up requested the interrupt: IF %02X, PC %04X", cpu.Memory[0xFF0F], cpu.PC)
+	}
+
+	cpu.SetJoypad(ButtonUp | ButtonStart)
+	cpu.Step()
+	if cpu.PC != 0x0060 {
+		t.Fatalf("PC is %04X, expected the joypad handler at 0060", cpu.PC)
+	}
+	if p1 := cpu.ReadMemory(0xFF00); p1 != 0xD7 {
+		t.Errorf("P1 reads %02X, expected D7 with Start held", p1)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The SM83 has 11 opcodes that don't exist: D3 DB DD E3 E4 EB EC ED F4 FC
+// FD. Fetching one locks the CPU up for good: it stops executing and
+// doesn't take interrupts, while the LCD and the rest of the system keep
+// running, so the screen stays up. Only a reset gets it going again.
+
+// IllegalOpcodePolicy is what the emulator does when the CPU locks up.
+type IllegalOpcodePolicy int
+
+const (
+	IllegalOpcodeLock  IllegalOpcodePolicy = iota // lock up like hardware
+	IllegalOpcodeBreak                            // lock up and break into the debugger
+	IllegalOpcodeError                            // lock up and have RunFrame return the Lockup
+)
+
+func ParseIllegalOpcodePolicy(s string) (IllegalOpcodePolicy, error) {
+	switch s {
+	case "lock":
+		return IllegalOpcodeLock, nil
+	case "break":
+		return IllegalOpcodeBreak, nil
+	case "error":
+		return IllegalOpcodeError, nil
+	}
+	return IllegalOpcodeLock, fmt.Errorf("unknown policy %q (lock, break or error)", s)
+}
+
+// HistoryLength is how many instructions InstructionHistory remembers.
+const HistoryLength = 16
+
+// InstructionHistory remembers the addresses of the last instructions
+// executed, for reporting how the CPU got somewhere.
+type InstructionHistory struct {
+	pcs   [HistoryLength]uint16
+	next  int
+	count int
+}
+
+func (h *InstructionHistory) Record(pc uint16) {
+	h.pcs[h.next] = pc
+	h.next = (h.next + 1) % HistoryLength
+	if h.count < HistoryLength {
+		h.count++
+	}
+}
+
+// Recent returns the addresses recorded, oldest first.
+func (h *InstructionHistory) Recent() []uint16 {
+	recent := make([]uint16, 0, h.count)
+	for i := h.count; i > 0; i-- {
+		recent = append(recent, h.pcs[(h.next-i+HistoryLength)%HistoryLength])
+	}
+	return recent
+}
+
+// Lockup describes how the CPU locked up. It is also the error RunFrame
+// returns under IllegalOpcodeError.
+type Lockup struct {
+	Opcode  uint8
+	PC      uint16
+	Bank    int
+	Frame   uint64
+	History []uint16 // the instructions before, oldest first
+}
+
+func (l *Lockup) Error() string {
+	return fmt.Sprintf("CPU locked up on illegal opcode %02X at %02X:%04X", l.Opcode, l.Bank, l.PC)
+}
+
+// Report describes the lock-up for the user, with the instructions that led
+// to it.
+func (l *Lockup) Report(cpu *CPU) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s in frame %d\n", l.Error(), l.Frame)
+	if len(l.History) > 0 {
+		b.WriteString("Last instructions:\n")
+	}
+	for _, pc := range l.History {
+		fmt.Fprintf(&b, "  %s\n", formatInstruction(cpu.BankAt(pc), cpu.Disassemble(pc), cpu.Label))
+	}
+	fmt.Fprintf(&b, "  %s", formatInstruction(l.Bank, cpu.Disassemble(l.PC), cpu.Label))
+	return b.String()
+}
+
+// lockUp is called when an illegal opcode is fetched. PC is left at it.
+func (cpu *CPU) lockUp(opcode uint8) {
+	history := cpu.History.Recent()
+	if n := len(history); n > 0 && history[n-1] == cpu.PC {
+		// Step records the illegal opcode before fetching it
+		history = history[:n-1]
+	}
+	cpu.Lockup = &Lockup{
+		Opcode:  opcode,
+		PC:      cpu.PC,
+		Bank:    cpu.BankAt(cpu.PC),
+		Frame:   cpu.Frames,
+		History: history,
+	}
+	if cpu.IllegalOpcodes != IllegalOpcodeBreak {
+		return
+	}
+	// the CPU won't get to another instruction, so stop here rather than
+	// before the next one
+	if cpu.GDB != nil && cpu.GDB.Attached() {
+		cpu.GDB.Break()
+		cpu.GDB.Check(cpu)
+	} else {
+		cpu.AttachDebugger().Break(cpu.Lockup.Error())
+		cpu.Debugger.Check(cpu)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// lockupProgram counts in A a few times, then runs into an illegal opcode
+// at 0x0006.
+var lockupProgram = []byte{
+	0x3C,       // 0000 INC A
+	0x3C,       // 0001 INC A
+	0x06, 0x42, // 0002 LD B, 0x42
+	0x00, // 0004 NOP
+	0x00, // 0005 NOP
+	0xD3, // 0006 illegal
+	0x3C, // 0007 INC A, never reached
+}
+
+func lockupCPU(policy IllegalOpcodePolicy) *CPU {
+	cpu := InitCPU()
+	copy(cpu.Memory, lockupProgram)
+	cpu.IllegalOpcodes = policy
+	return cpu
+}
+
+func TestLockup(t *testing.T) {
+	cpu := lockupCPU(IllegalOpcodeLock)
+	cpu.Memory[0xFFFF] = 0x01 // VBlank, which mustn't be serviced
+	for i := 0; i < 3; i++ {
+		if err := cpu.RunFrame(); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+
+	if cpu.Lockup == nil {
+		t.Fatalf("CPU didn't lock up")
+	}
+	if cpu.PC != 0x0006 || cpu.Registers[RegA] != 2 || cpu.SP != 0xFFFE {
+		t.Errorf("CPU kept going: PC %04X A %02X SP %04X", cpu.PC, cpu.Registers[RegA], cpu.SP)
+	}
+	if cpu.Frames != 3 || cpu.Memory[0xFF0F]&0x01 == 0 {
+		t.Errorf("the LCD stopped: %d frames, IF %02X", cpu.Frames, cpu.Memory[0xFF0F])
+	}
+
+	lockup := cpu.Lockup
+	if lockup.Opcode != 0xD3 || lockup.PC != 0x0006 || lockup.Bank != 0 || lockup.Frame != 0 {
+		t.Errorf("lockup %+v", lockup)
+	}
+	if expected := []uint16{0x0000, 0x0001, 0x0002, 0x0004, 0x0005}; !equalAddresses(lockup.History, expected) {
+		t.Errorf("history %04X, expected %04X", lockup.History, expected)
+	}
+
+	report := lockup.Report(cpu)
+	for _, line := range []string{
+		"CPU locked up on illegal opcode D3 at 00:0006 in frame 0",
+		"00:0002  06 42     LD B, $42",
+		"00:0006  D3        DB $D3",
+	} {
+		if !strings.Contains(report, line) {
+			t.Errorf("report doesn't contain %q:\n%s", line, report)
+		}
+	}
+}
+
+func TestLockupError(t *testing.T) {
+	cpu := lockupCPU(IllegalOpcodeError)
+	err := cpu.RunFrame()
+	var lockup *Lockup
+	if !errors.As(err, &lockup) || lockup.PC != 0x0006 {
+		t.Fatalf("RunFrame returned %v, expected the lockup", err)
+	}
+}
+
+func TestLockupBreak(t *testing.T) {
+	cpu := lockupCPU(IllegalOpcodeBreak)
+	var out bytes.Buffer
+	cpu.Debugger = NewDebugger(strings.NewReader("continue\n"), &out)
+	cpu.RunFrame()
+	if !strings.Contains(out.String(), "Break (CPU locked up on illegal opcode D3 at 00:0006) at 00:0006") {
+		t.Errorf("expected to break at the illegal opcode, output:\n%s", out.String())
+	}
+}
+
+func TestLoadStateUndoesLockup(t *testing.T) {
+	cpu := lockupCPU(IllegalOpcodeLock)
+	var state bytes.Buffer
+	if err := cpu.SaveState(&state); err != nil {
+		t.Fatal(err)
+	}
+	cpu.RunFrame()
+	if cpu.Lockup == nil {
+		t.Fatalf("CPU didn't lock up")
+	}
+	if err := cpu.LoadState(&state); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.Lockup != nil {
+		t.Errorf("still locked up after loading a state from before")
+	}
+}
+
+func TestInstructionHistory(t *testing.T) {
+	var h InstructionHistory
+	if len(h.Recent()) != 0 {
+		t.Errorf("empty history has %d entries", len(h.Recent()))
+	}
+	for pc := uint16(0); pc < HistoryLength+5; pc++ {
+		h.Record(pc)
+	}
+	recent := h.Recent()
+	if len(recent) != HistoryLength || recent[0] != 5 || recent[HistoryLength-1] != HistoryLength+4 {
+		t.Errorf("history %v, expected the last %d addresses oldest first", recent, HistoryLength)
+	}
+}
+
+func equalAddresses(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
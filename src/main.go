@@ -1,8 +1,10 @@
 package main
 
 import (
+	"crypto/sha1"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -10,23 +12,74 @@ import (
 	"github.com/veandco/go-sdl2/sdl"
 )
 
+// Version identifies the emulator build in movie files.
+const Version = "gopherboy-0.1"
+
 type CPU struct {
 	Registers     []uint8
-	Clock         uint16
+	Clock         uint64 // T-cycles since power on, at normal speed
 	PC            uint16
 	SP            uint16
 	IME           uint16
 	Flags         *Flags
 	MaxCycles     int // for testing
 	DMAActive     bool
-	DMACycles     int
 	DMASourceBase uint16
 
-	Memory []uint8
-	ROM    []uint8
-	Halted bool
+	Events     Scheduler // when each component next has something to do
+	DivStart   uint64    // Clock when the timer's counter was 0
+	SerialBits int       // bits left in the serial transfer, 0 if none
+
+	FrameStart  uint64 // Clock at the start of the current frame
+	Frames      uint64 // frames completed since power on
+	Speed       SpeedControl
+	Rewind      *RewindBuffer     // nil when rewinding is disabled
+	Movie       *Movie            // movie being recorded or played, if any
+	Screenshot  ScreenshotOptions // how the screenshot hotkey saves
+	Debugger    *Debugger         // nil unless debugging
+	Tracer      *Tracer           // nil unless tracing
+	Symbols     *SymbolTable      // nil unless a symbol file was loaded
+	Conventions *DebugConventions // ld b,b and ld d,d handling, nil if off
+	Sanitizer   *Sanitizer        // nil unless running with -strict
+	GDB         *GDBServer        // nil unless serving a remote debugger
+	Serial      io.Writer         // receives bytes sent over the link cable
+	Bus         *BusRecorder      // nil unless memory accesses are being recorded
+	Quit        bool
+
+	History        InstructionHistory  // the last instructions executed
+	Lockup         *Lockup             // set once an illegal opcode has locked the CPU up
+	IllegalOpcodes IllegalOpcodePolicy // what to do then
+
+	Model       Model
+	CGBMode     bool // the CGB's colour features are on, see cgb.go
+	DoubleSpeed bool
+	VRAMBank    int // mapped at 0x8000
+	WRAMBank    int // mapped at 0xD000
+	VRAMBanks   [2][0x2000]uint8
+	WRAMBanks   [8][0x1000]uint8
+	BGPalettes  [64]uint8
+	OBJPalettes [64]uint8
+	HDMASource  uint16
+	HDMADest    uint16
+	HDMABlocks  int // blocks left in an HBlank DMA, 0 if none
+	SGB         SGB // the SNES side of the Super Game Boy, see sgb.go
+
+	Joypad          uint8 // buttons pressed, as seen by the game
+	KeyboardButtons uint8 // buttons held on the keyboard
+
+	Memory   []uint8
+	ROM      []uint8
+	ROMPath  string
+	BootHash [sha1.Size]byte // zero if no boot ROM was loaded
+	Halted   bool
+	Stopped  bool // by STOP, until a button is pressed
 
 	Framebuffer [][]uint32
+	Palette     Palette   // the colours of DMG games
+	Palettes    []Palette // what the palette hotkey cycles through
+	Pixels      []byte    // last rendered frame, RGBA 160x144
+	Shades      []uint8   // its DMG shades 0-3 before Palette, outside CGB mode
+	SGBPixels   []byte    // the same inside the SGB's border, RGBA 256x224
 	Window      *sdl.Window
 	Renderer    *sdl.Renderer
 	Texture     *sdl.Texture
@@ -109,8 +162,13 @@ func InitCPU() *CPU {
 		SP:        0xFFFE,
 		Flags:     &Flags{},
 		PC:        0x0000,
+		Speed:     NewSpeedControl(),
+		Events:    NewScheduler(),
+		Palette:   PalettePresets[0],
+		Palettes:  PalettePresets,
 	}
 	result.Flags.CPU = &result
+	result.Events.Schedule(EventLCD, 0)
 	result.Memory[0xFF43] = 0
 	result.Memory[0xFF44] = 0xFF
 	return &result
@@ -161,6 +219,7 @@ func LoadBoot(cpu *CPU, bootFilePath string) error {
 		return fmt.Errorf("error reading boot file: %v", err)
 	}
 	copy(cpu.Memory[0x0000:0x0000+len(bootData)], bootData)
+	cpu.BootHash = sha1.Sum(bootData)
 	return nil
 }
 
@@ -176,31 +235,37 @@ func LoadROM(cpu *CPU, romFilePath string) error {
 	}
 
 	copy(cpu.ROM, romData)
+	cpu.ROMPath = romFilePath
 
 	copy(cpu.Memory, romData)
 
 	return nil
 }
 
-func (cpu *CPU) Exit() {
-	if cpu.Texture != nil {
-		cpu.Texture.Destroy()
-	}
-	if cpu.Renderer != nil {
-		cpu.Renderer.Destroy()
-	}
-	if cpu.Window != nil {
-		cpu.Window.Destroy()
+// BankAt returns the bank mapped at an address, numbered the way rgblink
+// numbers them in symbol files. There is no mapper, so ROM is always bank 0
+// at 0x0000-0x3FFF and bank 1 at 0x4000-0x7FFF. WRAM at 0xD000-0xDFFF is
+// bank 1 outside CGB mode and the SVBK bank in it, and VRAM is the VBK bank
+// in CGB mode. Other regions report bank 0.
+func (cpu *CPU) BankAt(address uint16) int {
+	switch {
+	case address >= 0x4000 && address < 0x8000:
+		return 1
+	case address >= 0x8000 && address < 0xA000 && cpu.CGBMode:
+		return cpu.VRAMBank
+	case address >= 0xD000 && address < 0xE000:
+		if cpu.CGBMode {
+			return cpu.WRAMBank
+		}
+		return 1
 	}
-	sdl.Quit()
+	return 0
+}
 
-	// Dump memory contents to file
-	if err := DumpMemoryToFile(cpu, "memory_dump.bin"); err != nil {
-		log.Printf("Failed to dump memory: %v", err)
-	} else {
-		log.Printf("Memory dumped to memory_dump.bin")
-	}
-	os.Exit(0)
+// Exit stops the main loop at the end of the current frame. RunProgram then
+// shuts SDL down and dumps memory.
+func (cpu *CPU) Exit() {
+	cpu.Quit = true
 }
 
 func (cpu *CPU) HandleKeyboard() {
@@ -210,12 +275,78 @@ func (cpu *CPU) HandleKeyboard() {
 			cpu.Exit()
 		case *sdl.KeyboardEvent:
 			keyEvent := event.(*sdl.KeyboardEvent)
-			if keyEvent.Type == sdl.KEYDOWN {
-				if keyEvent.Keysym.Sym == sdl.K_ESCAPE {
-					cpu.Exit()
-				}
+			cpu.handleJoypadKey(keyEvent)
+			if keyEvent.Keysym.Sym == sdl.K_TAB {
+				cpu.Speed.HoldFastForward(keyEvent.Type == sdl.KEYDOWN)
+			}
+			if keyEvent.Keysym.Sym == sdl.K_BACKSPACE && cpu.Rewind != nil {
+				cpu.Rewind.Hold(keyEvent.Type == sdl.KEYDOWN)
+			}
+			if keyEvent.Type == sdl.KEYDOWN && keyEvent.Repeat == 0 {
+				cpu.handleHotkey(keyEvent.Keysym)
+			}
+		}
+	}
+}
+
+// handleHotkey dispatches emulator (not joypad) keys:
+//
+//	Esc      quit
+//	Tab      fast-forward while held
+//	Bksp     rewind while held
+//	`        toggle fast-forward
+//	- / =    halve / double speed (0.25x to 8x)
+//	0        reset speed to 1x
+//	P        pause / resume
+//	N        advance one frame while paused
+//	C        next palette for DMG games
+//	S        save a screenshot
+//	F1-F9    load save state slot 1-9 (with Shift: save)
+//	F12      break into the debugger (on the terminal)
+func (cpu *CPU) handleHotkey(keysym sdl.Keysym) {
+	if keysym.Sym >= sdl.K_F1 && keysym.Sym <= sdl.K_F9 {
+		if cpu.Movie != nil {
+			log.Printf("Save states are disabled while a movie is recording or playing")
+			return
+		}
+		slot := int(keysym.Sym-sdl.K_F1) + 1
+		if keysym.Mod&sdl.KMOD_SHIFT != 0 {
+			if err := cpu.SaveSlot(slot); err != nil {
+				log.Printf("Failed to save state: %v", err)
+			} else {
+				log.Printf("Saved state to slot %d (%s)", slot, cpu.StatePath(slot))
+			}
+		} else {
+			if err := cpu.LoadSlot(slot); err != nil {
+				log.Printf("Failed to load state: %v", err)
+			} else {
+				log.Printf("Loaded state from slot %d", slot)
 			}
 		}
+		return
+	}
+
+	switch keysym.Sym {
+	case sdl.K_ESCAPE:
+		cpu.Exit()
+	case sdl.K_BACKQUOTE:
+		cpu.Speed.ToggleFastForward()
+	case sdl.K_MINUS:
+		cpu.Speed.Slower()
+	case sdl.K_EQUALS:
+		cpu.Speed.Faster()
+	case sdl.K_0:
+		cpu.Speed.SetMultiplier(1)
+	case sdl.K_p:
+		cpu.Speed.TogglePause()
+	case sdl.K_n:
+		cpu.Speed.AdvanceFrame()
+	case sdl.K_c:
+		log.Printf("Palette: %s", cpu.CyclePalette())
+	case sdl.K_s:
+		cpu.saveScreenshot()
+	case sdl.K_F12:
+		cpu.AttachDebugger().Break("interrupted")
 	}
 }
 
@@ -227,67 +358,71 @@ func (cpu *CPU) RequestStatInterrupt() {
 	cpu.Memory[0xFF0F] |= 1 << 1
 }
 
+func (cpu *CPU) RequestTimerInterrupt() {
+	cpu.Memory[0xFF0F] |= 1 << 2
+}
+
+func (cpu *CPU) RequestSerialInterrupt() {
+	cpu.Memory[0xFF0F] |= 1 << 3
+}
+
+// HandleInterrupts services the highest priority interrupt that is both
+// enabled in IE and requested in IF: VBlank (0x40), STAT (0x48), timer
+// (0x50), serial (0x58) and joypad (0x60), in that order. Any of them ends
+// HALT, but the handler is only called if IME is set.
 func (cpu *CPU) HandleInterrupts() {
-	cpu.Halted = false
-	// vblank
-	// Check each interrupt type
-
-	ie := cpu.Memory[0xFFFF]
-
-	if_ := cpu.Memory[0xFF0F]
-
-	// VBlank
-	if (ie&0x01 != 0) && (if_&0x01 != 0) {
-		cpu.IME = 0 // Disable interrupts
-		// Clear the interrupt flag
-		cpu.Memory[0xFF0F] &= ^uint8(0x01)
-		// Push current PC to stack
-		// Jump to interrupt handler
-		high := uint8(cpu.PC >> 8)
-		low := uint8(cpu.PC & 0xFF)
-		cpu.SP--
-		cpu.Memory[cpu.SP] = high
-		cpu.SP--
-		cpu.Memory[cpu.SP] = low
-		cpu.PC = 0x0040
+	pending := cpu.Memory[0xFFFF] & cpu.Memory[0xFF0F] & 0x1F
+	if pending == 0 {
 		return
 	}
-
-	// STAT
-	if (ie&0x02 != 0) && (if_&0x02 != 0) {
-		cpu.IME = 0
-		cpu.Memory[0xFF0F] &= ^uint8(0x02)
-
-		high := uint8(cpu.PC >> 8)
-		low := uint8(cpu.PC & 0xFF)
-		cpu.SP--
-		cpu.Memory[cpu.SP] = high
-		cpu.SP--
-		cpu.Memory[cpu.SP] = low
-		cpu.PC = 0x0048
+	cpu.Halted = false
+	if cpu.IME == 0 {
 		return
 	}
+	for bit := uint16(0); bit < 5; bit++ {
+		if pending&(1<<bit) != 0 {
+			cpu.IME = 0
+			cpu.Memory[0xFF0F] &^= 1 << bit
+			cpu.dispatchInterrupt(0x0040 + bit*8)
+			return
+		}
+	}
+}
+
+// dispatchInterrupt calls an interrupt handler. It takes 5 M-cycles: two
+// internal ones, the two pushes of PC and the jump.
+func (cpu *CPU) dispatchInterrupt(vector uint16) {
+	cpu.tick()
+	cpu.call(vector)
+	cpu.tick()
 }
 
-// RunProgram executes the program loaded in the CPU's memory
-func RunProgram(cpu *CPU, maxCycles int) {
+// RunProgram executes the program loaded in the CPU's memory, one frame at a
+// time, until the window is closed or maxFrames frames have run (0 means no
+// limit). Frames are paced to the real Game Boy refresh rate.
+func RunProgram(cpu *CPU, maxFrames int, vsync bool, screenshotAfter int) {
 	if err := sdl.Init(sdl.INIT_EVERYTHING); err != nil {
 		log.Fatalf("Failed to initialize SDL: %v", err)
 	}
 
-	window, err := sdl.CreateWindow("Gopherboy", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, 160*4, 144*4, sdl.WINDOW_SHOWN)
+	width, height := cpu.ScreenSize()
+	window, err := sdl.CreateWindow("Gopherboy", sdl.WINDOWPOS_UNDEFINED, sdl.WINDOWPOS_UNDEFINED, int32(width)*4, int32(height)*4, sdl.WINDOW_SHOWN)
 	if err != nil {
 		log.Fatalf("Failed to create window: %v", err)
 	}
 	cpu.Window = window
 
-	renderer, err := sdl.CreateRenderer(window, -1, sdl.RENDERER_ACCELERATED)
+	rendererFlags := uint32(sdl.RENDERER_ACCELERATED)
+	if vsync {
+		rendererFlags |= sdl.RENDERER_PRESENTVSYNC
+	}
+	renderer, err := sdl.CreateRenderer(window, -1, rendererFlags)
 	if err != nil {
 		log.Fatalf("Failed to create renderer: %v", err)
 	}
 	cpu.Renderer = renderer
 
-	texture, err := renderer.CreateTexture(sdl.PIXELFORMAT_RGBA8888, sdl.TEXTUREACCESS_STREAMING, 160, 144)
+	texture, err := renderer.CreateTexture(sdl.PIXELFORMAT_RGBA8888, sdl.TEXTUREACCESS_STREAMING, int32(width), int32(height))
 	if err != nil {
 		log.Fatalf("Failed to create texture: %v", err)
 	}
@@ -300,51 +435,66 @@ func RunProgram(cpu *CPU, maxCycles int) {
 	}
 
 	// Set the logical size to maintain aspect ratio
-	renderer.SetLogicalSize(160, 144)
+	renderer.SetLogicalSize(int32(width), int32(height))
 
 	start := time.Now()
-
-	for i := 0; i < maxCycles; i++ {
-		// cycleStart := time.Now()
-
+	frames := 0
+	// even with vsync on we keep the limiter running: the monitor is rarely
+	// exactly 59.73 Hz, and on a 120/144 Hz display vsync alone would run the
+	// game at double speed or more
+	limiter := NewFrameLimiter(FrameRate)
+	lastPresent := time.Now()
+	lastTitle := time.Now()
+	var reportedLockup *Lockup // rewinding can undo a lock-up, and it can happen again
+
+	for !cpu.Quit && (maxFrames == 0 || frames < maxFrames) {
 		cpu.HandleKeyboard()
-		cpu.HandleInterrupts()
-		if !cpu.Halted {
-			cpu.ParseNextOpcode()
+		if cpu.GDB != nil {
+			// Step only polls while there are frames to run
+			cpu.GDB.Poll(cpu)
 		}
 
-		if cpu.DMAActive {
-			if cpu.DMACycles > 0 {
-				cpu.Memory[0xFE00+uint16(160-cpu.DMACycles)] = cpu.Memory[cpu.DMASourceBase+uint16(160-cpu.DMACycles)]
-				cpu.DMACycles--
-			} else {
-				cpu.DMAActive = false
+		if cpu.Rewind != nil && cpu.Rewind.Rewinding() {
+			if _, err := cpu.Rewind.StepBack(cpu); err != nil {
+				log.Printf("Failed to rewind: %v", err)
 			}
-		}
+		} else if cpu.Speed.NextFrame() {
+			input := cpu.KeyboardButtons
+			if cpu.Movie != nil {
+				input = cpu.Movie.NextInput(input)
+			}
+			cpu.SetJoypad(input)
 
-		// super cludge, just want to make sure there is a little delay
-		// otherwise it loops at a constant rate
-		if i%100 != 0 {
-			cpu.Memory[0xFF44]++
-		}
-		if cpu.Memory[0xFF44] == 144 {
-			cpu.RequestVBlank()
-		}
-		// if cpu.Memory[0xFF44] == cpu.Memory[0xFF45] {
-		// 	cpu.RequestStatInterrupt()
-		// }
-		// elapsed := time.Since(start)
-		// cycleTime := time.Since(cycleStart)
-		// avgCycleTime := elapsed / time.Duration(i+1)
-		// log.Printf("Executed %d instructions, PC: 0x%04X, clock: %d, Avg cycle: %v, Current cycle: %v",
-		// 	i, cpu.PC, cpu.Clock, avgCycleTime, cycleTime)
-		err := cpu.CheckError()
-		if err != nil {
-			log.Printf("Test has failed")
-			break
+			if err := cpu.RunFrame(); err != nil {
+				if lockup, ok := err.(*Lockup); ok {
+					log.Print(lockup.Report(cpu))
+				} else {
+					log.Printf("Test has failed: %v", err)
+				}
+				break
+			}
+			frames++
+			limiter.Tally()
+			if frames == screenshotAfter {
+				cpu.saveScreenshot()
+			}
+
+			if cpu.Lockup != nil && cpu.Lockup != reportedLockup {
+				log.Print(cpu.Lockup.Report(cpu))
+				reportedLockup = cpu.Lockup
+			}
+
+			if cpu.Rewind != nil {
+				if err := cpu.Rewind.Record(cpu); err != nil {
+					log.Printf("Failed to record rewind snapshot: %v", err)
+				}
+			}
 		}
 
-		if cpu.Clock >= 456 {
+		// when fast-forwarding, only draw as often as the host display
+		// could show it anyway and don't throttle
+		fastForward := cpu.Speed.FastForwarding() && !cpu.Speed.Paused()
+		if !fastForward || time.Since(lastPresent) >= time.Second/60 {
 			cpu.RenderGameBoy()
 
 			// Clear the renderer
@@ -355,24 +505,45 @@ func RunProgram(cpu *CPU, maxCycles int) {
 
 			// Present the renderer
 			cpu.Renderer.Present()
+			lastPresent = time.Now()
+		}
 
-			cpu.Clock = cpu.Clock % 114
-			cpu.Memory[0xFF44] = 0
+		if !fastForward {
+			limiter.SetRate(FrameRate * cpu.Speed.Multiplier)
+			limiter.Wait()
 		}
-		time.Sleep(100)
 
+		if time.Since(lastTitle) >= time.Second/2 {
+			title := fmt.Sprintf("Gopherboy - %.2f/%.2f fps", limiter.FPS(), FrameRate*cpu.Speed.Multiplier)
+			if cpu.Lockup != nil {
+				title += " [locked up]"
+			} else if cpu.Stopped {
+				title += " [stopped]"
+			} else if cpu.Rewind != nil && cpu.Rewind.Rewinding() {
+				title += " [rewind: " + cpu.Rewind.String() + "]"
+			} else if state := cpu.Speed.String(); state != "" {
+				title += " [" + state + "]"
+			}
+			window.SetTitle(title)
+			lastTitle = time.Now()
+		}
 	}
 
 	totalTime := time.Since(start)
-	avgCycleTime := totalTime / time.Duration(maxCycles)
 	log.Printf("Program execution stopped. PC: 0x%04X, Halted: %v", cpu.PC, cpu.Halted)
-	log.Printf("Total execution time: %v, Average cycle time: %v", totalTime, avgCycleTime)
+	if totalTime > 0 {
+		log.Printf("Ran %d frames in %v: %.2f fps (target %.2f fps)",
+			frames, totalTime, float64(frames)/totalTime.Seconds(), FrameRate)
+	}
+	if cpu.Rewind != nil {
+		log.Printf("Rewind buffer: %s", cpu.Rewind)
+	}
 
 	// Dump memory contents to file
 	if err := DumpMemoryToFile(cpu, "memory_dump.bin"); err != nil {
 		log.Printf("Failed to dump memory: %v", err)
 	} else {
-		log.Printf("Memory dumped to memory_dump.hex")
+		log.Printf("Memory dumped to memory_dump.bin")
 	}
 
 	if cpu.Texture != nil {
@@ -408,8 +579,40 @@ func DumpMemoryToFile(cpu *CPU, filename string) error {
 func main() {
 	// Parse command-line flags
 	romFile := flag.String("rom", "", "Path to Game Boy ROM file")
-	maxCycles := flag.Int("cycles", 5000000, "Maximum number of CPU cycles to execute")
-	debug := flag.Bool("debug", false, "Enable debug output")
+	maxFrames := flag.Int("frames", 0, "Number of frames to run before stopping (0 runs until the window is closed)")
+	vsync := flag.Bool("vsync", false, "Synchronise presentation with the display refresh")
+	speed := flag.Float64("speed", 1, "Emulation speed multiplier (0.25 to 8)")
+	fastForward := flag.Bool("fast-forward", false, "Start with fast-forward (unthrottled) toggled on")
+	paused := flag.Bool("paused", false, "Start paused (N advances one frame, P resumes)")
+	rewindSeconds := flag.Float64("rewind-seconds", 10, "Seconds of gameplay kept for rewinding with Backspace (0 disables rewind)")
+	rewindInterval := flag.Int("rewind-interval", 2, "Frames between rewind snapshots")
+	rewindMemory := flag.Int("rewind-memory", 32, "Memory budget for rewind snapshots in MB")
+	loadState := flag.String("load-state", "", "Save state file to start from")
+	recordMovie := flag.String("record-movie", "", "Record joypad input to a movie file")
+	playMovie := flag.String("play-movie", "", "Play back joypad input from a movie file")
+	movieCheck := flag.Bool("movie-check", false, "With -play-movie: replay headless and fail if the final frame differs from the recording")
+	debug := flag.Bool("debug", false, "Start in the interactive debugger (F12 breaks into it at any time)")
+	gdbAddr := flag.String("gdb", "", "Listen for a GDB remote debugger on this address, e.g. localhost:2345")
+	traceFile := flag.String("trace", "", "Write a Gameboy Doctor format trace of every instruction to a file")
+	traceCompare := flag.String("trace-compare", "", "Compare the trace against a reference log and stop at the first difference")
+	traceRange := flag.String("trace-range", "", "Only trace instructions in a PC range, e.g. 0100-7FFF")
+	traceLimit := flag.Uint64("trace-limit", 0, "Stop tracing after this many instructions (0 for no limit)")
+	traceLabels := flag.Bool("trace-labels", false, "Write a line with the label before labelled instructions in the trace")
+	ldbb := flag.String("ld-bb", "off", "What ld b,b does: off, log, or break into the debugger")
+	model := flag.String("model", "auto", "Hardware to emulate: dmg, mgb (Game Boy Pocket), sgb, cgb, agb, or auto to pick from the cartridge header")
+	palette := flag.String("palette", PalettePresets[0].Name, "Colours for DMG games: grey, dmg (green), pocket, light, cgb, or a palette from -palette-file (C cycles through them)")
+	paletteFile := flag.String("palette-file", "", "File of custom palettes in hex; defaults to "+PalettePath()+" if there is one")
+	screenshotAfter := flag.Int("screenshot-after", 0, "Save a screenshot after this many frames (0 for none; S saves one at any time)")
+	screenshotFile := flag.String("screenshot-file", "", "PNG file screenshots are written to; defaults to the next free game-NNN.png next to the ROM")
+	screenshotScale := flag.Int("screenshot-scale", 1, "Integer factor screenshots are scaled up by")
+	screenshotIndexed := flag.Bool("screenshot-indexed", false, "Save screenshots as a paletted PNG of the shades 0-3 rather than the colours shown")
+	illegalOpcode := flag.String("illegal-opcode", "lock", "What an illegal opcode does: lock the CPU up like hardware, break into the debugger, or error to stop")
+	debugMessages := flag.Bool("debug-messages", true, "Print ld d,d debug messages to stderr")
+	strict := flag.Bool("strict", false, "Warn about code that works in the emulator but not on hardware")
+	strictOff := flag.String("strict-off", "", "Comma separated -strict checks to turn off: vram, rom-write, uninit, unusable, stack, lcd-off, exec")
+	strictBreak := flag.Bool("strict-break", false, "Break into the debugger on -strict warnings")
+	symFile := flag.String("sym", "", "Symbol file (RGBDS or no$gmb format); defaults to the ROM's .sym file if there is one")
+	disasm := flag.String("disasm", "", "Disassemble the ROM and exit: \"all\" or a range like 0150-01FF or 02:4000-02:7FFF")
 
 	flag.Parse()
 
@@ -426,19 +629,220 @@ func main() {
 	if err := LoadROM(cpu, *romFile); err != nil {
 		log.Fatalf("Failed to load ROM: %v", err)
 	}
-	if err := LoadBoot(cpu, "boot.gb"); err != nil {
-		log.Fatalf("Failed to load boot ROM: %v", err)
+	hardware, err := ParseModel(*model)
+	if err != nil {
+		log.Fatalf("Invalid -model: %v", err)
+	}
+	cpu.SetModel(hardware)
+	if cpu.CGBMode {
+		log.Printf("Running as %s in CGB mode", cpu.Model)
+	} else {
+		log.Printf("Running as %s", cpu.Model)
+	}
+	if *paletteFile == "" {
+		if _, err := os.Stat(PalettePath()); err == nil {
+			*paletteFile = PalettePath()
+		}
+	}
+	if *paletteFile != "" {
+		custom, err := LoadPalettes(*paletteFile)
+		if err != nil {
+			log.Fatalf("Failed to load palettes: %v", err)
+		}
+		for _, p := range custom {
+			if _, err := FindPalette(cpu.Palettes, p.Name); err == nil {
+				log.Fatalf("Palette %q in %s is already defined", p.Name, *paletteFile)
+			}
+			cpu.Palettes = append(cpu.Palettes[:len(cpu.Palettes):len(cpu.Palettes)], p)
+		}
+	}
+	if cpu.Palette, err = FindPalette(cpu.Palettes, *palette); err != nil {
+		log.Fatalf("Invalid -palette: %v", err)
+	}
+	if *screenshotScale < 1 {
+		log.Fatalf("Invalid -screenshot-scale: %d is not a positive whole number", *screenshotScale)
+	}
+	cpu.Screenshot = ScreenshotOptions{Scale: *screenshotScale, Indexed: *screenshotIndexed, Path: *screenshotFile}
+	if *symFile == "" {
+		if _, err := os.Stat(SymbolPath(*romFile)); err == nil {
+			*symFile = SymbolPath(*romFile)
+		}
+	}
+	if *symFile != "" {
+		symbols, err := LoadSymbols(*symFile)
+		if err != nil {
+			log.Fatalf("Failed to load symbols: %v", err)
+		}
+		cpu.Symbols = symbols
+		log.Printf("Loaded %d symbols from %s", symbols.Len(), *symFile)
+	}
+	if *disasm != "" {
+		if err := cpu.DisassembleROM(os.Stdout, *disasm); err != nil {
+			log.Fatalf("Failed to disassemble: %v", err)
+		}
+		return
+	}
+	if cpu.Model == ModelDMG {
+		if err := LoadBoot(cpu, "boot.gb"); err != nil {
+			log.Fatalf("Failed to load boot ROM: %v", err)
+		}
+	} else {
+		// boot.gb is the DMG boot ROM, so start other models where theirs
+		// would have left off
+		cpu.ResetPostBoot()
+	}
+
+	if err := cpu.Speed.SetMultiplier(*speed); err != nil {
+		log.Fatalf("Invalid -speed: %v", err)
+	}
+	cpu.Speed.SetFastForward(*fastForward)
+	if *paused {
+		cpu.Speed.Pause()
+	}
+
+	if *loadState != "" {
+		file, err := os.Open(*loadState)
+		if err != nil {
+			log.Fatalf("Failed to open save state: %v", err)
+		}
+		err = cpu.LoadState(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("Failed to load save state: %v", err)
+		}
+	}
+
+	if *recordMovie != "" && *playMovie != "" {
+		log.Fatal("Use only one of -record-movie and -play-movie")
+	}
+	if *movieCheck && *playMovie == "" {
+		log.Fatal("-movie-check needs a movie to play with -play-movie")
+	}
+	if *playMovie != "" {
+		movie, err := LoadMovie(*playMovie)
+		if err != nil {
+			log.Fatalf("Failed to load movie: %v", err)
+		}
+		if *movieCheck {
+			if err := PlayMovieHeadless(cpu, movie); err != nil {
+				log.Fatalf("Movie check failed: %v", err)
+			}
+			log.Printf("Movie check passed: %d frames, final frame matches", len(movie.Inputs))
+			return
+		}
+		if err := movie.Start(cpu); err != nil {
+			log.Fatalf("Failed to play movie: %v", err)
+		}
+		cpu.Movie = movie
+	}
+	if *recordMovie != "" {
+		movie, err := NewMovieRecording(cpu, *loadState != "")
+		if err != nil {
+			log.Fatalf("Failed to start recording: %v", err)
+		}
+		cpu.Movie = movie
+	}
+	if cpu.Movie != nil && *rewindSeconds > 0 {
+		log.Printf("Rewind is disabled while a movie is recording or playing")
+		*rewindSeconds = 0
+	}
+
+	if *rewindSeconds > 0 {
+		if *rewindInterval < 1 {
+			log.Fatalf("Invalid -rewind-interval: must be at least 1 frame")
+		}
+		cpu.Rewind = NewRewindBuffer(*rewindSeconds, *rewindInterval, *rewindMemory<<20)
+	}
+
+	if *traceFile != "" || *traceCompare != "" {
+		var out io.Writer
+		if *traceFile != "" {
+			file, err := os.Create(*traceFile)
+			if err != nil {
+				log.Fatalf("Failed to create trace: %v", err)
+			}
+			defer file.Close()
+			out = file
+		}
+		cpu.Tracer = NewTracer(out)
+		if *traceRange != "" {
+			if err := cpu.Tracer.SetRange(*traceRange); err != nil {
+				log.Fatalf("Invalid -trace-range: %v", err)
+			}
+		}
+		cpu.Tracer.Limit = *traceLimit
+		cpu.Tracer.Labels = *traceLabels
+		if *traceCompare != "" {
+			file, err := os.Open(*traceCompare)
+			if err != nil {
+				log.Fatalf("Failed to open reference trace: %v", err)
+			}
+			defer file.Close()
+			cpu.Tracer.Compare(file)
+		}
+	}
+
+	if *gdbAddr != "" {
+		cpu.GDB = NewGDBServer()
+		if err := cpu.GDB.Listen(*gdbAddr); err != nil {
+			log.Fatalf("Failed to start gdb server: %v", err)
+		}
+		defer cpu.GDB.Close()
+		log.Printf("Waiting for gdb on %s (target remote %s)", *gdbAddr, *gdbAddr)
+	}
+
+	sourceBreak, err := ParseSourceBreakMode(*ldbb)
+	if err != nil {
+		log.Fatalf("Invalid -ld-bb: %v", err)
+	}
+	if cpu.IllegalOpcodes, err = ParseIllegalOpcodePolicy(*illegalOpcode); err != nil {
+		log.Fatalf("Invalid -illegal-opcode: %v", err)
+	}
+	if sourceBreak != SourceBreakOff || *debugMessages {
+		cpu.Conventions = &DebugConventions{SourceBreak: sourceBreak, Messages: *debugMessages, Out: os.Stderr}
+	}
+
+	if *strict {
+		cpu.Sanitizer = NewSanitizer(os.Stderr)
+		if err := cpu.Sanitizer.Disable(*strictOff); err != nil {
+			log.Fatalf("Invalid -strict-off: %v", err)
+		}
+		cpu.Sanitizer.Break = *strictBreak
 	}
 
 	// Set debug level if needed
 	if *debug {
-		log.Printf("Debug mode enabled")
-		// You can add more detailed debug setup here
+		log.Printf("Debug mode enabled, type help at the (gbdb) prompt")
+		cpu.AttachDebugger().Break("start")
 	}
 
 	// Run the program
-	log.Printf("Starting program execution with max %d cycles", *maxCycles)
-	RunProgram(cpu, *maxCycles)
+	log.Printf("Starting program execution at %.2f fps", FrameRate)
+	RunProgram(cpu, *maxFrames, *vsync, *screenshotAfter)
+
+	if *recordMovie != "" {
+		cpu.Movie.Finish(cpu)
+		if err := cpu.Movie.Save(*recordMovie); err != nil {
+			log.Fatalf("Failed to save movie: %v", err)
+		}
+		log.Printf("Recorded %d frames to %s", len(cpu.Movie.Inputs), *recordMovie)
+	}
+	if *playMovie != "" {
+		if err := cpu.Movie.Check(cpu); err != nil {
+			log.Printf("Movie playback diverged: %v", err)
+		}
+	}
+	if cpu.Tracer != nil {
+		if err := cpu.Tracer.Close(); err != nil {
+			log.Fatalf("Failed to write trace: %v", err)
+		}
+		if cpu.Tracer.Err != nil {
+			log.Fatalf("Trace compare failed: %v", cpu.Tracer.Err)
+		}
+		if *traceCompare != "" {
+			log.Printf("Trace matches the reference for %d instructions", cpu.Tracer.Matched)
+		}
+	}
 
 	log.Printf("Emulation complete")
 }
@@ -0,0 +1,126 @@
+package main
+
+import "fmt"
+
+// Model is the Game Boy hardware being emulated. The models differ in the
+// registers their boot ROMs leave behind, which games use to tell them
+// apart, in what hardware they have and in a few quirks:
+//
+//   - DMG: the original Game Boy. Writing STAT can request a STAT
+//     interrupt (see writeSTAT), and STOP with the LCD on shows black.
+//   - MGB: the Game Boy Pocket, a DMG that boots with A=0xFF.
+//   - SGB: the Super Game Boy, a DMG inside a SNES cartridge, which colours
+//     the screen and draws a border around it, see sgb.go.
+//   - CGB: the Game Boy Color, with the colour features of cgb.go for games
+//     that support them. It shows the rest with a compatibility palette.
+//   - AGB: the Game Boy Advance running Game Boy games, a CGB that boots
+//     with bit 0 of B set.
+type Model int
+
+const (
+	ModelAuto Model = iota // picked from the cartridge header, see SetModel
+	ModelDMG
+	ModelMGB
+	ModelSGB
+	ModelCGB
+	ModelAGB
+)
+
+var modelNames = map[Model]string{
+	ModelAuto: "auto",
+	ModelDMG:  "dmg",
+	ModelMGB:  "mgb",
+	ModelSGB:  "sgb",
+	ModelCGB:  "cgb",
+	ModelAGB:  "agb",
+}
+
+func ParseModel(s string) (Model, error) {
+	for model, name := range modelNames {
+		if s == name {
+			return model, nil
+		}
+	}
+	return ModelAuto, fmt.Errorf("unknown model %q (auto, dmg, mgb, sgb, cgb or agb)", s)
+}
+
+func (m Model) String() string {
+	return modelNames[m]
+}
+
+// IsCGB reports whether the model is a Game Boy Color or runs like one.
+func (m Model) IsCGB() bool {
+	return m == ModelCGB || m == ModelAGB
+}
+
+// IsSGB reports whether the model is a Super Game Boy.
+func (m Model) IsSGB() bool {
+	return m == ModelSGB
+}
+
+// Cartridge header bytes saying which hardware a game supports. Bit 7 of
+// cgbFlag is set if the game supports the CGB, with or without also running
+// on the DMG. A game supports the SGB if sgbFlag is 0x03 and it uses the
+// new licensee code, which oldLicensee being 0x33 says.
+const (
+	cgbFlag     = 0x0143
+	sgbFlag     = 0x0146
+	oldLicensee = 0x014B
+)
+
+// SetModel picks the hardware to emulate for the loaded ROM. ModelAuto
+// picks the CGB for games that support it, then the SGB, then the DMG. The
+// CGB only turns its colour features on for games whose header says they
+// support them; it runs the rest in DMG compatibility mode.
+func (cpu *CPU) SetModel(model Model) {
+	supportsCGB := cpu.ROM[cgbFlag]&0x80 != 0
+	if model == ModelAuto {
+		switch {
+		case supportsCGB:
+			model = ModelCGB
+		case cpu.ROM[sgbFlag] == 0x03 && cpu.ROM[oldLicensee] == 0x33:
+			model = ModelSGB
+		default:
+			model = ModelDMG
+		}
+	}
+	cpu.Model = model
+	cpu.CGBMode = model.IsCGB() && supportsCGB
+	switch {
+	case cpu.CGBMode:
+		cpu.resetCGB()
+	case model.IsCGB():
+		cpu.setCompatibilityPalettes()
+	case model.IsSGB():
+		cpu.resetSGB()
+	}
+}
+
+// postBootRegisters returns AF, BC, DE and HL as the model's boot ROM
+// leaves them. The DMG and MGB boot ROMs clear H and C if the header
+// checksum is 0. In DMG compatibility mode the CGB's B and HL depend on the
+// game's title; these are the values for a game it doesn't recognise.
+func (cpu *CPU) postBootRegisters() (af, bc, de, hl uint16) {
+	switch cpu.Model {
+	case ModelSGB:
+		return 0x0100, 0x0014, 0x0000, 0xC060
+	case ModelCGB, ModelAGB:
+		af, bc, de, hl = 0x1180, 0x0000, 0x0008, 0x007C
+		if cpu.CGBMode {
+			de, hl = 0xFF56, 0x000D
+		}
+		if cpu.Model == ModelAGB {
+			// the AGB boot ROM ends with INC B
+			af, bc = 0x1100, bc+0x0100
+		}
+		return af, bc, de, hl
+	}
+	af, bc, de, hl = 0x01B0, 0x0013, 0x00D8, 0x014D
+	if cpu.Model == ModelMGB {
+		af = 0xFFB0
+	}
+	if cpu.ROM[0x014D] == 0 {
+		af &^= 0x0030
+	}
+	return af, bc, de, hl
+}
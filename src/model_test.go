@@ -0,0 +1,120 @@
+package main
+
+import "testing"
+
+func TestSetModel(t *testing.T) {
+	for _, test := range []struct {
+		header  [3]uint8 // CGB flag, SGB flag, old licensee
+		model   Model
+		actual  Model
+		cgbMode bool
+	}{
+		{[3]uint8{0x00, 0x00, 0x01}, ModelAuto, ModelDMG, false},
+		{[3]uint8{0x80, 0x00, 0x33}, ModelAuto, ModelCGB, true},
+		{[3]uint8{0xC0, 0x03, 0x33}, ModelAuto, ModelCGB, true},
+		{[3]uint8{0x00, 0x03, 0x33}, ModelAuto, ModelSGB, false},
+		// the SGB flag only counts with the new licensee code
+		{[3]uint8{0x00, 0x03, 0x01}, ModelAuto, ModelDMG, false},
+		{[3]uint8{0x80, 0x00, 0x33}, ModelDMG, ModelDMG, false},
+		{[3]uint8{0x80, 0x00, 0x33}, ModelAGB, ModelAGB, true},
+		// a DMG game on a CGB runs in compatibility mode
+		{[3]uint8{0x00, 0x00, 0x01}, ModelCGB, ModelCGB, false},
+	} {
+		cpu := InitCPU()
+		cpu.ROM[cgbFlag], cpu.ROM[sgbFlag], cpu.ROM[oldLicensee] = test.header[0], test.header[1], test.header[2]
+		cpu.SetModel(test.model)
+		if cpu.Model != test.actual || cpu.CGBMode != test.cgbMode {
+			t.Errorf("header % X, -model %s: model %s, CGB mode %t", test.header, test.model, cpu.Model, cpu.CGBMode)
+		}
+	}
+}
+
+func TestParseModel(t *testing.T) {
+	for model, name := range modelNames {
+		if parsed, err := ParseModel(name); err != nil || parsed != model {
+			t.Errorf("ParseModel(%q) = %s, %v", name, parsed, err)
+		}
+	}
+	if _, err := ParseModel("gba"); err == nil {
+		t.Errorf("ParseModel accepted gba")
+	}
+}
+
+func TestPostBootRegisters(t *testing.T) {
+	for _, test := range []struct {
+		model          Model
+		cgbFlag        uint8
+		af, bc, de, hl uint16
+	}{
+		{ModelDMG, 0x00, 0x01B0, 0x0013, 0x00D8, 0x014D},
+		{ModelMGB, 0x00, 0xFFB0, 0x0013, 0x00D8, 0x014D},
+		{ModelSGB, 0x00, 0x0100, 0x0014, 0x0000, 0xC060},
+		{ModelCGB, 0x80, 0x1180, 0x0000, 0xFF56, 0x000D},
+		{ModelCGB, 0x00, 0x1180, 0x0000, 0x0008, 0x007C},
+		{ModelAGB, 0x80, 0x1100, 0x0100, 0xFF56, 0x000D},
+	} {
+		cpu := InitCPU()
+		cpu.ROM[cgbFlag] = test.cgbFlag
+		cpu.ROM[0x014D] = 0x42
+		cpu.SetModel(test.model)
+		cpu.ResetPostBoot()
+		if af, bc, de, hl := cpu.GetAF(), cpu.GetBC(), cpu.GetDE(), cpu.GetHL(); af != test.af || bc != test.bc || de != test.de || hl != test.hl {
+			t.Errorf("%s, CGB flag %02X: AF=%04X BC=%04X DE=%04X HL=%04X, expected AF=%04X BC=%04X DE=%04X HL=%04X",
+				test.model, test.cgbFlag, af, bc, de, hl, test.af, test.bc, test.de, test.hl)
+		}
+	}
+
+	// the DMG boot ROM leaves H and C clear for a header checksum of 0
+	cpu := InitCPU()
+	cpu.SetModel(ModelDMG)
+	cpu.ResetPostBoot()
+	if f := cpu.Flags.Value(); f != 0x80 {
+		t.Errorf("F=%02X with a zero header checksum, expected 80", f)
+	}
+}
+
+func TestSTATWriteBug(t *testing.T) {
+	for _, test := range []struct {
+		model     Model
+		stat      uint8
+		requested bool
+	}{
+		{ModelDMG, 0x80, true}, // HBlank
+		{ModelDMG, 0x81, true}, // VBlank
+		{ModelDMG, 0x82, false},
+		{ModelDMG, 0x86, true}, // LY=LYC
+		{ModelCGB, 0x81, false},
+	} {
+		cpu := InitCPU()
+		cpu.SetModel(test.model)
+		cpu.Memory[0xFF41] = test.stat
+		cpu.writeSTAT(0x08)
+		if requested := cpu.Memory[0xFF0F]&0x02 != 0; requested != test.requested {
+			t.Errorf("%s, STAT %02X: interrupt requested %t", test.model, test.stat, requested)
+		}
+		if stat := cpu.Memory[0xFF41]; stat != 0x88|test.stat&0x07 {
+			t.Errorf("%s: STAT %02X after writing 08 over %02X", test.model, stat, test.stat)
+		}
+	}
+}
+
+func TestCompatibilityPalettes(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	cpu.SetModel(ModelCGB)
+	cpu.Memory[0xFF47] = 0x1B // BGP reversed: colour 0 is black
+	cpu.Memory[0xFE01] = 40   // object 0 at X 32-39
+	cpu.Memory[0xFE04] = 0
+	img := cpu.FrameImage()
+	for _, test := range []struct {
+		x        int
+		expected uint32
+	}{
+		{0, 0xFF000000},  // background colour 0 through BGP
+		{15, 0xFFFFFFFF}, // background colour 3
+		{33, 0xFFFF8484}, // object colour 1 in OBJ palette 0
+	} {
+		if pixel := pixelAt(img, test.x, 0); pixel != test.expected {
+			t.Errorf("x %d is %08X, expected %08X", test.x, pixel, test.expected)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Movie files record the joypad state of every frame so a session can be
+// replayed exactly. Since the emulator is deterministic, replaying the same
+// inputs from the same starting point gives bit-identical output.
+//
+// Layout (little endian):
+//
+//	offset  size  field
+//	0       4     magic "GBMV"
+//	4       2     movie format version (MovieVersion)
+//	6       16    emulator version that recorded it, NUL padded
+//	22      20    SHA-1 of the ROM
+//	42      20    SHA-1 of the boot ROM, all zero if none was loaded
+//	62      1     flags: bit 0 = starts from the embedded save state,
+//	              bit 1 = final framebuffer hash is present
+//	63      1     hardware model: 1 = DMG, 2 = MGB, 3 = SGB, 4 = CGB, 5 = AGB
+//	64      4     number of frames (N)
+//	68      32    SHA-256 of the final framebuffer, see FramebufferHash
+//	100     4     length of the embedded save state (S), 0 if none
+//	104     S     save state, as written by SaveState
+//	104+S   N     one byte of joypad state per frame, see ButtonRight etc.
+//
+// Input for frame i is latched before frame i is emulated.
+const MovieVersion = 3
+
+var movieMagic = [4]byte{'G', 'B', 'M', 'V'}
+
+const (
+	movieFromState   = 1 << 0
+	movieHasFinalFB  = 1 << 1
+	movieHeaderBytes = 104
+)
+
+type MovieHeader struct {
+	Magic           [4]byte
+	Version         uint16
+	EmulatorVersion [16]byte
+	ROMHash         [sha1.Size]byte
+	BootHash        [sha1.Size]byte
+	Flags           uint8
+	Model           uint8
+	Frames          uint32
+	FramebufferHash [sha256.Size]byte
+	SaveStateLength uint32
+}
+
+type Movie struct {
+	Header MovieHeader
+	State  []byte
+	Inputs []uint8
+
+	recording bool
+	pos       int
+}
+
+// NewMovieRecording starts recording from the machine's current state. If
+// fromState is false the machine is expected to be at power on; otherwise a
+// save state is embedded so playback starts from exactly here.
+func NewMovieRecording(cpu *CPU, fromState bool) (*Movie, error) {
+	m := &Movie{recording: true}
+	m.Header.Magic = movieMagic
+	m.Header.Version = MovieVersion
+	copy(m.Header.EmulatorVersion[:], Version)
+	m.Header.ROMHash = cpu.romHash()
+	m.Header.BootHash = cpu.BootHash
+	m.Header.Model = uint8(cpu.Model)
+
+	if fromState {
+		var buf bytes.Buffer
+		if err := cpu.SaveState(&buf); err != nil {
+			return nil, err
+		}
+		m.State = buf.Bytes()
+		m.Header.Flags |= movieFromState
+	}
+	return m, nil
+}
+
+// ReadMovie loads a movie for playback.
+func ReadMovie(r io.Reader) (*Movie, error) {
+	m := &Movie{}
+	if err := binary.Read(r, binary.LittleEndian, &m.Header); err != nil {
+		return nil, fmt.Errorf("error reading movie header: %v", err)
+	}
+	if m.Header.Magic != movieMagic {
+		return nil, fmt.Errorf("not a movie file")
+	}
+	if m.Header.Version != MovieVersion {
+		return nil, fmt.Errorf("movie format version %d is not supported (expected version %d)", m.Header.Version, MovieVersion)
+	}
+
+	// the lengths come from the file, so nothing is allocated on their say
+	// so alone: a save state is never longer than this version's, and the
+	// inputs are only as long as what is actually there
+	if maxState := binary.Size(saveStateHeader{}) + binary.Size(machineState{}); int64(m.Header.SaveStateLength) > int64(maxState) {
+		return nil, fmt.Errorf("movie save state is %d bytes, longer than any save state (%d bytes)", m.Header.SaveStateLength, maxState)
+	}
+	m.State = make([]byte, m.Header.SaveStateLength)
+	if _, err := io.ReadFull(r, m.State); err != nil {
+		return nil, fmt.Errorf("error reading movie save state: %v", err)
+	}
+	inputs, err := io.ReadAll(io.LimitReader(r, int64(m.Header.Frames)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading movie inputs: %v", err)
+	}
+	if len(inputs) != int(m.Header.Frames) {
+		return nil, fmt.Errorf("error reading movie inputs: %v", io.ErrUnexpectedEOF)
+	}
+	m.Inputs = inputs
+	return m, nil
+}
+
+func LoadMovie(path string) (*Movie, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening movie: %v", err)
+	}
+	defer file.Close()
+
+	return ReadMovie(file)
+}
+
+func (m *Movie) Write(w io.Writer) error {
+	m.Header.Frames = uint32(len(m.Inputs))
+	m.Header.SaveStateLength = uint32(len(m.State))
+
+	if err := binary.Write(w, binary.LittleEndian, &m.Header); err != nil {
+		return fmt.Errorf("error writing movie header: %v", err)
+	}
+	if _, err := w.Write(m.State); err != nil {
+		return fmt.Errorf("error writing movie save state: %v", err)
+	}
+	if _, err := w.Write(m.Inputs); err != nil {
+		return fmt.Errorf("error writing movie inputs: %v", err)
+	}
+	return nil
+}
+
+func (m *Movie) Save(path string) error {
+	var buf bytes.Buffer
+	if err := m.Write(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+func (m *Movie) Recording() bool {
+	return m.recording
+}
+
+// Playing reports whether the movie still has frames to play back.
+func (m *Movie) Playing() bool {
+	return !m.recording && m.pos < len(m.Inputs)
+}
+
+// Start prepares the machine for playback, refusing movies recorded with
+// another ROM, boot ROM or model since they could never replay identically.
+func (m *Movie) Start(cpu *CPU) error {
+	if m.Header.ROMHash != cpu.romHash() {
+		return fmt.Errorf("movie was recorded with a different ROM (sha1 %x, loaded ROM is %x)", m.Header.ROMHash, cpu.romHash())
+	}
+	if m.Header.BootHash != cpu.BootHash {
+		return fmt.Errorf("movie was recorded with a different boot ROM")
+	}
+	if model := Model(m.Header.Model); model != cpu.Model {
+		return fmt.Errorf("movie was recorded on %s, not %s", model, cpu.Model)
+	}
+	if m.Header.Flags&movieFromState != 0 {
+		return cpu.LoadState(bytes.NewReader(m.State))
+	}
+	return nil
+}
+
+// NextInput returns the joypad state for the next frame. While recording it
+// stores and returns the live input; during playback it returns the recorded
+// input instead.
+func (m *Movie) NextInput(live uint8) uint8 {
+	if m.recording {
+		m.Inputs = append(m.Inputs, live)
+		return live
+	}
+	if m.pos >= len(m.Inputs) {
+		return live
+	}
+	input := m.Inputs[m.pos]
+	m.pos++
+	return input
+}
+
+// Finish stores the hash of the final framebuffer in a recording.
+func (m *Movie) Finish(cpu *CPU) {
+	m.Header.FramebufferHash = cpu.FramebufferHash()
+	m.Header.Flags |= movieHasFinalFB
+}
+
+// Check compares the framebuffer at the end of playback with the one stored
+// when the movie was recorded.
+func (m *Movie) Check(cpu *CPU) error {
+	if m.Header.Flags&movieHasFinalFB == 0 {
+		return fmt.Errorf("movie has no final framebuffer hash to check against")
+	}
+	if m.pos < len(m.Inputs) {
+		return fmt.Errorf("playback stopped at frame %d of %d", m.pos, len(m.Inputs))
+	}
+	if hash := cpu.FramebufferHash(); hash != m.Header.FramebufferHash {
+		return fmt.Errorf("final framebuffer hash %x does not match recorded %x", hash, m.Header.FramebufferHash)
+	}
+	return nil
+}
+
+// FramebufferHash renders the current frame and returns its SHA-256. Outside
+// CGB mode it hashes the shades rather than the colours shown, so that a
+// movie checks the same whatever palette it was recorded and played in. CGB
+// mode has no shades, so there it hashes the colours.
+func (cpu *CPU) FramebufferHash() [sha256.Size]byte {
+	cpu.RenderFrame()
+	if cpu.CGBMode {
+		return sha256.Sum256(cpu.Pixels)
+	}
+	return sha256.Sum256(cpu.Shades)
+}
+
+// PlayMovieHeadless replays a movie without opening a window, as fast as
+// possible, and checks the final framebuffer.
+func PlayMovieHeadless(cpu *CPU, m *Movie) error {
+	if err := m.Start(cpu); err != nil {
+		return err
+	}
+	for m.Playing() {
+		cpu.SetJoypad(m.NextInput(0))
+		if err := cpu.RunFrame(); err != nil {
+			return fmt.Errorf("frame %d: %v", m.pos, err)
+		}
+	}
+	return m.Check(cpu)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// joypadEchoProgram reads the d-pad in a loop and writes what it sees across
+// the first row of the background map, so input shows up on screen.
+var joypadEchoProgram = []byte{
+	0x21, 0x00, 0x98, // LD HL, 0x9800
+	0x3E, 0x20, // LD A, 0x20 (select the d-pad)
+	0xE0, 0x00, // LD (0xFF00), A
+	0xF0, 0x00, // LD A, (0xFF00)
+	0x77,       // LD (HL), A
+	0x2C,       // INC L
+	0x18, 0xF6, // JR -10
+}
+
+func newMovieTestCPU() *CPU {
+	cpu := InitCPU()
+	copy(cpu.ROM, joypadEchoProgram)
+	copy(cpu.Memory, joypadEchoProgram)
+	cpu.SetModel(ModelDMG)
+	for addr := 0x8000; addr < 0x9800; addr++ {
+		cpu.Memory[addr] = uint8(addr * 7)
+	}
+	cpu.Memory[0xFF47] = 0xE4 // BGP, so the tiles don't all come out white
+	return cpu
+}
+
+func recordTestMovie(t *testing.T, inputs []uint8) *bytes.Buffer {
+	cpu := newMovieTestCPU()
+	movie, err := NewMovieRecording(cpu, false)
+	if err != nil {
+		t.Fatalf("NewMovieRecording: %v", err)
+	}
+	for _, input := range inputs {
+		cpu.SetJoypad(movie.NextInput(input))
+		if err := cpu.RunFrame(); err != nil {
+			t.Fatalf("RunFrame: %v", err)
+		}
+	}
+	movie.Finish(cpu)
+
+	var buf bytes.Buffer
+	if err := movie.Write(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return &buf
+}
+
+func TestMovieHeaderLayout(t *testing.T) {
+	if size := binary.Size(MovieHeader{}); size != movieHeaderBytes {
+		t.Errorf("movie header is %d bytes, documented as %d", size, movieHeaderBytes)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, MovieHeader{Flags: 0x11, Model: 0x22, Frames: 0x33, SaveStateLength: 0x44})
+	for offset, value := range map[int]uint8{62: 0x11, 63: 0x22, 64: 0x33, 100: 0x44} {
+		if got := buf.Bytes()[offset]; got != value {
+			t.Errorf("byte %d is %02X, expected %02X", offset, got, value)
+		}
+	}
+}
+
+func TestMoviePlaybackIsDeterministic(t *testing.T) {
+	inputs := []uint8{0, ButtonRight, ButtonRight, ButtonUp | ButtonLeft, 0, ButtonDown, ButtonA}
+	buf := recordTestMovie(t, inputs)
+
+	movie, err := ReadMovie(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMovie: %v", err)
+	}
+	if !bytes.Equal(movie.Inputs, inputs) {
+		t.Errorf("inputs: expected %v, got %v", inputs, movie.Inputs)
+	}
+	if err := PlayMovieHeadless(newMovieTestCPU(), movie); err != nil {
+		t.Errorf("playback check failed: %v", err)
+	}
+
+	// a movie whose input was tampered with must no longer match
+	data := bytes.Clone(buf.Bytes())
+	data[len(data)-1] = ButtonLeft
+	movie, _ = ReadMovie(bytes.NewReader(data))
+	err = PlayMovieHeadless(newMovieTestCPU(), movie)
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("expected a framebuffer mismatch, got %v", err)
+	}
+}
+
+func TestMovieRejectsDifferentROM(t *testing.T) {
+	buf := recordTestMovie(t, []uint8{0})
+	movie, err := ReadMovie(buf)
+	if err != nil {
+		t.Fatalf("ReadMovie: %v", err)
+	}
+
+	cpu := newMovieTestCPU()
+	cpu.ROM[0x0200] = 0xFF
+	if err := movie.Start(cpu); err == nil || !strings.Contains(err.Error(), "different ROM") {
+		t.Errorf("expected a different ROM error, got %v", err)
+	}
+}
+
+func TestMovieRejectsDifferentModel(t *testing.T) {
+	buf := recordTestMovie(t, []uint8{0})
+	movie, err := ReadMovie(buf)
+	if err != nil {
+		t.Fatalf("ReadMovie: %v", err)
+	}
+
+	cpu := newMovieTestCPU()
+	cpu.SetModel(ModelMGB)
+	if err := movie.Start(cpu); err == nil || !strings.Contains(err.Error(), "recorded on dmg, not mgb") {
+		t.Errorf("expected a different model error, got %v", err)
+	}
+}
+
+func TestReadMovieRejectsBadLengths(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		state uint32
+		input uint32
+	}{
+		{"save state", 0xFFFFFFFF, 0},
+		{"inputs", 0, 0xFFFFFFFF},
+	} {
+		var buf bytes.Buffer
+		header := MovieHeader{Magic: movieMagic, Version: MovieVersion, Frames: test.input, SaveStateLength: test.state}
+		binary.Write(&buf, binary.LittleEndian, &header)
+		buf.Write([]byte{0, 0, 0, 0})
+		if _, err := ReadMovie(&buf); err == nil {
+			t.Errorf("%s: a 4GB length in a short file was accepted", test.name)
+		}
+	}
+}
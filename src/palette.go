@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The DMG's three palette registers, in the order they are in memory from
+// BGP. Each has its own colours in a Palette.
+const (
+	PaletteBG = iota
+	PaletteOBP0
+	PaletteOBP1
+)
+
+// Palette is the colours a DMG screen is shown in, lightest shade first, as
+// ARGB. The background and the two object palettes can differ, like the
+// CGB gives DMG games. The CGB and the SGB colour the screen themselves and
+// don't use it.
+type Palette struct {
+	Name    string
+	Colours [3][4]uint32 // indexed by PaletteBG, PaletteOBP0 and PaletteOBP1
+}
+
+func (p Palette) String() string {
+	return p.Name
+}
+
+// samePalette makes a Palette using the same four colours for everything.
+func samePalette(name string, colours [4]uint32) Palette {
+	return Palette{Name: name, Colours: [3][4]uint32{colours, colours, colours}}
+}
+
+// PalettePresets are the palettes there is always a choice of. The first
+// is the default.
+var PalettePresets = []Palette{
+	samePalette("grey", [4]uint32{0xFFFFFFFF, 0xFFAAAAAA, 0xFF555555, 0xFF000000}),
+	samePalette("dmg", [4]uint32{0xFF9BBC0F, 0xFF8BAC0F, 0xFF306230, 0xFF0F380F}),
+	samePalette("pocket", [4]uint32{0xFFC4CFA1, 0xFF8B956D, 0xFF4D533C, 0xFF1F1F1F}),
+	samePalette("light", [4]uint32{0xFF00B581, 0xFF009A71, 0xFF00694A, 0xFF004F3B}),
+	compatibilityPreset(),
+}
+
+// compatibilityPreset is the palette the CGB gives a game it doesn't know,
+// see setCompatibilityPalettes.
+func compatibilityPreset() Palette {
+	p := Palette{Name: "cgb"}
+	for shade := 0; shade < 4; shade++ {
+		bg := []uint8{uint8(compatibilityBG[shade]), uint8(compatibilityBG[shade] >> 8)}
+		obj := []uint8{uint8(compatibilityOBJ[shade]), uint8(compatibilityOBJ[shade] >> 8)}
+		p.Colours[PaletteBG][shade] = cgbColour(bg, 0, 0)
+		p.Colours[PaletteOBP0][shade] = cgbColour(obj, 0, 0)
+		p.Colours[PaletteOBP1][shade] = cgbColour(obj, 0, 0)
+	}
+	return p
+}
+
+// FindPalette looks a palette up by name.
+func FindPalette(palettes []Palette, name string) (Palette, error) {
+	names := make([]string, len(palettes))
+	for i, p := range palettes {
+		if p.Name == name {
+			return p, nil
+		}
+		names[i] = p.Name
+	}
+	return Palette{}, fmt.Errorf("unknown palette %q (%s)", name, strings.Join(names, ", "))
+}
+
+// ReadPalettes parses a palette file: one palette per line, a name followed
+// by colours in hex as RRGGBB, lightest first, with ';' starting a comment.
+// Four colours are used for everything; twelve are the background's, then
+// OBP0's, then OBP1's.
+//
+//	; name  BG/OBP0/OBP1
+//	mint    E0F8D0 88C070 346856 081820
+func ReadPalettes(r io.Reader) ([]Palette, error) {
+	var palettes []Palette
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), ";")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		name, hex := fields[0], fields[1:]
+		if len(hex) != 4 && len(hex) != 12 {
+			return nil, fmt.Errorf("line %d: expected a name and 4 or 12 colours", line)
+		}
+		var colours []uint32
+		for _, h := range hex {
+			rgb, err := strconv.ParseUint(strings.TrimPrefix(h, "#"), 16, 32)
+			if err != nil || len(strings.TrimPrefix(h, "#")) != 6 {
+				return nil, fmt.Errorf("line %d: invalid colour %q, expected RRGGBB", line, h)
+			}
+			colours = append(colours, 0xFF000000|uint32(rgb))
+		}
+		if len(colours) == 4 {
+			colours = append(colours, append(colours, colours...)...)
+		}
+		p := Palette{Name: name}
+		for i, colour := range colours {
+			p.Colours[i/4][i%4] = colour
+		}
+		palettes = append(palettes, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading palettes: %v", err)
+	}
+	return palettes, nil
+}
+
+func LoadPalettes(path string) ([]Palette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening palettes: %v", err)
+	}
+	defer file.Close()
+
+	return ReadPalettes(file)
+}
+
+// PalettePath returns where custom palettes are read from if -palette-file
+// isn't given: palettes.txt in gopherboy's directory in the user's config
+// directory, e.g. ~/.config/gopherboy/palettes.txt.
+func PalettePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "gopherboy", "palettes.txt")
+}
+
+// CyclePalette switches to the palette after the current one in
+// cpu.Palettes, going back to the first after the last.
+func (cpu *CPU) CyclePalette() Palette {
+	next := 0
+	for i, p := range cpu.Palettes {
+		if p.Name == cpu.Palette.Name {
+			next = (i + 1) % len(cpu.Palettes)
+			break
+		}
+	}
+	cpu.Palette = cpu.Palettes[next]
+	return cpu.Palette
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadPalettes(t *testing.T) {
+	palettes, err := ReadPalettes(strings.NewReader(`
+; custom palettes
+mint   E0F8D0 88C070 346856 081820
+split  #FFFFFF 000000 000000 000000  FF0000 000000 000000 000000  00FF00 000000 000000 000000 ; per palette
+`))
+	if err != nil {
+		t.Fatalf("ReadPalettes: %v", err)
+	}
+	if len(palettes) != 2 {
+		t.Fatalf("read %d palettes, expected 2", len(palettes))
+	}
+	mint, split := palettes[0], palettes[1]
+	if mint.Name != "mint" || mint.Colours[PaletteBG][1] != 0xFF88C070 || mint.Colours[PaletteOBP1][3] != 0xFF081820 {
+		t.Errorf("mint is %v", mint.Colours)
+	}
+	if split.Colours[PaletteBG][0] != 0xFFFFFFFF || split.Colours[PaletteOBP0][0] != 0xFFFF0000 || split.Colours[PaletteOBP1][0] != 0xFF00FF00 {
+		t.Errorf("split is %v", split.Colours)
+	}
+
+	for _, bad := range []string{
+		"short FFFFFF 000000",
+		"digits FFFFFF 000000 000000 00000",
+		"notHex FFFFFF 000000 000000 GGGGGG",
+	} {
+		if _, err := ReadPalettes(strings.NewReader(bad)); err == nil {
+			t.Errorf("%q was accepted", bad)
+		}
+	}
+}
+
+func TestFindPalette(t *testing.T) {
+	p, err := FindPalette(PalettePresets, "pocket")
+	if err != nil || p.Name != "pocket" {
+		t.Errorf("found %v, %v", p, err)
+	}
+	if _, err := FindPalette(PalettePresets, "purple"); err == nil || !strings.Contains(err.Error(), "grey, dmg") {
+		t.Errorf("expected the presets to be listed, got %v", err)
+	}
+}
+
+func TestCyclePalette(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Palettes = PalettePresets[:2]
+	if p := cpu.CyclePalette(); p.Name != PalettePresets[1].Name {
+		t.Errorf("cycled to %s", p)
+	}
+	if p := cpu.CyclePalette(); p.Name != PalettePresets[0].Name {
+		t.Errorf("cycled to %s, expected to go back to the first", p)
+	}
+}
+
+func TestObjectPalettes(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	cpu.Palette = compatibilityPreset()
+	cpu.Memory[0xFE07] = 0x10 // object 1 uses OBP1
+	cpu.Memory[0xFF49] = 0xE4
+	img := cpu.FrameImage()
+	if pixel := pixelAt(img, 19, 0); pixel != cpu.Palette.Colours[PaletteOBP0][1] {
+		t.Errorf("OBP0 object is %08X", pixel)
+	}
+	if pixel := pixelAt(img, 8, 0); pixel != cpu.Palette.Colours[PaletteOBP1][2] {
+		t.Errorf("OBP1 object is %08X", pixel)
+	}
+	if pixel := pixelAt(img, 30, 0); pixel != cpu.Palette.Colours[PaletteBG][0] {
+		t.Errorf("background is %08X", pixel)
+	}
+}
+
+func TestFramebufferHashIgnoresPalette(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	hash := cpu.FramebufferHash()
+	cpu.Palette = PalettePresets[1]
+	if cpu.FramebufferHash() != hash {
+		t.Errorf("hash changed with the palette")
+	}
+	// the screen is left in the palette being shown
+	pixels := append([]uint8(nil), cpu.Pixels...)
+	cpu.RenderFrame()
+	if !bytes.Equal(pixels, cpu.Pixels) {
+		t.Errorf("hashing changed the colours on screen")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"image"
+	"sort"
 	"unsafe"
 )
 
@@ -48,96 +50,276 @@ func (cpu *CPU) BuildFrame() [][]uint32 {
 		addr = bgTileDataModeAddr + uint16(tileId*16) + uint16(tilePixelY*2)
 
 		pixel := interleaveTilePixel(cpu.Memory[addr], cpu.Memory[addr+1], 7-tilePixelX)
-		fb[ly][x] = colourizePixel(int(pixel))
+		fb[ly][x] = cpu.Palette.Colours[PaletteBG][pixel]
 	}
 
 	return fb
 }
-func colourizePixel(input int) uint32 {
-	// The input is expected to be a value between 0 and 3
-	// where 0 is white and 3 is black in the Game Boy's 2-bit color space.
-	switch input {
-	case 0: // White
-		return 0xFFFFFFFF // ARGB for white
-	case 1: // Light gray
-		return 0xFFAAAAAA // ARGB for light gray
-	case 2: // Dark gray
-		return 0xFF555555 // ARGB for dark gray
-	case 3: // Black
-		return 0xFF000000 // ARGB for black
-	default:
-		// If the input is out of range, return a noticeable color (e.g., red)
-		// to indicate an error or unexpected value.
-		return 0xFFFF0000
-	}
-}
 func interleaveTilePixel(low, high, index uint8) uint16 {
 	result := uint16(((high>>index)&0x1)<<1) + uint16((low>>index)&0x1)
 	return result
 }
 
+// scanline is a line of the screen as it is drawn: the colours so far, and
+// what is needed to work out whether an object pixel goes over the
+// background.
+type scanline struct {
+	colours  [160]uint32 // ARGB
+	bg       [160]uint8  // background colour numbers
+	priority [160]bool   // CGB background tiles drawn over objects
+	shades   [160]uint8  // outside CGB mode, the shades the palette registers gave
+}
+
 func buildFb(cpu *CPU, ly uint8, pixels []byte) {
-	bgTileMapModeAddr := bgTileMapMode(cpu)
+	var line scanline
+	cpu.drawBackground(ly, &line)
+	if cpu.Memory[0xFF40]&0x02 != 0 {
+		cpu.drawObjects(ly, &line)
+	}
+	if cpu.Model.IsSGB() {
+		cpu.SGB.colourLine(ly, &line)
+	}
+	copy(cpu.Shades[int(ly)*160:], line.shades[:])
 
-	var tileIndexAddr uint16
-	if bgTileMapModeAddr == 0 {
-		tileIndexAddr = 0x9800
-	} else {
-		tileIndexAddr = 0x9C00
+	for x, colourPixel := range line.colours {
+		// Calculate the position in the pixel array (4 bytes per pixel for RGBA)
+		setRGBA(pixels, (int(ly)*160+x)*4, colourPixel)
 	}
+}
 
-	var (
-		addr        uint16
-		pixel       uint16
-		colourPixel uint32
-		tileX       uint8
-		tileY       uint8
-		tilePixelX  uint8
-		tilePixelY  uint8
-		tileIndex   uint16
-		tileID      uint8
-	)
+// setRGBA stores an ARGB colour at pos in an RGBA pixel array, the format
+// SDL is given.
+func setRGBA(pixels []byte, pos int, colour uint32) {
+	pixels[pos] = uint8((colour >> 16) & 0xFF)   // R
+	pixels[pos+1] = uint8((colour >> 8) & 0xFF)  // G
+	pixels[pos+2] = uint8(colour & 0xFF)         // B
+	pixels[pos+3] = uint8((colour >> 24) & 0xFF) // A
+}
+
+// dmgShade maps a colour number through a DMG palette register (BGP, OBP0
+// or OBP1), which holds a shade for each in 2 bits.
+func dmgShade(palette uint8, colour uint16) int {
+	return int(palette>>(colour*2)) & 0x03
+}
+
+// dmgPixel colours pixel x of a line outside CGB mode through one of the
+// palette registers, PaletteBG, PaletteOBP0 or PaletteOBP1, and then
+// cpu.Palette. A CGB in compatibility mode looks the shade up in its own
+// palettes instead, see setCompatibilityPalettes. The SGB colours the shade
+// later, by where it is on the screen.
+func (cpu *CPU) dmgPixel(line *scanline, x int, palette int, colour uint16) {
+	shade := dmgShade(cpu.Memory[0xFF47+palette], colour)
+	line.shades[x] = uint8(shade)
+	switch {
+	case !cpu.Model.IsCGB():
+		line.colours[x] = cpu.Palette.Colours[palette][shade]
+	case palette == PaletteBG:
+		line.colours[x] = cgbColour(cpu.BGPalettes[:], 0, uint8(shade))
+	default:
+		line.colours[x] = cgbColour(cpu.OBJPalettes[:], uint8(palette-PaletteOBP0), uint8(shade))
+	}
+}
+
+// bgTileAddress returns where in VRAM a background tile's data starts, for
+// the addressing mode LCDC bit 4 selects.
+func bgTileAddress(cpu *CPU, tileID uint8) uint16 {
+	if bgTileDataMode(cpu) == 1 {
+		return uint16(tileID) * 16
+	}
+	// signed tile numbers from 0x9000
+	return uint16(0x1000 + int(int8(tileID))*16)
+}
+
+// drawBackground draws the background for line ly. In CGB mode each map
+// entry has an attribute byte in VRAM bank 1: the palette in bits 0-2, the
+// tile's VRAM bank in bit 3, horizontal and vertical flips in bits 5 and 6,
+// and priority over objects in bit 7.
+func (cpu *CPU) drawBackground(ly uint8, line *scanline) {
+	tileMap := uint16(0x1800)
+	if bgTileMapMode(cpu) == 1 {
+		tileMap = 0x1C00
+	}
+	vram := cpu.vram(0)
 
 	for x := uint8(0); x < 160; x++ {
-		tileX = x / 8
-		tileY = ly / 8
-		tilePixelX = x % 8
-		tilePixelY = ly % 8
-		tileIndex = uint16(tileY)*32 + uint16(tileX)
-		tileID = cpu.Memory[tileIndexAddr+tileIndex]
-
-		if bgTileDataMode(cpu) == 1 {
-			addr = 0x8000 + uint16(tileID)*16 + uint16(tilePixelY)*2
+		tileIndex := tileMap + uint16(ly/8)*32 + uint16(x/8)
+		tileID := vram[tileIndex]
+		var attributes uint8
+		if cpu.CGBMode {
+			attributes = cpu.vram(1)[tileIndex]
+		}
+
+		addr := bgTileAddress(cpu, tileID)
+		tilePixelY := ly % 8
+		if attributes&0x40 != 0 {
+			tilePixelY = 7 - tilePixelY
+		}
+		addr += uint16(tilePixelY) * 2
+		bit := 7 - x%8
+		if attributes&0x20 != 0 {
+			bit = x % 8
+		}
+
+		data := cpu.vram(int(attributes>>3) & 0x01)
+		pixel := interleaveTilePixel(data[addr], data[addr+1], bit)
+		line.bg[x] = uint8(pixel)
+		if cpu.CGBMode {
+			line.colours[x] = cgbColour(cpu.BGPalettes[:], attributes&0x07, uint8(pixel))
+			line.priority[x] = attributes&0x80 != 0
 		} else {
-			if tileID > 127 {
-				addr = 0x8800
-			} else {
-				addr = 0x9000
-			}
-			addr += uint16(tileID)*16 + uint16(tilePixelY)*2
+			cpu.dmgPixel(line, int(x), PaletteBG, pixel)
 		}
+	}
+}
 
-		pixel = interleaveTilePixel(cpu.Memory[addr], cpu.Memory[addr+1], 7-tilePixelX)
-		colourPixel = colourizePixel(int(pixel))
-		// Calculate the position in the pixel array (4 bytes per pixel for RGBA)
-		pos := (int(ly)*160 + int(x)) * 4
-		// Set RGBA values (SDL uses RGBA format)
-		pixels[pos] = uint8((colourPixel >> 16) & 0xFF)   // R
-		pixels[pos+1] = uint8((colourPixel >> 8) & 0xFF)  // G
-		pixels[pos+2] = uint8(colourPixel & 0xFF)         // B
-		pixels[pos+3] = uint8((colourPixel >> 24) & 0xFF) // A
+// ObjectsPerLine is how many objects the PPU finds on each line during its
+// OAM scan. The rest aren't drawn.
+const ObjectsPerLine = 10
+
+// drawObjects draws the objects on line ly over the background. Each OAM
+// entry is Y+16, X+8, a tile number and attributes: the CGB palette in bits
+// 0-2, the CGB VRAM bank in bit 3, the DMG palette (OBP0 or OBP1) in bit 4,
+// flips in bits 5 and 6, and in bit 7 whether background colours 1-3 go
+// over the object.
+//
+// Where objects overlap, on the DMG the one with the smaller X wins, then
+// the one earlier in OAM; on the CGB it is only OAM order. In CGB mode a
+// background tile's priority attribute also puts it over objects, and
+// clearing LCDC bit 0 puts every object over the background instead.
+func (cpu *CPU) drawObjects(ly uint8, line *scanline) {
+	oam := cpu.Memory[0xFE00:0xFEA0]
+	lcdc := cpu.Memory[0xFF40]
+	height := 8
+	if lcdc&0x04 != 0 {
+		height = 16
+	}
+
+	objects := make([]int, 0, ObjectsPerLine)
+	for i := 0; i < 40 && len(objects) < ObjectsPerLine; i++ {
+		y := int(oam[i*4]) - 16
+		if int(ly) >= y && int(ly) < y+height {
+			objects = append(objects, i*4)
+		}
+	}
+	if !cpu.CGBMode {
+		sort.SliceStable(objects, func(a, b int) bool {
+			return oam[objects[a]+1] < oam[objects[b]+1]
+		})
+	}
+
+	var drawn [160]bool
+	for _, object := range objects {
+		y, x := int(oam[object])-16, int(oam[object+1])-8
+		tile, attributes := oam[object+2], oam[object+3]
+		if height == 16 {
+			tile &^= 0x01
+		}
+		row := int(ly) - y
+		if attributes&0x40 != 0 {
+			row = height - 1 - row
+		}
+		addr := uint16(tile)*16 + uint16(row)*2
+		var data []uint8
+		if cpu.CGBMode {
+			data = cpu.vram(int(attributes>>3) & 0x01)
+		} else {
+			data = cpu.vram(0)
+		}
+
+		for i := 0; i < 8; i++ {
+			screenX := x + i
+			if screenX < 0 || screenX >= 160 || drawn[screenX] {
+				continue
+			}
+			bit := uint8(7 - i)
+			if attributes&0x20 != 0 {
+				bit = uint8(i)
+			}
+			pixel := interleaveTilePixel(data[addr], data[addr+1], bit)
+			if pixel == 0 {
+				// transparent, so an object underneath can show
+				continue
+			}
+			drawn[screenX] = true
+
+			bgOver := line.bg[screenX] != 0 && (attributes&0x80 != 0 || line.priority[screenX])
+			if cpu.CGBMode && lcdc&0x01 == 0 {
+				bgOver = false
+			}
+			if bgOver {
+				continue
+			}
+			switch {
+			case cpu.CGBMode:
+				line.colours[screenX] = cgbColour(cpu.OBJPalettes[:], attributes&0x07, uint8(pixel))
+			case attributes&0x10 != 0:
+				cpu.dmgPixel(line, screenX, PaletteOBP1, pixel)
+			default:
+				cpu.dmgPixel(line, screenX, PaletteOBP0, pixel)
+			}
+		}
 	}
 }
 
-func (cpu *CPU) RenderGameBoy() {
+// RenderFrame draws the screen into cpu.Pixels without touching SDL, so it
+// can be used headless.
+func (cpu *CPU) RenderFrame() {
 	// Create a byte array for pixel data (RGBA format, 4 bytes per pixel)
-	pixels := make([]byte, 160*144*4)
+	if cpu.Pixels == nil {
+		cpu.Pixels = make([]byte, 160*144*4)
+		cpu.Shades = make([]uint8, 160*144)
+	}
+
+	if cpu.Stopped && !cpu.Model.IsCGB() && cpu.Memory[0xFF40]&0x80 != 0 {
+		// the DMG shows black while stopped with the LCD on
+		fillPixels(cpu.Pixels, cpu.Palette.Colours[PaletteBG][3])
+		for i := range cpu.Shades {
+			cpu.Shades[i] = 3
+		}
+		return
+	}
+	if cpu.Model.IsSGB() && cpu.SGB.Mask != sgbMaskOff {
+		cpu.SGB.maskScreen(cpu.Pixels)
+		return
+	}
 
 	for ly := uint8(0); ly < 144; ly++ {
-		buildFb(cpu, ly, pixels)
+		buildFb(cpu, ly, cpu.Pixels)
+	}
+}
+
+func fillPixels(pixels []byte, colour uint32) {
+	for pos := 0; pos < len(pixels); pos += 4 {
+		setRGBA(pixels, pos, colour)
+	}
+}
+
+// FrameImage renders the screen and returns a copy of it as an image.
+func (cpu *CPU) FrameImage() *image.RGBA {
+	cpu.RenderFrame()
+	img := image.NewRGBA(image.Rect(0, 0, 160, 144))
+	copy(img.Pix, cpu.Pixels)
+	return img
+}
+
+// ScreenSize is the size of the picture RenderGameBoy shows: the Game Boy's
+// screen, or on the SGB its border with the screen in the middle.
+func (cpu *CPU) ScreenSize() (width, height int) {
+	if cpu.Model.IsSGB() {
+		return SGBWidth, SGBHeight
+	}
+	return 160, 144
+}
+
+func (cpu *CPU) RenderGameBoy() {
+	cpu.RenderGameBoyFrame()
+	pixels := cpu.Pixels
+	if cpu.Model.IsSGB() {
+		pixels = cpu.SGBPixels
 	}
 
 	// Update the texture with the new pixel data
-	pitch := 160 * 4 // 4 bytes per pixel (RGBA)
+	width, _ := cpu.ScreenSize()
+	pitch := width * 4 // 4 bytes per pixel (RGBA)
 	cpu.Texture.Update(nil, unsafe.Pointer(&pixels[0]), pitch)
 }
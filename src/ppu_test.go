@@ -0,0 +1,124 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+// pixelAt returns a pixel of a rendered frame as ARGB, like the colours in a
+// Palette.
+func pixelAt(img *image.RGBA, x, y int) uint32 {
+	c := img.RGBAAt(x, y)
+	return uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
+var grey = PalettePresets[0].Colours[PaletteBG]
+
+// newObjectTestCPU has two overlapping objects on line 0: object 0 in colour
+// 1 at screen X 12-19, and object 1 further left in colour 2 at 8-15. The
+// background is tile 0, colour 0 except for colour 3 at X 15.
+func newObjectTestCPU(cgb bool) *CPU {
+	cpu := InitCPU()
+	if cgb {
+		cpu = newCGBTestCPU()
+	}
+	cpu.Memory[0xFF40] = 0x93 // LCD, BG and objects on, tiles at 0x8000
+	cpu.Memory[0xFF47] = 0xE4
+	cpu.Memory[0xFF48] = 0xE4
+	copy(cpu.Memory[0x8000:], []uint8{0x00, 0x00})
+	copy(cpu.Memory[0x8010:], []uint8{0xFF, 0x00}) // tile 1, colour 1
+	copy(cpu.Memory[0x8020:], []uint8{0x00, 0xFF}) // tile 2, colour 2
+	copy(cpu.Memory[0x9800:], []uint8{0x00, 0x03})
+	copy(cpu.Memory[0x8030:], []uint8{0x01, 0x01}) // tile 3, colour 3 at the right edge
+	copy(cpu.Memory[0xFE00:], []uint8{
+		16, 20, 1, 0x00,
+		16, 16, 2, 0x00,
+	})
+	// OBJ palette 0: colour 1 red, colour 2 green
+	copy(cpu.OBJPalettes[:], []uint8{0xFF, 0x7F, 0x1F, 0x00, 0xE0, 0x03})
+	return cpu
+}
+
+func TestObjectPriority(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		cgb      bool
+		expected uint32
+	}{
+		// the object further left wins
+		{"dmg", false, grey[2]},
+		// the object first in OAM wins
+		{"cgb", true, 0xFFFF0000},
+	} {
+		cpu := newObjectTestCPU(test.cgb)
+		if pixel := pixelAt(cpu.FrameImage(), 13, 0); pixel != test.expected {
+			t.Errorf("%s: overlap is %08X, expected %08X", test.name, pixel, test.expected)
+		}
+	}
+}
+
+func TestObjectBehindBackground(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	cpu.Memory[0xFE03] = 0x80
+	cpu.Memory[0xFE04] = 0 // only object 0
+	img := cpu.FrameImage()
+	// background colour 0 doesn't hide the object
+	if pixel := pixelAt(img, 19, 0); pixel != grey[1] {
+		t.Errorf("object over colour 0 is %08X", pixel)
+	}
+	if pixel := pixelAt(img, 15, 0); pixel != grey[3] {
+		t.Errorf("object over colour 3 is %08X, expected the background", pixel)
+	}
+}
+
+// A hidden pixel of the winning object still hides the objects under it.
+func TestObjectBehindBackgroundHidesOthers(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	cpu.Memory[0xFE01] = 16 // on top of object 1, winning on OAM order
+	cpu.Memory[0xFE03] = 0x80
+	if pixel := pixelAt(cpu.FrameImage(), 15, 0); pixel != grey[3] {
+		t.Errorf("pixel is %08X, expected the background", pixel)
+	}
+}
+
+func TestCGBBackgroundPriority(t *testing.T) {
+	cpu := newObjectTestCPU(true)
+	cpu.Memory[0xFE04] = 0
+	cpu.vram(1)[0x1801] = 0x80 // the second tile goes over objects
+	// BG palette 0 colour 3 blue
+	copy(cpu.BGPalettes[6:], []uint8{0x00, 0x7C})
+
+	if pixel := pixelAt(cpu.FrameImage(), 15, 0); pixel != 0xFF0000FF {
+		t.Errorf("pixel is %08X, expected the background", pixel)
+	}
+	cpu.Memory[0xFF40] &^= 0x01 // objects over everything
+	if pixel := pixelAt(cpu.FrameImage(), 15, 0); pixel != 0xFFFF0000 {
+		t.Errorf("pixel is %08X with LCDC bit 0 clear, expected the object", pixel)
+	}
+}
+
+func TestCGBBackgroundAttributes(t *testing.T) {
+	cpu := newCGBTestCPU()
+	cpu.Memory[0xFF40] = 0x91
+	cpu.Memory[0x9800] = 0x05
+	cpu.vram(1)[0x1800] = 0x02 | 0x08 | 0x20 | 0x40 // palette 2, bank 1, both flips
+	// tile 5 in bank 1: colour 1 at the top left, colour 2 at the bottom left
+	cpu.vram(1)[0x50] = 0x80
+	cpu.vram(1)[0x5F] = 0x80
+	// palette 2: colour 0 black, colour 1 red, colour 2 green
+	copy(cpu.BGPalettes[16:], []uint8{0x00, 0x00, 0x1F, 0x00, 0xE0, 0x03})
+
+	img := cpu.FrameImage()
+	for _, test := range []struct {
+		x, y     int
+		expected uint32
+	}{
+		{0, 0, 0xFF000000},
+		{7, 7, 0xFFFF0000},
+		{7, 0, 0xFF00FF00},
+	} {
+		if pixel := pixelAt(img, test.x, test.y); pixel != test.expected {
+			t.Errorf("(%d, %d) is %08X, expected %08X", test.x, test.y, pixel, test.expected)
+		}
+	}
+}
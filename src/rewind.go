@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// RewindBuffer keeps a bounded history of save states so gameplay can be
+// played backwards. A snapshot is taken every Interval frames into a ring of
+// Capacity entries. Every KeyframeEvery-th snapshot is stored whole; the ones
+// in between are stored as the XOR against the last keyframe, which is almost
+// entirely zeros from frame to frame and so compresses to a few hundred bytes.
+// All entries are deflated.
+type RewindBuffer struct {
+	Interval      int // frames between snapshots
+	Capacity      int // snapshots kept
+	MaxBytes      int // memory budget for compressed snapshots
+	KeyframeEvery int
+
+	ring  []*rewindEntry
+	start int // oldest entry
+	count int
+	bytes int
+
+	held        bool
+	sinceRecord int
+
+	key      *rewindKeyframe
+	keyRaw   []byte
+	sinceKey int
+
+	raw     bytes.Buffer
+	scratch []byte
+	zbuf    bytes.Buffer
+	zw      *flate.Writer
+}
+
+type rewindKeyframe struct {
+	data []byte
+	refs int
+}
+
+type rewindEntry struct {
+	key   *rewindKeyframe
+	delta []byte // nil for the keyframe snapshot itself
+}
+
+// NewRewindBuffer sizes a rewind buffer to hold the given number of seconds
+// of gameplay, taking a snapshot every interval frames, within maxBytes.
+func NewRewindBuffer(seconds float64, interval int, maxBytes int) *RewindBuffer {
+	capacity := max(int(seconds*FrameRate)/interval, 1)
+	zw, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &RewindBuffer{
+		Interval:      interval,
+		Capacity:      capacity,
+		MaxBytes:      maxBytes,
+		KeyframeEvery: 60,
+		ring:          make([]*rewindEntry, capacity),
+		zw:            zw,
+	}
+}
+
+// Hold is driven by the rewind key: frames play backwards while held.
+func (rb *RewindBuffer) Hold(held bool) {
+	rb.held = held
+}
+
+func (rb *RewindBuffer) Rewinding() bool {
+	return rb.held
+}
+
+// Record is called after every emulated frame and takes a snapshot when one
+// is due.
+func (rb *RewindBuffer) Record(cpu *CPU) error {
+	rb.sinceRecord++
+	if rb.sinceRecord < rb.Interval {
+		return nil
+	}
+	rb.sinceRecord = 0
+
+	rb.raw.Reset()
+	if err := cpu.SaveState(&rb.raw); err != nil {
+		return err
+	}
+	raw := rb.raw.Bytes()
+
+	entry := &rewindEntry{}
+	if rb.key == nil || rb.sinceKey >= rb.KeyframeEvery || len(raw) != len(rb.keyRaw) {
+		rb.key = &rewindKeyframe{data: rb.compress(raw)}
+		rb.keyRaw = append(rb.keyRaw[:0], raw...)
+		rb.sinceKey = 0
+	} else {
+		if cap(rb.scratch) < len(raw) {
+			rb.scratch = make([]byte, len(raw))
+		}
+		delta := rb.scratch[:len(raw)]
+		for i := range raw {
+			delta[i] = raw[i] ^ rb.keyRaw[i]
+		}
+		entry.delta = rb.compress(delta)
+	}
+	rb.sinceKey++
+	entry.key = rb.key
+
+	rb.push(entry)
+	for rb.count > 1 && (rb.count > rb.Capacity || (rb.MaxBytes > 0 && rb.bytes > rb.MaxBytes)) {
+		rb.release(rb.ring[rb.start])
+		rb.ring[rb.start] = nil
+		rb.start = (rb.start + 1) % len(rb.ring)
+		rb.count--
+	}
+	return nil
+}
+
+// StepBack restores the most recent snapshot and drops it from the buffer.
+// It returns false when there is no more history.
+func (rb *RewindBuffer) StepBack(cpu *CPU) (bool, error) {
+	if rb.count == 0 {
+		return false, nil
+	}
+	newest := (rb.start + rb.count - 1) % len(rb.ring)
+	entry := rb.ring[newest]
+	rb.ring[newest] = nil
+	rb.count--
+	rb.release(entry)
+	rb.sinceRecord = 0
+
+	raw, err := decompress(entry.key.data)
+	if err != nil {
+		return false, err
+	}
+	if entry.delta != nil {
+		delta, err := decompress(entry.delta)
+		if err != nil {
+			return false, err
+		}
+		if len(delta) != len(raw) {
+			return false, fmt.Errorf("rewind snapshot is corrupt")
+		}
+		for i := range raw {
+			raw[i] ^= delta[i]
+		}
+	}
+	return true, cpu.LoadState(bytes.NewReader(raw))
+}
+
+func (rb *RewindBuffer) push(entry *rewindEntry) {
+	if entry.key.refs == 0 {
+		rb.bytes += len(entry.key.data)
+	}
+	entry.key.refs++
+	rb.bytes += len(entry.delta)
+
+	rb.ring[(rb.start+rb.count)%len(rb.ring)] = entry
+	rb.count++
+}
+
+// release accounts for an entry leaving the buffer. A keyframe's memory is
+// only freed once no remaining entry depends on it.
+func (rb *RewindBuffer) release(entry *rewindEntry) {
+	rb.bytes -= len(entry.delta)
+	entry.key.refs--
+	if entry.key.refs == 0 {
+		rb.bytes -= len(entry.key.data)
+	}
+}
+
+func (rb *RewindBuffer) compress(data []byte) []byte {
+	rb.zbuf.Reset()
+	rb.zw.Reset(&rb.zbuf)
+	rb.zw.Write(data)
+	rb.zw.Close()
+	return bytes.Clone(rb.zbuf.Bytes())
+}
+
+func decompress(data []byte) ([]byte, error) {
+	zr := flate.NewReader(bytes.NewReader(data))
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
+
+// Len returns the number of snapshots held.
+func (rb *RewindBuffer) Len() int {
+	return rb.count
+}
+
+// Seconds returns how far back the buffer currently reaches.
+func (rb *RewindBuffer) Seconds() float64 {
+	return float64(rb.count*rb.Interval) / FrameRate
+}
+
+// Bytes returns the memory held by compressed snapshots.
+func (rb *RewindBuffer) Bytes() int {
+	return rb.bytes
+}
+
+func (rb *RewindBuffer) String() string {
+	return fmt.Sprintf("%d snapshots, %.1fs, %.1f KB", rb.count, rb.Seconds(), float64(rb.bytes)/1024)
+}
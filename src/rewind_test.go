@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestRewindStepsBackThroughSnapshots(t *testing.T) {
+	cpu := InitCPU()
+	rb := NewRewindBuffer(1, 1, 0)
+	rb.KeyframeEvery = 4
+
+	for frame := 0; frame < 10; frame++ {
+		cpu.Memory[0xC000] = uint8(frame)
+		cpu.PC = uint16(0x100 + frame)
+		if err := rb.Record(cpu); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+
+	for frame := 9; frame >= 0; frame-- {
+		ok, err := rb.StepBack(cpu)
+		if err != nil || !ok {
+			t.Fatalf("StepBack to frame %d: ok=%v err=%v", frame, ok, err)
+		}
+		if cpu.Memory[0xC000] != uint8(frame) || cpu.PC != uint16(0x100+frame) {
+			t.Errorf("frame %d: restored memory %d, PC 0x%04X", frame, cpu.Memory[0xC000], cpu.PC)
+		}
+	}
+
+	if ok, _ := rb.StepBack(cpu); ok {
+		t.Errorf("expected the buffer to be empty")
+	}
+	if rb.Bytes() != 0 {
+		t.Errorf("expected no memory held by an empty buffer, got %d bytes", rb.Bytes())
+	}
+}
+
+func TestRewindIsBounded(t *testing.T) {
+	cpu := InitCPU()
+	rb := NewRewindBuffer(1, 1, 0)
+	rb.Capacity = 8
+	rb.KeyframeEvery = 3
+
+	for frame := 0; frame < 50; frame++ {
+		cpu.Memory[0xC000+uint16(frame)] = 0xFF
+		rb.Record(cpu)
+		if rb.Len() > rb.Capacity {
+			t.Fatalf("buffer grew to %d snapshots, capacity %d", rb.Len(), rb.Capacity)
+		}
+	}
+
+	// the oldest snapshots were evicted, but the ones kept must still
+	// decode even though their keyframe may have been dropped
+	for i := 0; i < rb.Capacity; i++ {
+		if ok, err := rb.StepBack(cpu); !ok || err != nil {
+			t.Fatalf("StepBack %d: ok=%v err=%v", i, ok, err)
+		}
+	}
+	if cpu.Memory[0xC000+42] != 0xFF || cpu.Memory[0xC000+43] != 0 {
+		t.Errorf("expected to be back at frame 42")
+	}
+
+	// a tiny byte budget keeps just the newest snapshot
+	rb = NewRewindBuffer(1, 1, 1)
+	for frame := 0; frame < 5; frame++ {
+		rb.Record(cpu)
+	}
+	if rb.Len() != 1 {
+		t.Errorf("expected the byte budget to leave 1 snapshot, got %d", rb.Len())
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Sanitizer checks for things that work in the emulator but not on
+// hardware, and warns with the PC, bank and label of the instruction
+// responsible. Each check is a category that can be turned off. A warning
+// is given once per category and instruction address.
+type Sanitizer struct {
+	Enabled map[string]bool
+	Break   bool // break into the debugger on a warning
+	Out     io.Writer
+
+	pc       uint16 // address of the instruction being executed
+	warned   map[sanitizerKey]bool
+	written  []uint64 // bitmap of addresses the CPU has written
+	liveData []uint64 // bitmap of RAM read as data, see Write
+}
+
+type sanitizerKey struct {
+	category string
+	pc       uint16
+}
+
+// SanitizerChecks lists the categories with what they catch.
+var SanitizerChecks = map[string]string{
+	"vram":      "VRAM access during mode 3, OAM access during modes 2 and 3",
+	"rom-write": "writes to ROM on a cartridge without a mapper",
+	"uninit":    "reads of WRAM or HRAM that was never written",
+	"unusable":  "access to the unusable region FEA0-FEFF",
+	"stack":     "the stack overflowing into data",
+	"lcd-off":   "turning the LCD off outside VBlank",
+	"exec":      "executing from RAM or the echo area (HRAM is fine)",
+}
+
+func NewSanitizer(out io.Writer) *Sanitizer {
+	s := &Sanitizer{
+		Enabled:  map[string]bool{},
+		Out:      out,
+		warned:   map[sanitizerKey]bool{},
+		written:  make([]uint64, 65536/64),
+		liveData: make([]uint64, 65536/64),
+	}
+	for category := range SanitizerChecks {
+		s.Enabled[category] = true
+	}
+	return s
+}
+
+// Disable turns off a comma separated list of categories.
+func (s *Sanitizer) Disable(list string) error {
+	for _, category := range strings.Split(list, ",") {
+		category = strings.TrimSpace(category)
+		if category == "" {
+			continue
+		}
+		if _, ok := SanitizerChecks[category]; !ok {
+			var names []string
+			for name := range SanitizerChecks {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("unknown check %q (%s)", category, strings.Join(names, ", "))
+		}
+		s.Enabled[category] = false
+	}
+	return nil
+}
+
+// StateLoaded is called when a save state replaces memory, which then
+// counts as initialised.
+func (s *Sanitizer) StateLoaded() {
+	for i := range s.written {
+		s.written[i] = ^uint64(0)
+		s.liveData[i] = 0
+	}
+}
+
+func getBit(bits []uint64, address uint16) bool {
+	return bits[address/64]&(1<<(address%64)) != 0
+}
+
+func setBit(bits []uint64, address uint16, value bool) {
+	if value {
+		bits[address/64] |= 1 << (address % 64)
+	} else {
+		bits[address/64] &^= 1 << (address % 64)
+	}
+}
+
+func (s *Sanitizer) warn(cpu *CPU, category, format string, args ...interface{}) {
+	if !s.Enabled[category] {
+		return
+	}
+	key := sanitizerKey{category, s.pc}
+	if s.warned[key] {
+		return
+	}
+	s.warned[key] = true
+
+	message := fmt.Sprintf("strict [%s] %s at %s", category, fmt.Sprintf(format, args...), cpu.DescribeAddress(s.pc))
+	fmt.Fprintln(s.Out, message)
+	if s.Break {
+		if cpu.GDB != nil && cpu.GDB.Attached() {
+			cpu.GDB.Break()
+		} else {
+			cpu.AttachDebugger().Break(message)
+		}
+	}
+}
+
+// lcdMode returns the PPU mode for the current position in the frame, or
+// -1 when the LCD is off.
+func (cpu *CPU) lcdMode() int {
+	if cpu.Memory[0xFF40]&0x80 == 0 {
+		return -1
+	}
+	position := cpu.FrameCycles()
+	line, dot := position/CyclesPerLine%LinesPerFrame, position%CyclesPerLine
+	switch {
+	case line >= VBlankLine:
+		return 1
+	case dot < OAMScanCycles:
+		return 2
+	case dot < OAMScanCycles+TransferCycles:
+		return 3
+	}
+	return 0
+}
+
+func isWorkRAM(address uint16) bool {
+	return (address >= 0xC000 && address < 0xE000) || (address >= 0xFF80 && address < 0xFFFF)
+}
+
+// Execute is called before every instruction.
+func (s *Sanitizer) Execute(cpu *CPU) {
+	s.pc = cpu.PC
+	pc := cpu.PC
+	switch {
+	case pc < 0x8000 || (pc >= 0xFF80 && pc < 0xFFFF):
+	case pc >= 0xE000 && pc < 0xFE00:
+		s.warn(cpu, "exec", "executing from the echo area %04X", pc)
+	default:
+		s.warn(cpu, "exec", "executing from RAM at %04X", pc)
+	}
+}
+
+// Read is called by ReadMemory.
+func (s *Sanitizer) Read(cpu *CPU, address uint16) {
+	s.access(cpu, address, "read from")
+	if isWorkRAM(address) {
+		if !getBit(s.written, address) {
+			s.warn(cpu, "uninit", "read from uninitialised %04X", address)
+		}
+		// data below the stack that the program uses; reads at or above SP
+		// are the stack itself, including locals read through HL
+		if address < cpu.SP {
+			setBit(s.liveData, address, true)
+		}
+	}
+}
+
+// Write is called by WriteMemory. A stack write is one at SP, as for PUSH,
+// CALL and interrupts; the stack overflows into data when it writes over
+// RAM the program has been reading as data.
+func (s *Sanitizer) Write(cpu *CPU, address uint16, value uint8) {
+	s.access(cpu, address, "write to")
+	setBit(s.written, address, true)
+
+	if address == cpu.SP && getBit(s.liveData, address) {
+		s.warn(cpu, "stack", "stack pushed over data at %04X", address)
+		setBit(s.liveData, address, false)
+	}
+
+	switch {
+	case address < 0x8000 && cpu.ROM[0x0147] == 0x00:
+		s.warn(cpu, "rom-write", "write of %02X to ROM at %04X without a mapper", value, address)
+	case address == 0xFF40 && value&0x80 == 0 && cpu.Memory[0xFF40]&0x80 != 0 && cpu.lcdMode() != 1:
+		s.warn(cpu, "lcd-off", "LCD turned off outside VBlank on line %d", cpu.Memory[0xFF44])
+	}
+}
+
+func (s *Sanitizer) access(cpu *CPU, address uint16, verb string) {
+	switch {
+	case address >= 0x8000 && address < 0xA000:
+		if mode := cpu.lcdMode(); mode == 3 {
+			s.warn(cpu, "vram", "%s VRAM %04X during mode 3", verb, address)
+		}
+	case address >= 0xFE00 && address < 0xFEA0:
+		if mode := cpu.lcdMode(); mode == 2 || mode == 3 {
+			s.warn(cpu, "vram", "%s OAM %04X during mode %d", verb, address, mode)
+		}
+	case address >= 0xFEA0 && address < 0xFF00:
+		s.warn(cpu, "unusable", "%s unusable %04X", verb, address)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// runSanitized runs a program at 0x0000 with every check enabled and
+// returns the warnings.
+func runSanitized(t *testing.T, program []byte, steps int, setup func(cpu *CPU)) string {
+	cpu := InitCPU()
+	copy(cpu.Memory, program)
+	var out strings.Builder
+	cpu.Sanitizer = NewSanitizer(&out)
+	if setup != nil {
+		setup(cpu)
+	}
+	for i := 0; i < steps; i++ {
+		cpu.Step()
+	}
+	return out.String()
+}
+
+func TestSanitizerChecks(t *testing.T) {
+	lcdOn := func(cpu *CPU) {
+		cpu.Memory[0xFF40] = 0x91
+		cpu.FrameStart = cpu.Clock - (10*CyclesPerLine + 100) // line 10, mode 3
+	}
+	tests := []struct {
+		name    string
+		program []byte
+		steps   int
+		setup   func(cpu *CPU)
+		warning string
+	}{
+		{"uninit", []byte{0xFA, 0x00, 0xC0}, 1, nil,
+			"strict [uninit] read from uninitialised C000 at 00:0000"},
+		{"rom-write", []byte{0xEA, 0x00, 0x20}, 1, nil,
+			"strict [rom-write] write of 00 to ROM at 2000 without a mapper"},
+		{"vram", []byte{0xEA, 0x00, 0x80}, 1, lcdOn,
+			"strict [vram] write to VRAM 8000 during mode 3"},
+		{"lcd-off", []byte{0xAF, 0xE0, 0x40}, 2, lcdOn,
+			"strict [lcd-off] LCD turned off outside VBlank on line 10 at 00:0001"},
+		{"unusable", []byte{0xEA, 0xA0, 0xFE}, 1, nil,
+			"strict [unusable] write to unusable FEA0"},
+		{"exec", []byte{0xC3, 0x00, 0xC0}, 2, nil,
+			"strict [exec] executing from RAM at C000 at 00:C000"},
+		// SP=C100: reading C0FF as data and then pushing over it
+		{"stack", []byte{0x31, 0x00, 0xC1, 0xEA, 0xFF, 0xC0, 0xFA, 0xFF, 0xC0, 0xC5}, 4, nil,
+			"strict [stack] stack pushed over data at C0FF at 00:0009"},
+	}
+	for _, test := range tests {
+		out := runSanitized(t, test.program, test.steps, test.setup)
+		if !strings.Contains(out, test.warning) {
+			t.Errorf("%s: expected %q, got:\n%s", test.name, test.warning, out)
+		}
+	}
+}
+
+func TestSanitizerQuietAndDisable(t *testing.T) {
+	// writes before reads, a ROM with a mapper and the LCD off: no warnings
+	program := []byte{0x3E, 0x01, 0xEA, 0x00, 0xC0, 0xFA, 0x00, 0xC0, 0xEA, 0x00, 0x20, 0xEA, 0x00, 0x80}
+	out := runSanitized(t, program, 4, func(cpu *CPU) { cpu.ROM[0x0147] = 0x01 })
+	if out != "" {
+		t.Errorf("expected no warnings, got:\n%s", out)
+	}
+
+	// the same warning is only given once per instruction
+	loop := []byte{0xFA, 0x00, 0xC0, 0x18, 0xFB}
+	out = runSanitized(t, loop, 10, nil)
+	if strings.Count(out, "\n") != 1 {
+		t.Errorf("expected one warning, got:\n%s", out)
+	}
+
+	out = runSanitized(t, loop, 10, func(cpu *CPU) {
+		if err := cpu.Sanitizer.Disable("uninit,exec"); err != nil {
+			t.Fatalf("Disable: %v", err)
+		}
+	})
+	if out != "" {
+		t.Errorf("expected a disabled check to be quiet, got:\n%s", out)
+	}
+	if err := NewSanitizer(nil).Disable("nonsense"); err == nil {
+		t.Errorf("expected an error for an unknown check")
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Save state layout: a fixed header followed by machineState, all written
+// with encoding/binary in little endian. Bump SaveStateVersion whenever
+// machineState changes shape; older states are rejected rather than
+// misread.
+const SaveStateVersion = 8
+
+var saveStateMagic = [4]byte{'G', 'B', 'S', 'S'}
+
+type saveStateHeader struct {
+	Magic   [4]byte
+	Version uint32
+	ROMHash [sha1.Size]byte
+}
+
+// machineState is everything needed to resume emulation exactly where it
+// was. The flat 64KB memory covers the boot ROM overlay, VRAM, WRAM, OAM,
+// HRAM and every I/O register (timer, serial, sound, LCD), since the
+// emulator keeps all of those in cpu.Memory, apart from DIV, which is worked
+// out from DivStart. The CGB's VRAM and WRAM banks that aren't mapped in and
+// its palettes are stored separately, and so is the SGB. Pending events are
+// stored as their absolute times, which stay valid because Clock is. There
+// is no mapper, so the cartridge has no state beyond the ROM, which is
+// identified by hash instead of being stored.
+type machineState struct {
+	Registers [8]uint8
+	PC        uint16
+	SP        uint16
+	IME       uint16
+	Halted    bool
+	Stopped   bool
+	Clock     uint64
+
+	DMAActive     bool
+	DMASourceBase uint16
+
+	Events     [eventKinds]uint64
+	DivStart   uint64
+	SerialBits int32
+
+	FrameStart uint64
+	Frames     uint64
+
+	Joypad uint8
+
+	Model       int32
+	CGBMode     bool
+	DoubleSpeed bool
+	VRAMBank    int32
+	WRAMBank    int32
+	VRAMBanks   [2][0x2000]uint8
+	WRAMBanks   [8][0x1000]uint8
+	BGPalettes  [64]uint8
+	OBJPalettes [64]uint8
+	HDMASource  uint16
+	HDMADest    uint16
+	HDMABlocks  int32
+
+	SGB SGB
+
+	Memory [65536]uint8
+}
+
+func (cpu *CPU) romHash() [sha1.Size]byte {
+	return sha1.Sum(cpu.ROM)
+}
+
+// SaveState writes a snapshot of the complete machine to w.
+func (cpu *CPU) SaveState(w io.Writer) error {
+	header := saveStateHeader{
+		Magic:   saveStateMagic,
+		Version: SaveStateVersion,
+		ROMHash: cpu.romHash(),
+	}
+
+	state := machineState{
+		PC:            cpu.PC,
+		SP:            cpu.SP,
+		IME:           cpu.IME,
+		Halted:        cpu.Halted,
+		Stopped:       cpu.Stopped,
+		Clock:         cpu.Clock,
+		DMAActive:     cpu.DMAActive,
+		DMASourceBase: cpu.DMASourceBase,
+		Events:        cpu.Events.When,
+		DivStart:      cpu.DivStart,
+		SerialBits:    int32(cpu.SerialBits),
+		FrameStart:    cpu.FrameStart,
+		Frames:        cpu.Frames,
+		Joypad:        cpu.Joypad,
+		Model:         int32(cpu.Model),
+		CGBMode:       cpu.CGBMode,
+		DoubleSpeed:   cpu.DoubleSpeed,
+		VRAMBank:      int32(cpu.VRAMBank),
+		WRAMBank:      int32(cpu.WRAMBank),
+		VRAMBanks:     cpu.VRAMBanks,
+		WRAMBanks:     cpu.WRAMBanks,
+		BGPalettes:    cpu.BGPalettes,
+		OBJPalettes:   cpu.OBJPalettes,
+		HDMASource:    cpu.HDMASource,
+		HDMADest:      cpu.HDMADest,
+		HDMABlocks:    int32(cpu.HDMABlocks),
+		SGB:           cpu.SGB,
+	}
+	copy(state.Registers[:], cpu.Registers)
+	copy(state.Memory[:], cpu.Memory)
+
+	if err := binary.Write(w, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("error writing save state header: %v", err)
+	}
+	if err := binary.Write(w, binary.LittleEndian, &state); err != nil {
+		return fmt.Errorf("error writing save state: %v", err)
+	}
+	return nil
+}
+
+// LoadState restores a snapshot written by SaveState. States from another
+// save state version or another ROM are rejected, and the machine is left
+// untouched if anything goes wrong.
+func (cpu *CPU) LoadState(r io.Reader) error {
+	var header saveStateHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return fmt.Errorf("error reading save state header: %v", err)
+	}
+	if header.Magic != saveStateMagic {
+		return fmt.Errorf("not a save state")
+	}
+	if header.Version != SaveStateVersion {
+		return fmt.Errorf("save state version %d is not supported (expected version %d)", header.Version, SaveStateVersion)
+	}
+	if header.ROMHash != cpu.romHash() {
+		return fmt.Errorf("save state was made with a different ROM (sha1 %x, loaded ROM is %x)", header.ROMHash, cpu.romHash())
+	}
+
+	var state machineState
+	if err := binary.Read(r, binary.LittleEndian, &state); err != nil {
+		return fmt.Errorf("error reading save state: %v", err)
+	}
+	if state.CGBMode != cpu.CGBMode {
+		return fmt.Errorf("save state was made in %s mode, not %s mode", modeName(state.CGBMode), modeName(cpu.CGBMode))
+	}
+	if model := Model(state.Model); model != cpu.Model {
+		return fmt.Errorf("save state was made on %s, not %s", model, cpu.Model)
+	}
+
+	copy(cpu.Registers, state.Registers[:])
+	cpu.Flags.SetValue(state.Registers[RegF])
+	cpu.PC = state.PC
+	cpu.SP = state.SP
+	cpu.IME = state.IME
+	cpu.Halted = state.Halted
+	cpu.Stopped = state.Stopped
+	cpu.Lockup = nil
+	cpu.Clock = state.Clock
+	cpu.DMAActive = state.DMAActive
+	cpu.DMASourceBase = state.DMASourceBase
+	cpu.Events.When = state.Events
+	cpu.Events.update()
+	cpu.DivStart = state.DivStart
+	cpu.SerialBits = int(state.SerialBits)
+	cpu.FrameStart = state.FrameStart
+	cpu.Frames = state.Frames
+	cpu.Joypad = state.Joypad
+	cpu.DoubleSpeed = state.DoubleSpeed
+	cpu.VRAMBank = int(state.VRAMBank)
+	cpu.WRAMBank = int(state.WRAMBank)
+	cpu.VRAMBanks = state.VRAMBanks
+	cpu.WRAMBanks = state.WRAMBanks
+	cpu.BGPalettes = state.BGPalettes
+	cpu.OBJPalettes = state.OBJPalettes
+	cpu.HDMASource = state.HDMASource
+	cpu.HDMADest = state.HDMADest
+	cpu.HDMABlocks = int(state.HDMABlocks)
+	cpu.SGB = state.SGB
+	copy(cpu.Memory, state.Memory[:])
+	if cpu.Sanitizer != nil {
+		cpu.Sanitizer.StateLoaded()
+	}
+	return nil
+}
+
+func modeName(cgb bool) string {
+	if cgb {
+		return "CGB"
+	}
+	return "DMG"
+}
+
+// StatePath returns the file used for a numbered save state slot, which sits
+// next to the ROM: game.gb slot 3 is game.ss3.
+func (cpu *CPU) StatePath(slot int) string {
+	base := cpu.ROMPath
+	if base == "" {
+		base = "gopherboy.gb"
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)) + fmt.Sprintf(".ss%d", slot)
+}
+
+// SaveSlot writes a save state to the given slot. It is written to a
+// temporary file next to the slot and renamed over it, so a failed save
+// leaves the slot as it was.
+func (cpu *CPU) SaveSlot(slot int) error {
+	path := cpu.StatePath(slot)
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing save state slot %d: %v", slot, err)
+	}
+	defer os.Remove(file.Name()) // fails harmlessly once renamed
+
+	if err := cpu.SaveState(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Chmod(0644); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing save state slot %d: %v", slot, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error writing save state slot %d: %v", slot, err)
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("error writing save state slot %d: %v", slot, err)
+	}
+	return nil
+}
+
+// LoadSlot restores the save state in the given slot.
+func (cpu *CPU) LoadSlot(slot int) error {
+	file, err := os.Open(cpu.StatePath(slot))
+	if err != nil {
+		return fmt.Errorf("error opening save state slot %d: %v", slot, err)
+	}
+	defer file.Close()
+
+	return cpu.LoadState(file)
+}
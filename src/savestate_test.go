@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newStateTestCPU() *CPU {
+	cpu := InitCPU()
+	cpu.ROM[0x0134] = 'T'
+	cpu.Registers[RegA] = 0x12
+	cpu.Registers[RegH] = 0xC0
+	cpu.Flags.SetValue(0xB0)
+	cpu.PC = 0x0150
+	cpu.SP = 0xDFF0
+	cpu.IME = 1
+	cpu.Halted = true
+	cpu.Stopped = true
+	cpu.DMAActive = true
+	cpu.DMASourceBase = 0xC100
+	cpu.Events.Schedule(EventDMA, 12345+37*4)
+	cpu.DivStart = 0x1234
+	cpu.SerialBits = 3
+	cpu.Clock = 12345 + 67890
+	cpu.FrameStart = 67890
+	cpu.Frames = 99
+	cpu.Memory[0xC000] = 0xAB
+	cpu.Memory[0xFF40] = 0x91
+	return cpu
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	cpu := newStateTestCPU()
+
+	var buf bytes.Buffer
+	if err := cpu.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	restored := InitCPU()
+	copy(restored.ROM, cpu.ROM)
+	if err := restored.LoadState(&buf); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+
+	if !bytes.Equal(restored.Registers, cpu.Registers) {
+		t.Errorf("registers: expected %v, got %v", cpu.Registers, restored.Registers)
+	}
+	if restored.Flags.Value() != 0xB0 {
+		t.Errorf("flags: expected 0xB0, got 0x%02X", restored.Flags.Value())
+	}
+	if restored.PC != cpu.PC || restored.SP != cpu.SP || restored.IME != cpu.IME || restored.Halted != cpu.Halted || restored.Stopped != cpu.Stopped {
+		t.Errorf("cpu state mismatch: PC %04X SP %04X IME %d halted %v stopped %v", restored.PC, restored.SP, restored.IME, restored.Halted, restored.Stopped)
+	}
+	if restored.DMAActive != cpu.DMAActive || restored.DMASourceBase != cpu.DMASourceBase {
+		t.Errorf("DMA state mismatch: %v %04X", restored.DMAActive, restored.DMASourceBase)
+	}
+	if restored.Events != cpu.Events {
+		t.Errorf("events: expected %v, got %v", cpu.Events, restored.Events)
+	}
+	if restored.DivStart != cpu.DivStart || restored.SerialBits != cpu.SerialBits {
+		t.Errorf("timer and serial mismatch: DIV start %d, %d serial bits", restored.DivStart, restored.SerialBits)
+	}
+	if restored.Clock != cpu.Clock || restored.FrameCycles() != cpu.FrameCycles() || restored.Frames != cpu.Frames {
+		t.Errorf("frame position mismatch: %d cycles, frame %d", restored.FrameCycles(), restored.Frames)
+	}
+	if !bytes.Equal(restored.Memory, cpu.Memory) {
+		t.Errorf("memory mismatch")
+	}
+}
+
+func TestLoadStateRejectsDifferentROM(t *testing.T) {
+	cpu := newStateTestCPU()
+
+	var buf bytes.Buffer
+	if err := cpu.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	other := InitCPU()
+	other.PC = 0x1234
+	err := other.LoadState(&buf)
+	if err == nil || !strings.Contains(err.Error(), "different ROM") {
+		t.Fatalf("expected a different ROM error, got %v", err)
+	}
+	if other.PC != 0x1234 {
+		t.Errorf("rejected state still modified the CPU")
+	}
+}
+
+func TestLoadStateRejectsOtherVersion(t *testing.T) {
+	cpu := newStateTestCPU()
+
+	var buf bytes.Buffer
+	if err := cpu.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[4:8], SaveStateVersion+1)
+
+	err := cpu.LoadState(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Fatalf("expected a version error, got %v", err)
+	}
+}
+
+func TestSaveStateCGB(t *testing.T) {
+	cpu := newCGBTestCPU()
+	cpu.WriteMemory(0xFF4F, 0x01)
+	cpu.WriteMemory(0x8000, 0x11)
+	cpu.WriteMemory(0xFF4F, 0x00)
+	cpu.WriteMemory(0xFF70, 0x03)
+	cpu.BGPalettes[5] = 0x55
+	cpu.DoubleSpeed = true
+
+	var buf bytes.Buffer
+	if err := cpu.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	data := buf.Bytes()
+
+	restored := newCGBTestCPU()
+	if err := restored.LoadState(bytes.NewReader(data)); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if restored.vram(1)[0] != 0x11 || restored.WRAMBank != 3 || restored.BGPalettes[5] != 0x55 || !restored.DoubleSpeed {
+		t.Errorf("CGB state not restored: VRAM bank 1 %02X, WRAM bank %d, palette %02X, double speed %t",
+			restored.vram(1)[0], restored.WRAMBank, restored.BGPalettes[5], restored.DoubleSpeed)
+	}
+
+	dmg := InitCPU()
+	copy(dmg.ROM, cpu.ROM)
+	err := dmg.LoadState(bytes.NewReader(data))
+	if err == nil || !strings.Contains(err.Error(), "CGB mode") {
+		t.Errorf("expected a CGB mode error, got %v", err)
+	}
+}
+
+func TestSaveStateModel(t *testing.T) {
+	// all of these run a DMG game in DMG mode, so only the model tells them
+	// apart
+	for _, test := range []struct{ saved, loaded Model }{
+		{ModelSGB, ModelDMG},
+		{ModelMGB, ModelDMG},
+		{ModelCGB, ModelDMG},
+		{ModelAGB, ModelCGB},
+	} {
+		cpu := InitCPU()
+		cpu.SetModel(test.saved)
+		var buf bytes.Buffer
+		if err := cpu.SaveState(&buf); err != nil {
+			t.Fatalf("SaveState: %v", err)
+		}
+		other := InitCPU()
+		other.SetModel(test.loaded)
+		expected := fmt.Sprintf("made on %s, not %s", test.saved, test.loaded)
+		if err := other.LoadState(&buf); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s state on %s: expected a model error, got %v", test.saved, test.loaded, err)
+		}
+	}
+}
+
+func TestSaveStateSGB(t *testing.T) {
+	cpu := newSGBTestCPU()
+	sendPackets(cpu, sgbAttrDiv<<3|1, 0x02, 0)
+	cpu.WriteMemory(0xFF00, 0x00) // halfway through the next packet
+	cpu.WriteMemory(0xFF00, 0x30)
+	cpu.WriteMemory(0xFF00, 0x10)
+
+	var buf bytes.Buffer
+	if err := cpu.SaveState(&buf); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+	restored := newSGBTestCPU()
+	if err := restored.LoadState(&buf); err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if restored.SGB != cpu.SGB {
+		t.Errorf("SGB state not restored")
+	}
+}
+
+func TestSaveSlot(t *testing.T) {
+	cpu := newStateTestCPU()
+	cpu.ROMPath = filepath.Join(t.TempDir(), "game.gb")
+	cpu.PC = 0x1234
+	if err := cpu.SaveSlot(1); err != nil {
+		t.Fatal(err)
+	}
+	cpu.PC = 0x5678
+	if err := cpu.SaveSlot(1); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(filepath.Dir(cpu.ROMPath))
+	if len(files) != 1 || files[0].Name() != "game.ss1" {
+		t.Errorf("expected just game.ss1 next to the ROM, found %v", files)
+	}
+
+	cpu.PC = 0
+	if err := cpu.LoadSlot(1); err != nil {
+		t.Fatal(err)
+	}
+	if cpu.PC != 0x5678 {
+		t.Errorf("slot holds PC %04X, expected the second save's 5678", cpu.PC)
+	}
+}
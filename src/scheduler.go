@@ -0,0 +1,101 @@
+package main
+
+// EventKind identifies something a component has scheduled to happen. A
+// component has at most one event of each kind pending, so the scheduler
+// is a slot per kind rather than a queue.
+type EventKind int
+
+const (
+	EventLCD         EventKind = iota // the PPU changes mode or line
+	EventTimer                        // the timer's input falls and TIMA increments
+	EventTimerReload                  // TIMA is loaded from TMA after overflowing
+	EventSerial                       // a bit is shifted through SB
+	EventDMA                          // an OAM DMA transfer completes
+	eventKinds
+)
+
+// NoEvent is the time of an event that isn't scheduled.
+const NoEvent = ^uint64(0)
+
+// Scheduler keeps the time, in cpu.Clock T-cycles, at which each kind of
+// event is next due. The CPU checks it on every M-cycle, which costs a
+// comparison instead of polling every component, and a halted CPU skips
+// straight to the next event.
+type Scheduler struct {
+	When [eventKinds]uint64
+	next uint64 // the earliest of When
+}
+
+func NewScheduler() Scheduler {
+	var s Scheduler
+	for kind := range s.When {
+		s.When[kind] = NoEvent
+	}
+	s.next = NoEvent
+	return s
+}
+
+// Schedule sets the time of an event, replacing any pending one of the
+// same kind.
+func (s *Scheduler) Schedule(kind EventKind, at uint64) {
+	s.When[kind] = at
+	if at < s.next {
+		s.next = at
+	} else {
+		s.update()
+	}
+}
+
+// Cancel removes a pending event.
+func (s *Scheduler) Cancel(kind EventKind) {
+	s.When[kind] = NoEvent
+	s.update()
+}
+
+// Pending reports whether an event of a kind is scheduled.
+func (s *Scheduler) Pending(kind EventKind) bool {
+	return s.When[kind] != NoEvent
+}
+
+// Next returns the time of the earliest event, or NoEvent.
+func (s *Scheduler) Next() uint64 {
+	return s.next
+}
+
+func (s *Scheduler) update() {
+	s.next = NoEvent
+	for _, at := range s.When {
+		if at < s.next {
+			s.next = at
+		}
+	}
+}
+
+// runEvents handles every event due by cpu.Clock, earliest first. Each
+// handler is given the time the event was due, which is what it schedules
+// the next one from.
+func (cpu *CPU) runEvents() {
+	for cpu.Events.next <= cpu.Clock {
+		kind := EventKind(0)
+		for k, at := range cpu.Events.When {
+			if at < cpu.Events.When[kind] {
+				kind = EventKind(k)
+			}
+		}
+		at := cpu.Events.When[kind]
+		cpu.Events.Cancel(kind)
+
+		switch kind {
+		case EventLCD:
+			cpu.lcdEvent(at)
+		case EventTimer:
+			cpu.timerEvent(at)
+		case EventTimerReload:
+			cpu.timerReloadEvent()
+		case EventSerial:
+			cpu.serialEvent(at)
+		case EventDMA:
+			cpu.dmaEvent()
+		}
+	}
+}
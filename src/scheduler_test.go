@@ -0,0 +1,100 @@
+package main
+
+import "testing"
+
+func TestScheduler(t *testing.T) {
+	s := NewScheduler()
+	if s.Next() != NoEvent {
+		t.Fatalf("new scheduler has an event at %d", s.Next())
+	}
+	s.Schedule(EventTimer, 100)
+	s.Schedule(EventSerial, 50)
+	s.Schedule(EventDMA, 200)
+	if s.Next() != 50 {
+		t.Errorf("next event at %d, expected 50", s.Next())
+	}
+	// rescheduling replaces the pending event
+	s.Schedule(EventSerial, 300)
+	if s.Next() != 100 {
+		t.Errorf("next event at %d after moving the first, expected 100", s.Next())
+	}
+	s.Cancel(EventTimer)
+	if s.Pending(EventTimer) || s.Next() != 200 {
+		t.Errorf("next event at %d after cancelling, expected 200", s.Next())
+	}
+}
+
+// Events are handled in the order they are due, each at its own time, even
+// when the clock has passed several of them.
+func TestRunEventsInOrder(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Events.Cancel(EventLCD)
+	cpu.Memory[0xFF01] = 0x00
+	cpu.SerialBits = 2
+	cpu.Events.Schedule(EventSerial, 8)
+	cpu.Events.Schedule(EventDMA, 4)
+	cpu.DMAActive = true
+	cpu.Clock = 8 + SerialBitCycles
+
+	cpu.runEvents()
+	if cpu.DMAActive {
+		t.Errorf("DMA still active")
+	}
+	if cpu.SerialBits != 0 || cpu.Memory[0xFF01] != 0x03 {
+		t.Errorf("%d serial bits left, SB %02X, expected both bits shifted", cpu.SerialBits, cpu.Memory[0xFF01])
+	}
+	if cpu.Events.Next() != NoEvent {
+		t.Errorf("event left at %d", cpu.Events.Next())
+	}
+}
+
+func TestLCDEvents(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF45] = 2
+	for _, test := range []struct {
+		position int
+		ly       uint8
+		mode     uint8
+	}{
+		{4, 0, 2},
+		{OAMScanCycles, 0, 3},
+		{OAMScanCycles + TransferCycles, 0, 0},
+		{2*CyclesPerLine + 4, 2, 2},
+		{VBlankLine*CyclesPerLine + 200, VBlankLine, 1},
+		{CyclesPerFrame - 4, LinesPerFrame - 1, 1},
+	} {
+		for cpu.FrameCycles() < test.position {
+			cpu.tick()
+		}
+		stat := cpu.Memory[0xFF41]
+		if ly := cpu.Memory[0xFF44]; ly != test.ly || stat&0x03 != test.mode {
+			t.Errorf("at %d: LY %d mode %d, expected LY %d mode %d", test.position, ly, stat&0x03, test.ly, test.mode)
+		}
+		if coincidence := stat&0x04 != 0; coincidence != (test.ly == 2) {
+			t.Errorf("at %d: LY=LYC flag %t", test.position, coincidence)
+		}
+	}
+	if cpu.Memory[0xFF0F]&0x01 == 0 {
+		t.Errorf("VBlank not requested")
+	}
+}
+
+// A halted CPU skips to the next event instead of ticking through every
+// M-cycle, and wakes up when an enabled interrupt is requested.
+func TestHaltSkipsToEvents(t *testing.T) {
+	cpu := runCode(t, func(cpu *CPU) {
+		cpu.Memory[0xFFFF] = 0x01 // VBlank
+	}, 0x76) // HALT
+	steps := 0
+	for cpu.Halted {
+		cpu.Step()
+		steps++
+	}
+	if line := cpu.FrameCycles() / CyclesPerLine; line != VBlankLine {
+		t.Errorf("woke up on line %d, expected %d", line, VBlankLine)
+	}
+	// a mode change per visible line
+	if steps > 3*VBlankLine+1 {
+		t.Errorf("took %d steps to reach VBlank", steps)
+	}
+}
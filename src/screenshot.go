@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ScreenshotOptions is how screenshots are saved.
+type ScreenshotOptions struct {
+	Scale int // each pixel becomes Scale x Scale pixels; 0 or 1 is native size
+
+	// Indexed saves the shades 0-3 the Game Boy put out as a paletted PNG,
+	// instead of the colours shown, so that screenshots can be compared
+	// whatever palette they were taken in. The PNG's palette is always the
+	// grey preset. On the SGB it leaves the border out, and it isn't
+	// possible in CGB mode, which has no shades.
+	Indexed bool
+
+	Path string // the file to write; empty for the next free ScreenshotPath
+}
+
+// ScreenshotImage renders the screen and returns it as ScreenshotOptions
+// says: as shown, SGB border and all, or as shades.
+func (cpu *CPU) ScreenshotImage(opts ScreenshotOptions) (image.Image, error) {
+	scale := max(opts.Scale, 1)
+	if !opts.Indexed {
+		cpu.RenderGameBoyFrame()
+		width, height := cpu.ScreenSize()
+		pixels := cpu.Pixels
+		if cpu.Model.IsSGB() {
+			pixels = cpu.SGBPixels
+		}
+		img := image.NewRGBA(image.Rect(0, 0, width*scale, height*scale))
+		for y := 0; y < height*scale; y++ {
+			for x := 0; x < width*scale; x++ {
+				copy(img.Pix[img.PixOffset(x, y):], pixels[((y/scale)*width+x/scale)*4:][:4])
+			}
+		}
+		return img, nil
+	}
+
+	if cpu.CGBMode {
+		return nil, fmt.Errorf("indexed screenshots aren't possible in CGB mode")
+	}
+	cpu.RenderFrame()
+	grey := PalettePresets[0].Colours[PaletteBG]
+	palette := make(color.Palette, len(grey))
+	for i, argb := range grey {
+		palette[i] = color.RGBA{uint8(argb >> 16), uint8(argb >> 8), uint8(argb), uint8(argb >> 24)}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 160*scale, 144*scale), palette)
+	for y := 0; y < 144*scale; y++ {
+		for x := 0; x < 160*scale; x++ {
+			img.Pix[img.PixOffset(x, y)] = cpu.Shades[(y/scale)*160+x/scale]
+		}
+	}
+	return img, nil
+}
+
+// RenderGameBoyFrame renders what RenderGameBoy shows, into cpu.Pixels, or
+// on the SGB into cpu.SGBPixels, without touching SDL.
+func (cpu *CPU) RenderGameBoyFrame() {
+	if cpu.Model.IsSGB() {
+		cpu.RenderSGB()
+	} else {
+		cpu.RenderFrame()
+	}
+}
+
+// WriteScreenshot writes a screenshot to w as a PNG.
+func (cpu *CPU) WriteScreenshot(w io.Writer, opts ScreenshotOptions) error {
+	img, err := cpu.ScreenshotImage(opts)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(w, img); err != nil {
+		return fmt.Errorf("error writing screenshot: %v", err)
+	}
+	return nil
+}
+
+// SaveScreenshot writes a screenshot to opts.Path, or the next free
+// ScreenshotPath, and returns where it went.
+func (cpu *CPU) SaveScreenshot(opts ScreenshotOptions) (string, error) {
+	path := opts.Path
+	if path == "" {
+		for n := 1; ; n++ {
+			path = cpu.ScreenshotPath(n)
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				break
+			}
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("error creating screenshot: %v", err)
+	}
+	if err := cpu.WriteScreenshot(file, opts); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("error writing screenshot: %v", err)
+	}
+	return path, nil
+}
+
+// ScreenshotPath returns the file for the nth screenshot, which sits next
+// to the ROM like save states do: game.gb's third is game-003.png.
+func (cpu *CPU) ScreenshotPath(n int) string {
+	base := cpu.ROMPath
+	if base == "" {
+		base = "gopherboy.gb"
+	}
+	return strings.TrimSuffix(base, filepath.Ext(base)) + fmt.Sprintf("-%03d.png", n)
+}
+
+// saveScreenshot saves a screenshot the way cpu.Screenshot says, for the
+// hotkey and -screenshot-after.
+func (cpu *CPU) saveScreenshot() {
+	path, err := cpu.SaveScreenshot(cpu.Screenshot)
+	if err != nil {
+		log.Printf("Failed to save screenshot: %v", err)
+		return
+	}
+	log.Printf("Saved screenshot to %s", path)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScreenshotScale(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	img, err := cpu.ScreenshotImage(ScreenshotOptions{Scale: 3})
+	if err != nil {
+		t.Fatalf("ScreenshotImage: %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(480, 432) {
+		t.Fatalf("screenshot is %v", size)
+	}
+	native := cpu.FrameImage()
+	for _, p := range []image.Point{{15, 0}, {13, 0}, {100, 100}} {
+		for _, corner := range []image.Point{{0, 0}, {2, 2}} {
+			scaled := img.At(p.X*3+corner.X, p.Y*3+corner.Y)
+			if scaled != native.At(p.X, p.Y) {
+				t.Errorf("%v scaled is %v, native %v", p, scaled, native.At(p.X, p.Y))
+			}
+		}
+	}
+}
+
+func TestIndexedScreenshot(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	var shades [2][]uint8
+	for i, palette := range []Palette{PalettePresets[0], PalettePresets[1]} {
+		cpu.Palette = palette
+		img, err := cpu.ScreenshotImage(ScreenshotOptions{Indexed: true})
+		if err != nil {
+			t.Fatalf("ScreenshotImage: %v", err)
+		}
+		shades[i] = img.(*image.Paletted).Pix
+	}
+	if !bytes.Equal(shades[0], shades[1]) {
+		t.Errorf("indexed screenshot changed with the palette")
+	}
+	for _, test := range []struct{ x, shade int }{{13, 2}, {19, 1}, {15, 2}, {30, 0}} {
+		if shade := shades[0][test.x]; int(shade) != test.shade {
+			t.Errorf("pixel %d is shade %d, expected %d", test.x, shade, test.shade)
+		}
+	}
+
+	if _, err := newCGBTestCPU().ScreenshotImage(ScreenshotOptions{Indexed: true}); err == nil {
+		t.Errorf("indexed screenshot taken in CGB mode")
+	}
+}
+
+func TestSGBScreenshot(t *testing.T) {
+	cpu := newSGBTestCPU()
+	img, err := cpu.ScreenshotImage(ScreenshotOptions{})
+	if err != nil {
+		t.Fatalf("ScreenshotImage: %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(SGBWidth, SGBHeight) {
+		t.Errorf("SGB screenshot is %v, expected the border too", size)
+	}
+	img, _ = cpu.ScreenshotImage(ScreenshotOptions{Indexed: true})
+	if size := img.Bounds().Size(); size != image.Pt(160, 144) {
+		t.Errorf("indexed SGB screenshot is %v", size)
+	}
+}
+
+func TestSaveScreenshot(t *testing.T) {
+	cpu := newObjectTestCPU(false)
+	cpu.ROMPath = filepath.Join(t.TempDir(), "game.gb")
+	for _, expected := range []string{"game-001.png", "game-002.png"} {
+		path, err := cpu.SaveScreenshot(ScreenshotOptions{})
+		if err != nil {
+			t.Fatalf("SaveScreenshot: %v", err)
+		}
+		if filepath.Base(path) != expected {
+			t.Errorf("saved to %s, expected %s", path, expected)
+		}
+	}
+
+	f, err := os.Open(cpu.ScreenshotPath(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatalf("screenshot isn't a PNG: %v", err)
+	}
+	rgba, ok := img.(*image.RGBA)
+	if !ok {
+		t.Fatalf("screenshot decoded as %T", img)
+	}
+	if pixel := pixelAt(rgba, 13, 0); pixel != grey[2] {
+		t.Errorf("pixel is %08X", pixel)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+// SerialBitCycles is how long each bit of a transfer clocked by the Game Boy
+// takes, at 8192 Hz.
+const SerialBitCycles = CPUFrequency / 8192
+
+// SerialTransferCycles is how long a whole byte takes.
+const SerialTransferCycles = 8 * SerialBitCycles
+
+// serialControl is called after a write to SC (0xFF02). Nothing is plugged
+// into the link port, so a transfer started with the internal clock sends
+// its byte to cpu.Serial as it starts and receives 0xFF, one bit per
+// EventSerial. Test ROMs print their results this way.
+func (cpu *CPU) serialControl(value uint8) {
+	if value&0x81 != 0x81 {
+		// no transfer, or waiting for the other end to clock it
+		return
+	}
+	if cpu.Serial != nil {
+		cpu.Serial.Write([]byte{cpu.Memory[0xFF01]})
+	}
+	cpu.SerialBits = 8
+	cpu.Events.Schedule(EventSerial, cpu.Clock+cpu.cpuCycles(SerialBitCycles))
+}
+
+// serialEvent shifts a bit out of SB and a 1 in. After the eighth, SC bit 7
+// is cleared and the serial interrupt is requested.
+func (cpu *CPU) serialEvent(at uint64) {
+	cpu.Memory[0xFF01] = cpu.Memory[0xFF01]<<1 | 1
+	cpu.SerialBits--
+	if cpu.SerialBits > 0 {
+		cpu.Events.Schedule(EventSerial, at+cpu.cpuCycles(SerialBitCycles))
+		return
+	}
+	cpu.Memory[0xFF02] &^= 0x80
+	cpu.RequestSerialInterrupt()
+}
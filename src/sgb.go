@@ -0,0 +1,430 @@
+package main
+
+import "encoding/binary"
+
+// The Super Game Boy is a DMG inside a SNES cartridge. The SNES shows the
+// Game Boy's screen in the middle of a 256x224 border and colours it, and
+// SGB games control both by sending it commands.
+//
+// A command is sent as 1 to 7 packets of 16 bytes over the two select lines
+// of P1. Each packet starts with a reset pulse, both lines low, followed by
+// 128 bits, least significant first, and a 0 stop bit. A 0 is sent by
+// pulling P14 low and a 1 by pulling P15 low, and both lines go back high
+// between pulses. The first byte of the first packet holds the command in
+// bits 3-7 and the number of packets in bits 0-2.
+//
+// The SNES can't read VRAM, so larger data such as the border (CHR_TRN and
+// PCT_TRN) is sent through the screen: the game shows it as background
+// tiles, and the SNES reads the picture back, see sgbTransfer. Here the
+// transfer happens as soon as the command arrives, rather than over the
+// next frame, so the data has to be on the screen by the time the game sends
+// it. Games already do, as the SNES doesn't wait for it either.
+//
+// Commands for sound, SNES code and the SGB's own menus are ignored.
+
+// Commands, with the names Nintendo gave them.
+const (
+	sgbPal01    = 0x00 // PAL01
+	sgbPal23    = 0x01 // PAL23
+	sgbPal03    = 0x02 // PAL03
+	sgbPal12    = 0x03 // PAL12
+	sgbAttrBlk  = 0x04 // ATTR_BLK
+	sgbAttrLin  = 0x05 // ATTR_LIN
+	sgbAttrDiv  = 0x06 // ATTR_DIV
+	sgbAttrChr  = 0x07 // ATTR_CHR
+	sgbPalSet   = 0x0A // PAL_SET
+	sgbPalTrn   = 0x0B // PAL_TRN
+	sgbMltReq   = 0x11 // MLT_REQ
+	sgbChrTrn   = 0x13 // CHR_TRN
+	sgbPctTrn   = 0x14 // PCT_TRN
+	sgbAttrTrn  = 0x15 // ATTR_TRN
+	sgbAttrSet  = 0x16 // ATTR_SET
+	sgbMaskEn   = 0x17 // MASK_EN
+	sgbPackets  = 7    // the most a command can have
+	sgbCellsX   = 20   // attributes are per 8x8 cell of the screen
+	sgbCellsY   = 18
+	sgbAttrFile = sgbCellsX * sgbCellsY / 4 // an attribute file has 2 bits per cell
+)
+
+// What MASK_EN shows instead of the game's screen.
+const (
+	sgbMaskOff    = 0
+	sgbMaskFreeze = 1 // the last frame shown
+	sgbMaskBlack  = 2
+	sgbMaskColour = 3 // colour 0
+)
+
+// The border is drawn at SGBWidth x SGBHeight with the Game Boy's screen at
+// (sgbScreenX, sgbScreenY).
+const (
+	SGBWidth   = 256
+	SGBHeight  = 224
+	sgbScreenX = 48
+	sgbScreenY = 40
+)
+
+// SGB is what the Super Game Boy keeps on the SNES side. It is all fixed
+// size so that save states can store it as it is. Colours are little endian
+// with 5 bits each of red, green and blue, the same as the CGB's.
+type SGB struct {
+	// the command being received, see writeP1
+	Receiving bool  // between a reset pulse and a stop bit
+	Released  bool  // both select lines went back high after the last pulse
+	Bits      uint8 // of the packet being received
+	Packet    uint8 // being received
+	Command   [sgbPackets * 16]uint8
+
+	// MLT_REQ: P1 reads the joypad of Player, one of Players
+	Players     uint8
+	Player      uint8
+	ButtonsRead bool // P15 went low since Player last changed
+
+	Palettes       [4 * 8]uint8                 // palettes 0-3, which colour the screen
+	Attributes     [sgbCellsX * sgbCellsY]uint8 // the palette of each cell
+	SystemPalettes [512 * 8]uint8               // sent by PAL_TRN, picked by PAL_SET
+	AttributeFiles [45 * sgbAttrFile]uint8      // sent by ATTR_TRN, picked by ATTR_SET
+	Mask           uint8
+
+	BorderTiles    [256 * 32]uint8 // SNES 4 bit tiles, sent by CHR_TRN
+	BorderMap      [32 * 32 * 2]uint8
+	BorderPalettes [4 * 32]uint8 // SNES palettes 4-7, 16 colours each
+}
+
+// resetSGB sets the SGB up as its boot ROM leaves it, with every palette
+// the SGB's default of cream, orange, red and dark blue.
+func (cpu *CPU) resetSGB() {
+	cpu.SGB = SGB{Released: true, Players: 1}
+	for palette := 0; palette < 4; palette++ {
+		for colour, rgb := range []uint16{0x67BF, 0x265B, 0x10B5, 0x2866} {
+			binary.LittleEndian.PutUint16(cpu.SGB.Palettes[palette*8+colour*2:], rgb)
+		}
+	}
+}
+
+// writeP1 watches the select lines for command packets and for the end of a
+// joypad read, which moves MLT_REQ on to the next player.
+func (cpu *CPU) writeP1(value uint8) {
+	sgb := &cpu.SGB
+	lines := value & 0x30
+	if lines&0x20 == 0 {
+		sgb.ButtonsRead = true
+	}
+	if lines == 0x30 {
+		if sgb.ButtonsRead && sgb.Players > 1 {
+			sgb.Player = (sgb.Player + 1) % sgb.Players
+		}
+		sgb.ButtonsRead = false
+		sgb.Released = true
+		return
+	}
+	if !sgb.Released {
+		return
+	}
+	sgb.Released = false
+
+	if lines == 0x00 {
+		// a reset pulse starts a packet, and a command if it isn't in the
+		// middle of one
+		sgb.Receiving = true
+		sgb.Bits = 0
+		if sgb.Packet == 0 {
+			sgb.Command = [sgbPackets * 16]uint8{}
+		} else {
+			clear(sgb.Command[int(sgb.Packet)*16 : int(sgb.Packet+1)*16])
+		}
+		return
+	}
+	if !sgb.Receiving {
+		// an ordinary joypad read
+		return
+	}
+	one := lines == 0x10
+	if sgb.Bits == 128 {
+		sgb.Receiving = false
+		if one {
+			// no stop bit, so the packet is thrown away
+			return
+		}
+		sgb.Packet++
+		if length := sgb.Command[0] & 0x07; sgb.Packet >= max(length, 1) {
+			sgb.Packet = 0
+			cpu.sgbCommand(sgb.Command[:])
+		}
+		return
+	}
+	if one {
+		sgb.Command[int(sgb.Packet)*16+int(sgb.Bits/8)] |= 1 << (sgb.Bits % 8)
+	}
+	sgb.Bits++
+}
+
+// sgbCommand carries out a command. data has room for the longest command,
+// so the commands can index it without checking the length they were sent
+// with.
+func (cpu *CPU) sgbCommand(data []uint8) {
+	sgb := &cpu.SGB
+	switch data[0] >> 3 {
+	case sgbPal01:
+		sgb.setPalettes(0, 1, data)
+	case sgbPal23:
+		sgb.setPalettes(2, 3, data)
+	case sgbPal03:
+		sgb.setPalettes(0, 3, data)
+	case sgbPal12:
+		sgb.setPalettes(1, 2, data)
+	case sgbAttrBlk:
+		sgb.attrBlock(data)
+	case sgbAttrLin:
+		sgb.attrLine(data)
+	case sgbAttrDiv:
+		sgb.attrDivide(data)
+	case sgbAttrChr:
+		sgb.attrCells(data)
+	case sgbPalSet:
+		for palette := 0; palette < 4; palette++ {
+			n := int(binary.LittleEndian.Uint16(data[1+palette*2:]) & 0x1FF)
+			copy(sgb.Palettes[palette*8:palette*8+8], sgb.SystemPalettes[n*8:])
+		}
+		sgb.shareColour0()
+		if data[9]&0x80 != 0 {
+			sgb.setAttributeFile(data[9] & 0x3F)
+		}
+		if data[9]&0x40 != 0 {
+			sgb.Mask = sgbMaskOff
+		}
+	case sgbPalTrn:
+		copy(sgb.SystemPalettes[:], cpu.sgbTransfer())
+	case sgbMltReq:
+		sgb.Players = [4]uint8{1, 2, 1, 4}[data[1]&0x03]
+		sgb.Player = 0
+	case sgbChrTrn:
+		copy(sgb.BorderTiles[int(data[1]&0x01)*0x1000:], cpu.sgbTransfer())
+	case sgbPctTrn:
+		transfer := cpu.sgbTransfer()
+		copy(sgb.BorderMap[:], transfer)
+		copy(sgb.BorderPalettes[:], transfer[len(sgb.BorderMap):])
+	case sgbAttrTrn:
+		copy(sgb.AttributeFiles[:], cpu.sgbTransfer())
+	case sgbAttrSet:
+		sgb.setAttributeFile(data[1] & 0x3F)
+		if data[1]&0x40 != 0 {
+			sgb.Mask = sgbMaskOff
+		}
+	case sgbMaskEn:
+		sgb.Mask = data[1] & 0x03
+	}
+}
+
+// setPalettes sets colours 1-3 of palettes a and b, and colour 0, which all
+// four palettes share.
+func (sgb *SGB) setPalettes(a, b int, data []uint8) {
+	copy(sgb.Palettes[:2], data[1:3])
+	copy(sgb.Palettes[a*8+2:a*8+8], data[3:9])
+	copy(sgb.Palettes[b*8+2:b*8+8], data[9:15])
+	sgb.shareColour0()
+}
+
+func (sgb *SGB) shareColour0() {
+	for palette := 1; palette < 4; palette++ {
+		copy(sgb.Palettes[palette*8:palette*8+2], sgb.Palettes[:2])
+	}
+}
+
+// attrBlock is ATTR_BLK: up to 18 rectangles, each giving palettes to the
+// cells inside it, on its edge and outside it. Setting only the inside or
+// only the outside sets the edge too.
+func (sgb *SGB) attrBlock(data []uint8) {
+	for i := 0; i < min(int(data[1]), 18); i++ {
+		set := data[2+i*6:]
+		control, palettes := set[0]&0x07, set[1]
+		inside, edge, outside := palettes&0x03, palettes>>2&0x03, palettes>>4&0x03
+		switch control {
+		case 0x01:
+			control, edge = 0x03, inside
+		case 0x04:
+			control, edge = 0x06, outside
+		}
+		x1, y1, x2, y2 := int(set[2]&0x1F), int(set[3]&0x1F), int(set[4]&0x1F), int(set[5]&0x1F)
+		for y := 0; y < sgbCellsY; y++ {
+			for x := 0; x < sgbCellsX; x++ {
+				cell := &sgb.Attributes[y*sgbCellsX+x]
+				switch {
+				case x > x1 && x < x2 && y > y1 && y < y2:
+					if control&0x01 != 0 {
+						*cell = inside
+					}
+				case x >= x1 && x <= x2 && y >= y1 && y <= y2:
+					if control&0x02 != 0 {
+						*cell = edge
+					}
+				default:
+					if control&0x04 != 0 {
+						*cell = outside
+					}
+				}
+			}
+		}
+	}
+}
+
+// attrLine is ATTR_LIN: a byte per line of cells, with the line number in
+// bits 0-4, the palette in bits 5-6 and in bit 7 whether it is a row
+// rather than a column.
+func (sgb *SGB) attrLine(data []uint8) {
+	for _, set := range data[2 : 2+min(int(data[1]), len(data)-2)] {
+		line, palette := int(set&0x1F), set>>5&0x03
+		for i := 0; i < max(sgbCellsX, sgbCellsY); i++ {
+			x, y := line, i
+			if set&0x80 != 0 {
+				x, y = i, line
+			}
+			if x < sgbCellsX && y < sgbCellsY {
+				sgb.Attributes[y*sgbCellsX+x] = palette
+			}
+		}
+	}
+}
+
+// attrDivide is ATTR_DIV: a column (or with bit 6 set, a row) of cells
+// dividing the screen, with a palette for each side and for the line.
+func (sgb *SGB) attrDivide(data []uint8) {
+	after, before, on := data[1]&0x03, data[1]>>2&0x03, data[1]>>4&0x03
+	at := int(data[2] & 0x1F)
+	for y := 0; y < sgbCellsY; y++ {
+		for x := 0; x < sgbCellsX; x++ {
+			position := x
+			if data[1]&0x40 != 0 {
+				position = y
+			}
+			palette := on
+			if position < at {
+				palette = before
+			} else if position > at {
+				palette = after
+			}
+			sgb.Attributes[y*sgbCellsX+x] = palette
+		}
+	}
+}
+
+// attrCells is ATTR_CHR: palettes for a run of cells from (X, Y), 2 bits a
+// cell, left to right or with bit 0 of byte 5 set top to bottom.
+func (sgb *SGB) attrCells(data []uint8) {
+	x, y := int(data[1]&0x1F), int(data[2]&0x1F)
+	count := int(binary.LittleEndian.Uint16(data[3:]))
+	vertical := data[5]&0x01 != 0
+	for i := 0; i < count && 6+i/4 < len(data) && x < sgbCellsX && y < sgbCellsY; i++ {
+		sgb.Attributes[y*sgbCellsX+x] = data[6+i/4] >> (6 - i%4*2) & 0x03
+		if vertical {
+			if y++; y == sgbCellsY {
+				x, y = x+1, 0
+			}
+		} else {
+			if x++; x == sgbCellsX {
+				x, y = 0, y+1
+			}
+		}
+	}
+}
+
+// setAttributeFile sets every cell's palette from one of the attribute
+// files ATTR_TRN sent.
+func (sgb *SGB) setAttributeFile(n uint8) {
+	if int(n) >= len(sgb.AttributeFiles)/sgbAttrFile {
+		return
+	}
+	file := sgb.AttributeFiles[int(n)*sgbAttrFile:]
+	for i := range sgb.Attributes {
+		sgb.Attributes[i] = file[i/4] >> (6 - i%4*2) & 0x03
+	}
+}
+
+// sgbTransfer returns the 4KB a VRAM transfer sends. The SNES reads it off
+// the screen, where the game shows it as background tiles 0-255 from the top
+// left, 20 to a row. Each tile comes back with the shades BGP gave it.
+func (cpu *CPU) sgbTransfer() []uint8 {
+	tileMap := 0x1800
+	if bgTileMapMode(cpu) == 1 {
+		tileMap = 0x1C00
+	}
+	vram := cpu.vram(0)
+	bgp := cpu.Memory[0xFF47]
+
+	data := make([]uint8, 0x1000)
+	for tile := 0; tile < 256; tile++ {
+		addr := bgTileAddress(cpu, vram[tileMap+tile/20*32+tile%20])
+		for row := uint16(0); row < 8; row++ {
+			low, high := vram[addr+row*2], vram[addr+row*2+1]
+			var shadeLow, shadeHigh uint8
+			for bit := uint8(0); bit < 8; bit++ {
+				shade := uint8(dmgShade(bgp, interleaveTilePixel(low, high, bit)))
+				shadeLow |= shade & 0x01 << bit
+				shadeHigh |= shade >> 1 << bit
+			}
+			data[tile*16+int(row)*2] = shadeLow
+			data[tile*16+int(row)*2+1] = shadeHigh
+		}
+	}
+	return data
+}
+
+// colourLine colours a line of DMG shades with the palette of the cell each
+// pixel is in.
+func (sgb *SGB) colourLine(ly uint8, line *scanline) {
+	for x, shade := range line.shades {
+		palette := sgb.Attributes[int(ly)/8*sgbCellsX+x/8]
+		line.colours[x] = cgbColour(sgb.Palettes[:], palette, shade)
+	}
+}
+
+// maskScreen draws what MASK_EN shows instead of the game.
+func (sgb *SGB) maskScreen(pixels []byte) {
+	switch sgb.Mask {
+	case sgbMaskBlack:
+		fillPixels(pixels, 0xFF000000)
+	case sgbMaskColour:
+		fillPixels(pixels, cgbColour(sgb.Palettes[:], 0, 0))
+	}
+}
+
+// borderPixel returns the colour of the border at (x, y). Each entry in the
+// 32x32 map is the tile in bits 0-7, the palette (4-7) in bits 10-12 and
+// horizontal and vertical flips in bits 14 and 15. Tiles have 4 bit colours
+// split into 4 bit planes: planes 0 and 1 interleaved in the first 16 bytes,
+// planes 2 and 3 in the last. Colour 0 is transparent, showing SGB colour 0
+// behind.
+func (sgb *SGB) borderPixel(x, y int) uint32 {
+	entry := binary.LittleEndian.Uint16(sgb.BorderMap[(y/8*32+x/8)*2:])
+	row, bit := y%8, uint8(7-x%8)
+	if entry&0x4000 != 0 {
+		bit = uint8(x % 8)
+	}
+	if entry&0x8000 != 0 {
+		row = 7 - row
+	}
+	tile := sgb.BorderTiles[int(entry&0xFF)*32:]
+	colour := uint8(interleaveTilePixel(tile[row*2], tile[row*2+1], bit))
+	colour |= uint8(interleaveTilePixel(tile[16+row*2], tile[16+row*2+1], bit)) << 2
+	if colour == 0 {
+		return cgbColour(sgb.Palettes[:], 0, 0)
+	}
+	palette := int(entry>>10) & 0x03
+	return cgbColour(sgb.BorderPalettes[palette*32:], 0, colour)
+}
+
+// RenderSGB renders the frame and draws it in the middle of the border, in
+// cpu.SGBPixels.
+func (cpu *CPU) RenderSGB() {
+	cpu.RenderFrame()
+	if cpu.SGBPixels == nil {
+		cpu.SGBPixels = make([]byte, SGBWidth*SGBHeight*4)
+	}
+	for y := 0; y < SGBHeight; y++ {
+		for x := 0; x < SGBWidth; x++ {
+			setRGBA(cpu.SGBPixels, (y*SGBWidth+x)*4, cpu.SGB.borderPixel(x, y))
+		}
+	}
+	for y := 0; y < 144; y++ {
+		copy(cpu.SGBPixels[((sgbScreenY+y)*SGBWidth+sgbScreenX)*4:], cpu.Pixels[y*160*4:(y+1)*160*4])
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func newSGBTestCPU() *CPU {
+	cpu := InitCPU()
+	cpu.SetModel(ModelSGB)
+	cpu.Memory[0xFF40] = 0x91 // LCD and BG on, tiles at 0x8000
+	cpu.Memory[0xFF47] = 0xE4
+	return cpu
+}
+
+// sendPackets pulses a command out over P1 the way games do.
+func sendPackets(cpu *CPU, data ...uint8) {
+	for packet := 0; packet < len(data); packet += 16 {
+		cpu.WriteMemory(0xFF00, 0x00)
+		cpu.WriteMemory(0xFF00, 0x30)
+		for i := 0; i < 128; i++ {
+			var b uint8
+			if packet+i/8 < len(data) {
+				b = data[packet+i/8]
+			}
+			if b>>(i%8)&0x01 != 0 {
+				cpu.WriteMemory(0xFF00, 0x10)
+			} else {
+				cpu.WriteMemory(0xFF00, 0x20)
+			}
+			cpu.WriteMemory(0xFF00, 0x30)
+		}
+		cpu.WriteMemory(0xFF00, 0x20)
+		cpu.WriteMemory(0xFF00, 0x30)
+	}
+}
+
+func TestSGBPalettes(t *testing.T) {
+	cpu := newSGBTestCPU()
+	cpu.WriteMemory(0xFF00, 0x20) // reading the joypad doesn't send anything
+	cpu.WriteMemory(0xFF00, 0x10)
+	cpu.WriteMemory(0xFF00, 0x30)
+	sendPackets(cpu, sgbPal12<<3|1,
+		0x00, 0x00, // black
+		0x1F, 0x00, 0xE0, 0x03, 0x00, 0x7C, // palette 1: red, green, blue
+		0xFF, 0x7F, 0xFF, 0x7F, 0xFF, 0x7F, // palette 2: white
+	)
+	for _, test := range []struct {
+		palette, colour uint8
+		expected        uint32
+	}{
+		{0, 0, 0xFF000000},
+		{3, 0, 0xFF000000},
+		{1, 1, 0xFFFF0000},
+		{1, 3, 0xFF0000FF},
+		{2, 2, 0xFFFFFFFF},
+		{3, 1, 0xFFDE944A}, // untouched, the SGB's default
+	} {
+		if colour := cgbColour(cpu.SGB.Palettes[:], test.palette, test.colour); colour != test.expected {
+			t.Errorf("palette %d colour %d is %08X, expected %08X", test.palette, test.colour, colour, test.expected)
+		}
+	}
+}
+
+func TestSGBAttributes(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		data     []uint8
+		expected map[[2]int]uint8 // cell -> palette
+	}{
+		{
+			"ATTR_BLK inside only", []uint8{sgbAttrBlk<<3 | 1, 1, 0x01, 0x01, 2, 2, 5, 5},
+			map[[2]int]uint8{{3, 3}: 1, {2, 4}: 1, {5, 5}: 1, {1, 1}: 0, {6, 3}: 0},
+		},
+		{
+			"ATTR_BLK all three", []uint8{sgbAttrBlk<<3 | 1, 1, 0x07, 0x39, 2, 2, 5, 5},
+			map[[2]int]uint8{{3, 3}: 1, {2, 4}: 2, {0, 0}: 3, {19, 17}: 3},
+		},
+		{
+			"ATTR_LIN", []uint8{sgbAttrLin<<3 | 1, 2, 0x80 | 0x40 | 3, 0x20 | 7},
+			map[[2]int]uint8{{0, 3}: 2, {19, 3}: 2, {7, 0}: 1, {7, 17}: 1, {7, 3}: 1, {0, 0}: 0},
+		},
+		{
+			"ATTR_DIV", []uint8{sgbAttrDiv<<3 | 1, 0x40 | 0x30 | 0x04 | 0x02, 9},
+			map[[2]int]uint8{{0, 8}: 1, {19, 9}: 3, {5, 10}: 2, {5, 17}: 2},
+		},
+		{
+			"ATTR_CHR", []uint8{sgbAttrChr<<3 | 1, 19, 0, 4, 0, 0, 0b11_10_01_00},
+			map[[2]int]uint8{{19, 0}: 3, {0, 1}: 2, {1, 1}: 1, {2, 1}: 0},
+		},
+	} {
+		cpu := newSGBTestCPU()
+		cpu.SGB.Attributes[1*sgbCellsX+2] = 3 // for ATTR_CHR to overwrite
+		sendPackets(cpu, test.data...)
+		for cell, palette := range test.expected {
+			if got := cpu.SGB.Attributes[cell[1]*sgbCellsX+cell[0]]; got != palette {
+				t.Errorf("%s: cell %v has palette %d, expected %d", test.name, cell, got, palette)
+			}
+		}
+	}
+}
+
+func TestSGBMultiplePackets(t *testing.T) {
+	cpu := newSGBTestCPU()
+	// ATTR_LIN setting 16 columns, which takes two packets
+	data := []uint8{sgbAttrLin<<3 | 2, 16}
+	for column := uint8(0); column < 16; column++ {
+		data = append(data, 0x20|column)
+	}
+	sendPackets(cpu, data[:16]...)
+	if cpu.SGB.Attributes[0] != 0 {
+		t.Fatalf("command ran after the first packet")
+	}
+	sendPackets(cpu, data[16:]...)
+	for column := 0; column < 16; column++ {
+		if palette := cpu.SGB.Attributes[17*sgbCellsX+column]; palette != 1 {
+			t.Errorf("column %d has palette %d", column, palette)
+		}
+	}
+}
+
+func TestSGBScreenColours(t *testing.T) {
+	cpu := newSGBTestCPU()
+	cpu.Memory[0x8000] = 0xFF // tile 0, colour 1 on the top row
+	sendPackets(cpu, sgbPal01<<3|1, 0x00, 0x00, 0x1F, 0x00, 0, 0, 0, 0, 0xE0, 0x03)
+	sendPackets(cpu, sgbAttrDiv<<3|1, 0x01, 1) // palette 1 right of column 1
+	img := cpu.FrameImage()
+	if pixel := pixelAt(img, 0, 0); pixel != 0xFFFF0000 {
+		t.Errorf("cell 0 is %08X, expected palette 0 red", pixel)
+	}
+	if pixel := pixelAt(img, 16, 0); pixel != 0xFF00FF00 {
+		t.Errorf("cell 2 is %08X, expected palette 1 green", pixel)
+	}
+
+	sendPackets(cpu, sgbMaskEn<<3|1, sgbMaskColour)
+	if pixel := pixelAt(cpu.FrameImage(), 0, 0); pixel != 0xFF000000 {
+		t.Errorf("masked screen is %08X, expected colour 0", pixel)
+	}
+}
+
+func TestMultiplayer(t *testing.T) {
+	cpu := newSGBTestCPU()
+	cpu.SetJoypad(ButtonA)
+	sendPackets(cpu, sgbMltReq<<3|1, 0x01)
+
+	for _, player := range []uint8{0, 1, 0} {
+		cpu.WriteMemory(0xFF00, 0x30)
+		if id := cpu.ReadMemory(0xFF00) & 0x0F; id != 0x0F-player {
+			t.Errorf("P1 reads ID %X, expected %X", id, 0x0F-player)
+		}
+		cpu.WriteMemory(0xFF00, 0x10)
+		pressed := cpu.ReadMemory(0xFF00)&0x01 == 0
+		if pressed != (player == 0) {
+			t.Errorf("player %d pressing A is %t", player+1, pressed)
+		}
+	}
+}
+
+func TestSGBBorder(t *testing.T) {
+	cpu := newSGBTestCPU()
+	// show tiles 0-255 as the transfer wants them
+	for tile := 0; tile < 256; tile++ {
+		cpu.Memory[0x9800+tile/20*32+tile%20] = uint8(tile)
+	}
+
+	// the data comes back with the shades BGP gives it
+	cpu.Memory[0xFF47] = 0x1B
+	sendPackets(cpu, sgbChrTrn<<3|1, 0x01)
+	if cpu.SGB.BorderTiles[0x1000] != 0xFF || cpu.SGB.BorderTiles[0x1FFF] != 0xFF {
+		t.Errorf("tiles 80-FF weren't sent through BGP")
+	}
+
+	// CHR_TRN: SNES tile 1 has colour 15 along its top row
+	cpu.Memory[0xFF47] = 0xE4
+	copy(cpu.Memory[0x8020:], []uint8{0xFF, 0xFF})
+	copy(cpu.Memory[0x8030:], []uint8{0xFF, 0xFF})
+	sendPackets(cpu, sgbChrTrn<<3|1, 0x00)
+
+	// PCT_TRN: tile 1 at the top left in palette 5, flipped vertically,
+	// whose colour 15 is red
+	clear(cpu.Memory[0x8000:0x9000])
+	cpu.Memory[0x8000] = 0x01
+	cpu.Memory[0x8001] = 0x94
+	cpu.Memory[0x8000+0x800+32+30] = 0x1F
+	sendPackets(cpu, sgbPctTrn<<3|1)
+
+	cpu.RenderSGB()
+	img := &image.RGBA{Pix: cpu.SGBPixels, Stride: SGBWidth * 4, Rect: image.Rect(0, 0, SGBWidth, SGBHeight)}
+	if pixel := pixelAt(img, 3, 7); pixel != 0xFFFF0000 {
+		t.Errorf("border is %08X at (3, 7), expected red", pixel)
+	}
+	if pixel := pixelAt(img, 3, 0); pixel != cgbColour(cpu.SGB.Palettes[:], 0, 0) {
+		t.Errorf("transparent border is %08X, expected colour 0", pixel)
+	}
+	if pixel, screen := pixelAt(img, sgbScreenX, sgbScreenY+8), pixelAt(cpu.FrameImage(), 0, 8); pixel != screen {
+		t.Errorf("screen is %08X in the border, %08X on its own", pixel, screen)
+	}
+}
@@ -0,0 +1,141 @@
+package sm83
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Instruction is one decoded instruction.
+type Instruction struct {
+	Address  uint16
+	Bytes    []uint8
+	Mnemonic string // the table template, e.g. "JR NZ, r8"
+	Text     string // with operands filled in, e.g. "JR NZ, $0150"
+
+	// Target is the address a JP, JR, CALL or RST goes to, when it is known
+	// from the instruction alone.
+	Target    uint16
+	HasTarget bool
+}
+
+// Length returns the number of bytes the instruction occupies.
+func (inst Instruction) Length() int {
+	return len(inst.Bytes)
+}
+
+// Valid reports whether the opcode exists. Invalid opcodes disassemble as a
+// single data byte.
+func (inst Instruction) Valid() bool {
+	return inst.Mnemonic != ""
+}
+
+// IsCall reports whether the instruction pushes a return address, i.e. CALL
+// or RST.
+func (inst Instruction) IsCall() bool {
+	return strings.HasPrefix(inst.Mnemonic, "CALL") || strings.HasPrefix(inst.Mnemonic, "RST")
+}
+
+// IsReturn reports whether the instruction is RET, RETI or a conditional RET.
+func (inst Instruction) IsReturn() bool {
+	return strings.HasPrefix(inst.Mnemonic, "RET")
+}
+
+func (inst Instruction) String() string {
+	return inst.Text
+}
+
+// Disassemble decodes the instruction at addr, reading memory through read.
+func Disassemble(read func(uint16) uint8, addr uint16) Instruction {
+	opcode := read(addr)
+	op := Opcodes[opcode]
+	if opcode == 0xCB {
+		op = CBOpcodes[read(addr+1)]
+	}
+
+	inst := Instruction{Address: addr, Mnemonic: op.Mnemonic}
+	if !op.Valid() {
+		inst.Bytes = []uint8{opcode}
+		inst.Text = fmt.Sprintf("DB $%02X", opcode)
+		return inst
+	}
+	for i := 0; i < op.Length; i++ {
+		inst.Bytes = append(inst.Bytes, read(addr+uint16(i)))
+	}
+	switch {
+	case strings.HasPrefix(op.Mnemonic, "RST"):
+		inst.Target = uint16(opcode & 0x38)
+		inst.HasTarget = true
+	case strings.Contains(op.Mnemonic, "r8"):
+		inst.Target = inst.relativeTarget()
+		inst.HasTarget = true
+	case strings.HasPrefix(op.Mnemonic, "JP") || strings.HasPrefix(op.Mnemonic, "CALL"):
+		if strings.Contains(op.Mnemonic, "u16") {
+			inst.Target = inst.word()
+			inst.HasTarget = true
+		}
+	}
+	inst.Text = inst.Symbolize(nil)
+	return inst
+}
+
+// DisassembleBytes decodes the instruction at the start of code as if it was
+// at addr. Bytes past the end of code read as zero.
+func DisassembleBytes(code []uint8, addr uint16) Instruction {
+	return Disassemble(func(a uint16) uint8 {
+		offset := int(a - addr)
+		if offset < len(code) {
+			return code[offset]
+		}
+		return 0
+	}, addr)
+}
+
+func (inst Instruction) word() uint16 {
+	return uint16(inst.Bytes[2])<<8 | uint16(inst.Bytes[1])
+}
+
+func (inst Instruction) relativeTarget() uint16 {
+	return inst.Address + 2 + uint16(int8(inst.Bytes[1]))
+}
+
+// Symbolize formats the instruction with addresses replaced by labels where
+// label knows one, e.g. "CALL PlayerUpdate" or "LD A, (wScore)". Immediate
+// bytes and words that are loaded into registers are data, not addresses,
+// so only jump targets and memory operands are looked up. label may be nil.
+func (inst Instruction) Symbolize(label func(address uint16) (string, bool)) string {
+	if !inst.Valid() {
+		return inst.Text
+	}
+	text := inst.Mnemonic
+	address := func(a uint16, format string) string {
+		if label != nil {
+			if name, ok := label(a); ok {
+				return name
+			}
+		}
+		return fmt.Sprintf(format, a)
+	}
+	switch {
+	case strings.Contains(text, "(u16)"):
+		return strings.Replace(text, "u16", address(inst.word(), "$%04X"), 1)
+	case strings.Contains(text, "u16") && inst.HasTarget:
+		return strings.Replace(text, "u16", address(inst.word(), "$%04X"), 1)
+	case strings.Contains(text, "u16"):
+		return strings.Replace(text, "u16", fmt.Sprintf("$%04X", inst.word()), 1)
+	case strings.Contains(text, "u8"):
+		return strings.Replace(text, "u8", fmt.Sprintf("$%02X", inst.Bytes[1]), 1)
+	case strings.Contains(text, "a8"):
+		return strings.Replace(text, "a8", address(0xFF00|uint16(inst.Bytes[1]), "$%04X"), 1)
+	case strings.Contains(text, "r8"):
+		return strings.Replace(text, "r8", address(inst.relativeTarget(), "$%04X"), 1)
+	case strings.Contains(text, "SP+s8"):
+		offset := int8(inst.Bytes[1])
+		if offset < 0 {
+			return strings.Replace(text, "+s8", fmt.Sprintf("-%d", -int(offset)), 1)
+		}
+		return strings.Replace(text, "s8", fmt.Sprintf("%d", offset), 1)
+	case strings.Contains(text, "s8"):
+		return strings.Replace(text, "s8", fmt.Sprintf("%d", int8(inst.Bytes[1])), 1)
+	}
+	return text
+}
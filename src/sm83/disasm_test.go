@@ -0,0 +1,121 @@
+package sm83
+
+import "testing"
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		code     []uint8
+		text     string
+		length   int
+		target   uint16
+		isTarget bool
+	}{
+		{[]uint8{0x00}, "NOP", 1, 0, false},
+		{[]uint8{0x01, 0x00, 0xC0}, "LD BC, $C000", 3, 0, false},
+		{[]uint8{0x08, 0x34, 0x12}, "LD ($1234), SP", 3, 0, false},
+		{[]uint8{0x20, 0xFE}, "JR NZ, $0200", 2, 0x0200, true},
+		{[]uint8{0x18, 0x10}, "JR $0212", 2, 0x0212, true},
+		{[]uint8{0x3E, 0x42}, "LD A, $42", 2, 0, false},
+		{[]uint8{0x46}, "LD B, (HL)", 1, 0, false},
+		{[]uint8{0x76}, "HALT", 1, 0, false},
+		{[]uint8{0x9E}, "SBC A, (HL)", 1, 0, false},
+		{[]uint8{0xC3, 0x50, 0x01}, "JP $0150", 3, 0x0150, true},
+		{[]uint8{0xCD, 0x00, 0x40}, "CALL $4000", 3, 0x4000, true},
+		{[]uint8{0xE9}, "JP HL", 1, 0, false},
+		{[]uint8{0xEF}, "RST $28", 1, 0x0028, true},
+		{[]uint8{0xE0, 0x44}, "LD ($FF44), A", 2, 0, false},
+		{[]uint8{0xF0, 0x00}, "LD A, ($FF00)", 2, 0, false},
+		{[]uint8{0xE2}, "LD (C), A", 1, 0, false},
+		{[]uint8{0xE8, 0xFE}, "ADD SP, -2", 2, 0, false},
+		{[]uint8{0xF8, 0x05}, "LD HL, SP+5", 2, 0, false},
+		{[]uint8{0xF8, 0xFD}, "LD HL, SP-3", 2, 0, false},
+		{[]uint8{0x10, 0x00}, "STOP", 2, 0, false},
+		{[]uint8{0xCB, 0x11}, "RL C", 2, 0, false},
+		{[]uint8{0xCB, 0x7E}, "BIT 7, (HL)", 2, 0, false},
+		{[]uint8{0xCB, 0xFF}, "SET 7, A", 2, 0, false},
+		{[]uint8{0xD3}, "DB $D3", 1, 0, false},
+	}
+
+	for _, test := range tests {
+		inst := DisassembleBytes(test.code, 0x0200)
+		if inst.Text != test.text {
+			t.Errorf("% X: expected %q, got %q", test.code, test.text, inst.Text)
+		}
+		if inst.Length() != test.length {
+			t.Errorf("% X: expected length %d, got %d", test.code, test.length, inst.Length())
+		}
+		if inst.HasTarget != test.isTarget || inst.Target != test.target {
+			t.Errorf("% X: expected target %04X (%t), got %04X (%t)", test.code, test.target, test.isTarget, inst.Target, inst.HasTarget)
+		}
+	}
+}
+
+func TestOpcodeTables(t *testing.T) {
+	invalid := 0
+	for opcode, op := range Opcodes {
+		if !op.Valid() {
+			invalid++
+			continue
+		}
+		if op.Length < 1 || op.Length > 3 {
+			t.Errorf("%02X %s: length %d", opcode, op.Mnemonic, op.Length)
+		}
+		if op.Cycles == 0 || op.Cycles%4 != 0 || (op.BranchCycles != 0 && op.BranchCycles <= op.Cycles) {
+			t.Errorf("%02X %s: cycles %d/%d", opcode, op.Mnemonic, op.Cycles, op.BranchCycles)
+		}
+	}
+	// 0xD3, 0xDB, 0xDD, 0xE3, 0xE4, 0xEB, 0xEC, 0xED, 0xF4, 0xFC, 0xFD
+	if invalid != 11 {
+		t.Errorf("expected 11 invalid opcodes, got %d", invalid)
+	}
+	for opcode, op := range CBOpcodes {
+		if !op.Valid() || op.Length != 2 {
+			t.Errorf("CB %02X: %+v", opcode, op)
+		}
+	}
+
+	for _, test := range []struct {
+		op     Opcode
+		cycles int
+		branch int
+	}{
+		{Opcodes[0x08], 20, 0},   // LD (u16), SP
+		{Opcodes[0x46], 8, 0},    // LD B, (HL)
+		{Opcodes[0x36], 12, 0},   // LD (HL), u8
+		{Opcodes[0xC4], 12, 24},  // CALL NZ, u16
+		{Opcodes[0xC0], 8, 20},   // RET NZ
+		{CBOpcodes[0x46], 12, 0}, // BIT 0, (HL)
+		{CBOpcodes[0x86], 16, 0}, // RES 0, (HL)
+		{CBOpcodes[0x11], 8, 0},  // RL C
+	} {
+		if test.op.Cycles != test.cycles || test.op.BranchCycles != test.branch {
+			t.Errorf("%s: expected %d/%d cycles, got %d/%d", test.op.Mnemonic, test.cycles, test.branch, test.op.Cycles, test.op.BranchCycles)
+		}
+	}
+}
+
+func TestSymbolize(t *testing.T) {
+	labels := map[uint16]string{0x4000: "PlayerUpdate", 0xC000: "wScore", 0xFF40: "rLCDC", 0x0210: "Loop"}
+	label := func(address uint16) (string, bool) {
+		name, ok := labels[address]
+		return name, ok
+	}
+	tests := []struct {
+		code []uint8
+		text string
+	}{
+		{[]uint8{0xCD, 0x00, 0x40}, "CALL PlayerUpdate"},
+		{[]uint8{0xFA, 0x00, 0xC0}, "LD A, (wScore)"},
+		{[]uint8{0xE0, 0x40}, "LD (rLCDC), A"},
+		{[]uint8{0x18, 0x0E}, "JR Loop"},
+		// a word loaded into a register is data, not an address
+		{[]uint8{0x21, 0x00, 0xC0}, "LD HL, $C000"},
+		{[]uint8{0xC3, 0x00, 0x50}, "JP $5000"},
+		{[]uint8{0xD3}, "DB $D3"},
+	}
+	for _, test := range tests {
+		if text := DisassembleBytes(test.code, 0x0200).Symbolize(label); text != test.text {
+			t.Errorf("% X: expected %q, got %q", test.code, test.text, text)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+// Package sm83 describes the instruction set of the Game Boy's SM83 CPU and
+// disassembles it.
+package sm83
+
+import (
+	"strings"
+)
+
+// Opcode describes one instruction encoding. Mnemonic is a template in which
+// operands are written as placeholders:
+//
+//	u8   immediate byte                 LD B, u8
+//	u16  immediate word                 JP u16
+//	a8   high page address, 0xFF00+u8   LD (a8), A
+//	r8   relative jump target           JR NZ, r8
+//	s8   signed immediate byte          ADD SP, s8
+//
+// An empty Mnemonic marks an opcode that doesn't exist on the SM83.
+//
+// Cycles are T-cycles. A conditional instruction takes Cycles when the
+// condition fails and BranchCycles when it holds; BranchCycles is 0 for
+// everything else.
+type Opcode struct {
+	Mnemonic     string
+	Length       int
+	Cycles       int
+	BranchCycles int
+}
+
+// Opcodes is the unprefixed instruction table. The CB entry describes the
+// prefix itself; the instruction it introduces is in CBOpcodes.
+var Opcodes [256]Opcode
+
+// CBOpcodes is the table of CB-prefixed instructions. Lengths and cycles
+// include the prefix byte.
+var CBOpcodes [256]Opcode
+
+var mnemonics = [256]string{
+	// 0x00
+	"NOP", "LD BC, u16", "LD (BC), A", "INC BC", "INC B", "DEC B", "LD B, u8", "RLCA",
+	"LD (u16), SP", "ADD HL, BC", "LD A, (BC)", "DEC BC", "INC C", "DEC C", "LD C, u8", "RRCA",
+	// 0x10
+	"STOP", "LD DE, u16", "LD (DE), A", "INC DE", "INC D", "DEC D", "LD D, u8", "RLA",
+	"JR r8", "ADD HL, DE", "LD A, (DE)", "DEC DE", "INC E", "DEC E", "LD E, u8", "RRA",
+	// 0x20
+	"JR NZ, r8", "LD HL, u16", "LD (HL+), A", "INC HL", "INC H", "DEC H", "LD H, u8", "DAA",
+	"JR Z, r8", "ADD HL, HL", "LD A, (HL+)", "DEC HL", "INC L", "DEC L", "LD L, u8", "CPL",
+	// 0x30
+	"JR NC, r8", "LD SP, u16", "LD (HL-), A", "INC SP", "INC (HL)", "DEC (HL)", "LD (HL), u8", "SCF",
+	"JR C, r8", "ADD HL, SP", "LD A, (HL-)", "DEC SP", "INC A", "DEC A", "LD A, u8", "CCF",
+	// 0x40-0xBF are generated, see init
+	// 0xC0
+	0xC0: "RET NZ", "POP BC", "JP NZ, u16", "JP u16", "CALL NZ, u16", "PUSH BC", "ADD A, u8", "RST $00",
+	"RET Z", "RET", "JP Z, u16", "PREFIX CB", "CALL Z, u16", "CALL u16", "ADC A, u8", "RST $08",
+	// 0xD0
+	"RET NC", "POP DE", "JP NC, u16", "", "CALL NC, u16", "PUSH DE", "SUB A, u8", "RST $10",
+	"RET C", "RETI", "JP C, u16", "", "CALL C, u16", "", "SBC A, u8", "RST $18",
+	// 0xE0
+	"LD (a8), A", "POP HL", "LD (C), A", "", "", "PUSH HL", "AND A, u8", "RST $20",
+	"ADD SP, s8", "JP HL", "LD (u16), A", "", "", "", "XOR A, u8", "RST $28",
+	// 0xF0
+	"LD A, (a8)", "POP AF", "LD A, (C)", "DI", "", "PUSH AF", "OR A, u8", "RST $30",
+	"LD HL, SP+s8", "LD SP, HL", "LD A, (u16)", "EI", "", "", "CP A, u8", "RST $38",
+}
+
+// M-cycles of each opcode, not taken for conditional ones. 0x40-0xBF are
+// generated, see init.
+var mcycles = [256]int{
+	1, 3, 2, 2, 1, 1, 2, 1, 5, 2, 2, 2, 1, 1, 2, 1, // 0x00
+	1, 3, 2, 2, 1, 1, 2, 1, 3, 2, 2, 2, 1, 1, 2, 1, // 0x10
+	2, 3, 2, 2, 1, 1, 2, 1, 2, 2, 2, 2, 1, 1, 2, 1, // 0x20
+	2, 3, 2, 2, 3, 3, 3, 1, 2, 2, 2, 2, 1, 1, 2, 1, // 0x30
+	0xC0: 2, 3, 3, 4, 3, 4, 2, 4, 2, 4, 3, 1, 3, 6, 2, 4, // 0xC0
+	2, 3, 3, 0, 3, 4, 2, 4, 2, 4, 3, 0, 3, 0, 2, 4, // 0xD0
+	3, 3, 2, 0, 0, 4, 2, 4, 4, 1, 4, 0, 0, 0, 2, 4, // 0xE0
+	3, 3, 2, 1, 0, 4, 2, 4, 3, 2, 4, 1, 0, 0, 2, 4, // 0xF0
+}
+
+// M-cycles of the conditional opcodes when the condition holds.
+var branchMcycles = map[int]int{
+	0x20: 3, 0x28: 3, 0x30: 3, 0x38: 3, // JR cc
+	0xC0: 5, 0xC8: 5, 0xD0: 5, 0xD8: 5, // RET cc
+	0xC2: 4, 0xCA: 4, 0xD2: 4, 0xDA: 4, // JP cc
+	0xC4: 6, 0xCC: 6, 0xD4: 6, 0xDC: 6, // CALL cc
+}
+
+// Operand order of the register field in the 0x40-0xBF block and CB opcodes.
+var registerNames = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+var aluNames = [8]string{"ADD A,", "ADC A,", "SUB A,", "SBC A,", "AND A,", "XOR A,", "OR A,", "CP A,"}
+
+var shiftNames = [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+
+func init() {
+	for op := 0x40; op < 0xC0; op++ {
+		if op < 0x80 {
+			mnemonics[op] = "LD " + registerNames[op>>3&7] + ", " + registerNames[op&7]
+		} else {
+			mnemonics[op] = aluNames[op>>3&7] + " " + registerNames[op&7]
+		}
+		// an (HL) operand costs a memory access
+		mcycles[op] = 1
+		if op&7 == 6 || (op < 0x80 && op>>3&7 == 6) {
+			mcycles[op] = 2
+		}
+	}
+	mnemonics[0x76] = "HALT"
+	mcycles[0x76] = 1
+
+	for op, mnemonic := range mnemonics {
+		Opcodes[op] = Opcode{
+			Mnemonic:     mnemonic,
+			Length:       operandLength(mnemonic) + 1,
+			Cycles:       mcycles[op] * 4,
+			BranchCycles: branchMcycles[op] * 4,
+		}
+	}
+	// STOP is followed by a byte that is skipped, and the CB prefix is
+	// always followed by the rest of the instruction
+	Opcodes[0x10].Length = 2
+	Opcodes[0xCB].Length = 2
+
+	for op := 0; op < 256; op++ {
+		reg := registerNames[op&7]
+		bit := string(rune('0' + op>>3&7))
+		var mnemonic string
+		switch op >> 6 {
+		case 0:
+			mnemonic = shiftNames[op>>3&7] + " " + reg
+		case 1:
+			mnemonic = "BIT " + bit + ", " + reg
+		case 2:
+			mnemonic = "RES " + bit + ", " + reg
+		case 3:
+			mnemonic = "SET " + bit + ", " + reg
+		}
+		// the prefix and opcode fetches, plus a read and a write for (HL),
+		// which BIT only reads
+		cycles := 8
+		if op&7 == 6 {
+			cycles = 16
+			if op>>6 == 1 {
+				cycles = 12
+			}
+		}
+		CBOpcodes[op] = Opcode{Mnemonic: mnemonic, Length: 2, Cycles: cycles}
+	}
+}
+
+func operandLength(mnemonic string) int {
+	switch {
+	case mnemonic == "":
+		return 0
+	case strings.Contains(mnemonic, "u16"):
+		return 2
+	case strings.Contains(mnemonic, "u8"), strings.Contains(mnemonic, "a8"),
+		strings.Contains(mnemonic, "r8"), strings.Contains(mnemonic, "s8"):
+		return 1
+	}
+	return 0
+}
+
+// Valid reports whether the opcode exists.
+func (op Opcode) Valid() bool {
+	return op.Mnemonic != ""
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	MinSpeed = 0.25
+	MaxSpeed = 8.0
+)
+
+// SpeedControl holds the user-facing run state of the emulator: the speed
+// multiplier, fast-forward and pause/frame-advance. The main loop consults it
+// once per frame.
+type SpeedControl struct {
+	Multiplier float64
+
+	fastForward     bool // toggled on
+	holdFastForward bool // held down
+	paused          bool
+	pendingFrames   int // frames to advance while paused
+}
+
+func NewSpeedControl() SpeedControl {
+	return SpeedControl{Multiplier: 1}
+}
+
+// SetMultiplier sets the throttled emulation speed relative to real hardware.
+func (s *SpeedControl) SetMultiplier(multiplier float64) error {
+	if multiplier < MinSpeed || multiplier > MaxSpeed {
+		return fmt.Errorf("speed %.2fx out of range (%.2fx to %.0fx)", multiplier, MinSpeed, MaxSpeed)
+	}
+	s.Multiplier = multiplier
+	return nil
+}
+
+// Faster doubles the speed multiplier, up to MaxSpeed.
+func (s *SpeedControl) Faster() {
+	s.Multiplier = min(s.Multiplier*2, MaxSpeed)
+}
+
+// Slower halves the speed multiplier, down to MinSpeed.
+func (s *SpeedControl) Slower() {
+	s.Multiplier = max(s.Multiplier/2, MinSpeed)
+}
+
+func (s *SpeedControl) SetFastForward(on bool) {
+	s.fastForward = on
+}
+
+func (s *SpeedControl) ToggleFastForward() {
+	s.fastForward = !s.fastForward
+}
+
+// HoldFastForward is driven by the fast-forward key: unthrottled while held.
+func (s *SpeedControl) HoldFastForward(held bool) {
+	s.holdFastForward = held
+}
+
+// FastForwarding reports whether frames should run without throttling.
+func (s *SpeedControl) FastForwarding() bool {
+	return s.fastForward || s.holdFastForward
+}
+
+func (s *SpeedControl) Pause() {
+	s.paused = true
+}
+
+func (s *SpeedControl) Resume() {
+	s.paused = false
+	s.pendingFrames = 0
+}
+
+func (s *SpeedControl) TogglePause() {
+	if s.paused {
+		s.Resume()
+	} else {
+		s.Pause()
+	}
+}
+
+func (s *SpeedControl) Paused() bool {
+	return s.paused
+}
+
+// AdvanceFrame runs exactly one more frame while paused. It does nothing when
+// the emulator is running.
+func (s *SpeedControl) AdvanceFrame() {
+	if s.paused {
+		s.pendingFrames++
+	}
+}
+
+// NextFrame reports whether the main loop should emulate a frame now,
+// consuming a pending frame advance if paused.
+func (s *SpeedControl) NextFrame() bool {
+	if !s.paused {
+		return true
+	}
+	if s.pendingFrames > 0 {
+		s.pendingFrames--
+		return true
+	}
+	return false
+}
+
+// String describes the run state for the window title.
+func (s *SpeedControl) String() string {
+	switch {
+	case s.paused:
+		return "paused"
+	case s.FastForwarding():
+		return "fast-forward"
+	case s.Multiplier != 1:
+		return fmt.Sprintf("%gx", s.Multiplier)
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import "testing"
+
+func TestSpeedMultiplier(t *testing.T) {
+	s := NewSpeedControl()
+	for _, test := range []struct {
+		multiplier float64
+		ok         bool
+	}{
+		{MinSpeed, true},
+		{MaxSpeed, true},
+		{1.5, true},
+		{MinSpeed / 2, false},
+		{MaxSpeed + 1, false},
+	} {
+		s.Multiplier = 1
+		err := s.SetMultiplier(test.multiplier)
+		if (err == nil) != test.ok {
+			t.Errorf("SetMultiplier(%g): error %v", test.multiplier, err)
+		}
+		expected := 1.0 // left alone if out of range
+		if test.ok {
+			expected = test.multiplier
+		}
+		if s.Multiplier != expected {
+			t.Errorf("SetMultiplier(%g): multiplier is %g, expected %g", test.multiplier, s.Multiplier, expected)
+		}
+	}
+
+	s.Multiplier = 1
+	for i := 0; i < 5; i++ {
+		s.Faster()
+	}
+	if s.Multiplier != MaxSpeed {
+		t.Errorf("Faster went to %g, expected it to stop at %g", s.Multiplier, MaxSpeed)
+	}
+	for i := 0; i < 10; i++ {
+		s.Slower()
+	}
+	if s.Multiplier != MinSpeed {
+		t.Errorf("Slower went to %g, expected it to stop at %g", s.Multiplier, MinSpeed)
+	}
+}
+
+func TestFastForward(t *testing.T) {
+	s := NewSpeedControl()
+	s.SetMultiplier(2)
+	if s.FastForwarding() || s.String() != "2x" {
+		t.Fatalf("fast-forwarding from the start, %q", s.String())
+	}
+
+	s.HoldFastForward(true)
+	if !s.FastForwarding() || s.String() != "fast-forward" {
+		t.Errorf("not fast-forwarding while held, %q", s.String())
+	}
+	s.HoldFastForward(false)
+	if s.FastForwarding() {
+		t.Errorf("still fast-forwarding after letting go")
+	}
+
+	// holding and letting go doesn't turn off a toggled fast-forward
+	s.ToggleFastForward()
+	s.HoldFastForward(true)
+	s.HoldFastForward(false)
+	if !s.FastForwarding() {
+		t.Errorf("letting go turned off the toggled fast-forward")
+	}
+	s.ToggleFastForward()
+	if s.FastForwarding() || s.String() != "2x" {
+		t.Errorf("still fast-forwarding after toggling off, %q", s.String())
+	}
+}
+
+func TestPauseAndAdvance(t *testing.T) {
+	s := NewSpeedControl()
+	s.AdvanceFrame() // does nothing while running
+	if !s.NextFrame() || !s.NextFrame() {
+		t.Fatalf("not running frames before pausing")
+	}
+
+	s.TogglePause()
+	if !s.Paused() || s.NextFrame() || s.String() != "paused" {
+		t.Fatalf("ran a frame while paused")
+	}
+	s.AdvanceFrame()
+	s.AdvanceFrame()
+	for i := 0; i < 2; i++ {
+		if !s.NextFrame() {
+			t.Errorf("advance %d didn't run a frame", i+1)
+		}
+	}
+	if s.NextFrame() {
+		t.Errorf("ran more frames than were advanced")
+	}
+
+	// resuming drops advances that weren't run
+	s.AdvanceFrame()
+	s.TogglePause()
+	s.Pause()
+	if s.NextFrame() {
+		t.Errorf("an advance queued before resuming ran after pausing again")
+	}
+	s.Resume()
+	if s.Paused() || !s.NextFrame() {
+		t.Errorf("not running after resuming")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// STOP (0x10) is a 2-byte instruction that stops the system clock until a
+// button is pressed, to save power. What it actually does depends on the
+// joypad and on whether an interrupt is pending (IE & IF), as Pan Docs
+// documents:
+//
+//	button held  interrupt pending  STOP
+//	yes          yes                is 1 byte long and does nothing else
+//	yes          no                 is 2 bytes long and enters HALT instead
+//	no           yes                is 1 byte long, stops and resets DIV
+//	no           no                 is 2 bytes long, stops and resets DIV
+//
+// When STOP is 1 byte long, the byte after it is executed as the next
+// instruction. A button counts as held when it is on a joypad line the game
+// has selected in P1.
+//
+// In CGB mode, with no button held, STOP switches speed instead of stopping
+// if KEY1 has asked for it, resetting DIV and pausing the CPU while it does
+// (see switchSpeed). It is 1 byte long if an interrupt is pending. Pan Docs
+// says the CPU behaves unpredictably if IME is also set; here it switches
+// all the same.
+//
+// While stopped nothing is clocked: cpu.Clock stands still, so the timer,
+// the LCD, the serial port and DMA freeze where they are and no interrupts
+// are requested. An LCD left on shows a black screen on the DMG. Pressing a
+// button on a selected line starts the clock again (see SetJoypad), and the
+// CPU carries on after STOP.
+
+// stop executes STOP. PC has already been advanced past both bytes.
+func (cpu *CPU) stop() {
+	pending := cpu.Memory[0xFFFF]&cpu.Memory[0xFF0F]&0x1F != 0
+	if pending {
+		cpu.PC--
+	}
+	if cpu.joypadLines() != 0x0F {
+		if !pending {
+			cpu.Halted = true
+		}
+		return
+	}
+	if cpu.speedSwitchArmed() {
+		cpu.switchSpeed()
+		return
+	}
+	cpu.writeTimer(0xFF04, 0)
+	cpu.Stopped = true
+}
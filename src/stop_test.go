@@ -0,0 +1,94 @@
+package main
+
+import "testing"
+
+func TestStop(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		held     bool
+		pending  bool
+		pc       uint16
+		halted   bool
+		stopped  bool
+		divReset bool
+	}{
+		{"button held, interrupt pending", true, true, 0xC001, false, false, false},
+		{"button held", true, false, 0xC002, true, false, false},
+		{"interrupt pending", false, true, 0xC001, false, true, true},
+		{"nothing", false, false, 0xC002, false, true, true},
+	} {
+		cpu := InitCPU()
+		copy(cpu.Memory[0xC000:], []uint8{0x10, 0x00})
+		cpu.PC = 0xC000
+		cpu.resetTimer(0x1234)
+		cpu.Memory[0xFF00] = 0x10 // buttons selected
+		if test.held {
+			cpu.Joypad = ButtonA
+		}
+		if test.pending {
+			cpu.Memory[0xFFFF], cpu.Memory[0xFF0F] = 0x04, 0x04
+		}
+		cpu.ParseNextOpcode()
+
+		if cpu.PC != test.pc || cpu.Halted != test.halted || cpu.Stopped != test.stopped {
+			t.Errorf("%s: PC %04X halted %t stopped %t, expected PC %04X halted %t stopped %t",
+				test.name, cpu.PC, cpu.Halted, cpu.Stopped, test.pc, test.halted, test.stopped)
+		}
+		if divReset := cpu.divCounter() < 0x1234; divReset != test.divReset {
+			t.Errorf("%s: counter %04X after STOP", test.name, cpu.divCounter())
+		}
+	}
+}
+
+// While stopped the clock stands still, so nothing else moves either, until
+// a button on a selected line is pressed.
+func TestStoppedUntilButton(t *testing.T) {
+	cpu := runCode(t, func(cpu *CPU) {
+		cpu.Memory[0xFF00] = 0x20 // d-pad selected
+		cpu.WriteMemory(0xFF07, 0x05)
+	}, 0x10, 0x00) // STOP
+	if !cpu.Stopped {
+		t.Fatalf("not stopped")
+	}
+	clock, ly, frames, tima := cpu.Clock, cpu.Memory[0xFF44], cpu.Frames, cpu.Memory[0xFF05]
+
+	for i := 0; i < 3; i++ {
+		if err := cpu.RunFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cpu.Step() != 0 || cpu.Clock != clock || cpu.Memory[0xFF44] != ly || cpu.Frames != frames {
+		t.Errorf("time passed while stopped: clock %d -> %d, LY %d -> %d, frames %d -> %d",
+			clock, cpu.Clock, ly, cpu.Memory[0xFF44], frames, cpu.Frames)
+	}
+	if cpu.Memory[0xFF05] != tima {
+		t.Errorf("TIMA counted from %d to %d while stopped", tima, cpu.Memory[0xFF05])
+	}
+
+	cpu.SetJoypad(ButtonStart) // not selected
+	if !cpu.Stopped {
+		t.Errorf("woken by a button that isn't selected")
+	}
+	cpu.SetJoypad(ButtonStart | ButtonDown)
+	if cpu.Stopped {
+		t.Fatalf("still stopped after pressing down")
+	}
+	if cpu.Memory[0xFF0F]&0x10 == 0 {
+		t.Errorf("joypad interrupt not requested")
+	}
+	if cpu.RunFrame(); cpu.Frames != frames+1 || cpu.FrameCycles() >= CyclesPerFrame {
+		t.Errorf("frame not finished after waking up")
+	}
+}
+
+func TestStoppedScreen(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF40] = 0x91
+	cpu.Stopped = true
+	img := cpu.FrameImage()
+	for _, pos := range []int{0, len(img.Pix) / 2, len(img.Pix) - 4} {
+		if r, g, b := img.Pix[pos], img.Pix[pos+1], img.Pix[pos+2]; r|g|b != 0 {
+			t.Errorf("pixel %d is %02X%02X%02X, expected black", pos/4, r, g, b)
+		}
+	}
+}
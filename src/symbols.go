@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Symbol is a label from a symbol file. Bank is the ROM (or RAM) bank the
+// label lives in; labels outside banked memory are in bank 0.
+type Symbol struct {
+	Name    string
+	Bank    int
+	Address uint16
+}
+
+func (s Symbol) String() string {
+	return fmt.Sprintf("%02X:%04X %s", s.Bank, s.Address, s.Name)
+}
+
+// SymbolTable holds the labels of the running program, for the debugger,
+// disassembly, traces and crash reports.
+type SymbolTable struct {
+	byName    map[string]Symbol
+	byAddress map[uint32]string
+	sorted    []Symbol // by bank then address, for Nearest
+}
+
+func symbolKey(bank int, address uint16) uint32 {
+	return uint32(bank)<<16 | uint32(address)
+}
+
+// ReadSymbols parses a symbol file. Both the RGBDS format written by
+// rgblink -n and the no$gmb/BGB format are accepted: one "BANK:ADDRESS
+// label" per line in hex, with ';' starting a comment. no$gmb allows a four
+// digit bank and uses names beginning with '.' for annotations such as
+// ".code:0010" rather than labels; those are skipped.
+func ReadSymbols(r io.Reader) (*SymbolTable, error) {
+	st := &SymbolTable{byName: map[string]Symbol{}, byAddress: map[uint32]string{}}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text, _, _ := strings.Cut(scanner.Text(), ";")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"bank:address label\"", line)
+		}
+		location, name := fields[0], fields[1]
+		if strings.HasPrefix(name, ".") && strings.Contains(name, ":") {
+			continue
+		}
+
+		bankText, addressText, found := strings.Cut(location, ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"bank:address label\"", line)
+		}
+		bank, err := strconv.ParseUint(bankText, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid bank %q", line, bankText)
+		}
+		address, err := strconv.ParseUint(addressText, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", line, addressText)
+		}
+		st.Add(Symbol{Name: name, Bank: int(bank), Address: uint16(address)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading symbols: %v", err)
+	}
+	return st, nil
+}
+
+func LoadSymbols(path string) (*SymbolTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening symbols: %v", err)
+	}
+	defer file.Close()
+
+	return ReadSymbols(file)
+}
+
+// SymbolPath returns where a symbol file for a ROM would be: game.gb has
+// its symbols in game.sym.
+func SymbolPath(romPath string) string {
+	return strings.TrimSuffix(romPath, filepath.Ext(romPath)) + ".sym"
+}
+
+// Add adds a label. When several labels share an address the first one is
+// used to name it.
+func (st *SymbolTable) Add(s Symbol) {
+	st.byName[s.Name] = s
+	key := symbolKey(s.Bank, s.Address)
+	if _, ok := st.byAddress[key]; !ok {
+		st.byAddress[key] = s.Name
+	}
+	st.sorted = nil
+}
+
+func (st *SymbolTable) Len() int {
+	return len(st.byName)
+}
+
+// Lookup finds a label by name. A nil table has no labels.
+func (st *SymbolTable) Lookup(name string) (Symbol, bool) {
+	if st == nil {
+		return Symbol{}, false
+	}
+	s, ok := st.byName[name]
+	return s, ok
+}
+
+// Label returns the label at exactly this bank and address.
+func (st *SymbolTable) Label(bank int, address uint16) (string, bool) {
+	if st == nil {
+		return "", false
+	}
+	name, ok := st.byAddress[symbolKey(bank, address)]
+	return name, ok
+}
+
+// Nearest returns the closest label at or before an address in the same
+// bank and the distance from it, for describing addresses inside routines.
+func (st *SymbolTable) Nearest(bank int, address uint16) (string, int, bool) {
+	if st.sorted == nil {
+		st.sorted = make([]Symbol, 0, len(st.byAddress))
+		for key, name := range st.byAddress {
+			st.sorted = append(st.sorted, Symbol{Name: name, Bank: int(key >> 16), Address: uint16(key)})
+		}
+		sort.Slice(st.sorted, func(i, j int) bool {
+			return symbolKey(st.sorted[i].Bank, st.sorted[i].Address) < symbolKey(st.sorted[j].Bank, st.sorted[j].Address)
+		})
+	}
+	key := symbolKey(bank, address)
+	i := sort.Search(len(st.sorted), func(i int) bool {
+		return symbolKey(st.sorted[i].Bank, st.sorted[i].Address) > key
+	})
+	if i == 0 || st.sorted[i-1].Bank != bank {
+		return "", 0, false
+	}
+	s := st.sorted[i-1]
+	return s.Name, int(address - s.Address), true
+}
+
+// Label returns the label at an address as currently mapped, if symbols are
+// loaded and one is there.
+func (cpu *CPU) Label(address uint16) (string, bool) {
+	return cpu.Symbols.Label(cpu.BankAt(address), address)
+}
+
+// DescribeAddress gives a bank qualified address with the label it falls
+// in, e.g. "01:4012 (PlayerUpdate+18)", for crash reports and the debugger.
+func (cpu *CPU) DescribeAddress(address uint16) string {
+	bank := cpu.BankAt(address)
+	s := fmt.Sprintf("%02X:%04X", bank, address)
+	if cpu.Symbols == nil {
+		return s
+	}
+	name, offset, ok := cpu.Symbols.Nearest(bank, address)
+	switch {
+	case !ok:
+		return s
+	case offset == 0:
+		return s + " (" + name + ")"
+	}
+	return fmt.Sprintf("%s (%s+%d)", s, name, offset)
+}
+
+// ParseLocation parses a label or a hex address with an optional bank, as
+// accepted by the debugger: "PlayerUpdate", "01:4000" or "$C000". The bank
+// is -1 if not given. Labels take precedence over hex numbers, so a label
+// called "Add" can still be used.
+func (cpu *CPU) ParseLocation(s string) (int, uint16, error) {
+	if symbol, ok := cpu.Symbols.Lookup(s); ok {
+		bank := symbol.Bank
+		if symbol.Address < 0x4000 || symbol.Address >= 0x8000 {
+			// only switchable ROM needs the bank to tell labels apart
+			bank = -1
+		}
+		return bank, symbol.Address, nil
+	}
+	bank, address, err := parseBankAddress(s)
+	if err != nil && cpu.Symbols != nil {
+		return 0, 0, fmt.Errorf("%q is neither a label nor an address", s)
+	}
+	return bank, address, err
+}
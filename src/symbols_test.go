@@ -0,0 +1,119 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const rgbdsSymbols = `; File generated by rgblink
+00:0000 Main
+00:0002 Main.call
+00:0010 StoreA
+01:4000 PlayerUpdate
+01:4000 PlayerUpdate_Alias
+02:4000 EnemyUpdate
+00:c000 wCounter
+`
+
+const nocashSymbols = `; no$gmb format
+0000:0010 StoreA
+0001:4000 PlayerUpdate
+0000:0010 .code:0004
+`
+
+func TestReadSymbols(t *testing.T) {
+	for name, text := range map[string]string{"rgbds": rgbdsSymbols, "no$gmb": nocashSymbols} {
+		st, err := ReadSymbols(strings.NewReader(text))
+		if err != nil {
+			t.Fatalf("%s: ReadSymbols: %v", name, err)
+		}
+		if s, ok := st.Lookup("PlayerUpdate"); !ok || s.Bank != 1 || s.Address != 0x4000 {
+			t.Errorf("%s: PlayerUpdate: got %v (%t)", name, s, ok)
+		}
+		if label, ok := st.Label(0, 0x0010); !ok || label != "StoreA" {
+			t.Errorf("%s: label at 00:0010: got %q (%t)", name, label, ok)
+		}
+	}
+
+	st, _ := ReadSymbols(strings.NewReader(rgbdsSymbols))
+	if label, _ := st.Label(1, 0x4000); label != "PlayerUpdate" {
+		t.Errorf("expected the first label at an address to name it, got %q", label)
+	}
+	if label, _ := st.Label(2, 0x4000); label != "EnemyUpdate" {
+		t.Errorf("expected labels in different banks to be kept apart, got %q", label)
+	}
+	if name, offset, ok := st.Nearest(0, 0x0013); !ok || name != "StoreA" || offset != 3 {
+		t.Errorf("nearest to 00:0013: got %s+%d (%t)", name, offset, ok)
+	}
+	if _, _, ok := st.Nearest(2, 0x3FFF); ok {
+		t.Errorf("expected no label before the first one in a bank")
+	}
+
+	if _, err := ReadSymbols(strings.NewReader("00:zz00 Bad\n")); err == nil {
+		t.Errorf("expected an error for an invalid address")
+	}
+}
+
+func TestDebuggerLabels(t *testing.T) {
+	cpu := InitCPU()
+	copy(cpu.Memory, countingProgram)
+	cpu.Symbols, _ = ReadSymbols(strings.NewReader(rgbdsSymbols))
+
+	bank, address, err := cpu.ParseLocation("PlayerUpdate")
+	if err != nil || bank != 1 || address != 0x4000 {
+		t.Errorf("PlayerUpdate: got %02X:%04X (%v)", bank, address, err)
+	}
+	if _, _, err := cpu.ParseLocation("NoSuchLabel"); err == nil {
+		t.Errorf("expected an error for an unknown label")
+	}
+	if s := cpu.DescribeAddress(0x0012); s != "00:0012 (StoreA+2)" {
+		t.Errorf("unexpected description %q", s)
+	}
+
+	var out strings.Builder
+	cpu.Debugger = NewDebugger(strings.NewReader("break StoreA if a == 2\ncontinue\ndisas Main 3\n"), &out)
+	cpu.Debugger.Break("start")
+	for i := 0; i < 100 && cpu.Debugger != nil; i++ {
+		cpu.Step()
+	}
+	for _, want := range []string{
+		"Breakpoint 1 at 0010 (StoreA) if a == 2",
+		"Break (breakpoint 1) at 00:0010 (StoreA)",
+		"00:0010  EA 00 C0  LD (wCounter), A",
+		"Main:\n00:0000  3C        INC A",
+		"Main.call:\n00:0002  CD 10 00  CALL StoreA",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestBankedRAMLabels(t *testing.T) {
+	symbols := `00:c000 wCounter
+01:d000 wPlayer
+03:d000 wEnemies
+00:8000 vTiles
+01:8000 vTilesBank1
+`
+	cpu := InitCPU()
+	cpu.Symbols, _ = ReadSymbols(strings.NewReader(symbols))
+	for address, want := range map[uint16]string{0xC000: "wCounter", 0xD000: "wPlayer", 0x8000: "vTiles"} {
+		if label, _ := cpu.Label(address); label != want {
+			t.Errorf("DMG: %04X is %q, expected %q", address, label, want)
+		}
+	}
+
+	cpu = newCGBTestCPU()
+	cpu.Symbols, _ = ReadSymbols(strings.NewReader(symbols))
+	cpu.WriteMemory(0xFF70, 0x03)
+	cpu.WriteMemory(0xFF4F, 0x01)
+	for address, want := range map[uint16]string{0xC000: "wCounter", 0xD000: "wEnemies", 0x8000: "vTilesBank1"} {
+		if label, _ := cpu.Label(address); label != want {
+			t.Errorf("CGB: %04X is %q, expected %q", address, label, want)
+		}
+	}
+	if s := cpu.DescribeAddress(0xD004); s != "03:D004 (wEnemies+4)" {
+		t.Errorf("unexpected description %q", s)
+	}
+}
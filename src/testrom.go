@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Test ROMs report their result in one of three ways:
+//
+//   - mooneye: LD B,B with B, C, D, E, H, L set to the Fibonacci numbers
+//     3, 5, 8, 13, 21, 34 on a pass, or all 0x42 on a failure
+//   - Blargg, serial: the results are printed over the link port and end
+//     in "Passed" or "Failed"
+//   - Blargg, memory: A001-A003 hold DE B0 61, A000 holds 0x80 while the
+//     test runs and the result code after, 0 for a pass, and the text
+//     printed so far starts at A004
+//
+// RunTestROM runs one headless until it gives a result or the cycle
+// budget runs out.
+
+type TestROMStatus int
+
+const (
+	TestROMRunning TestROMStatus = iota
+	TestROMPassed
+	TestROMFailed
+	TestROMTimeout
+	TestROMError // the ROM could not be run at all
+)
+
+func (s TestROMStatus) String() string {
+	switch s {
+	case TestROMPassed:
+		return "pass"
+	case TestROMFailed:
+		return "fail"
+	case TestROMTimeout:
+		return "timeout"
+	case TestROMError:
+		return "error"
+	}
+	return "running"
+}
+
+type TestROMResult struct {
+	Status TestROMStatus
+	Detail string // how the result was reported, or why it failed
+	Cycles uint64 // T-cycles run
+	Serial string // everything sent over the link port
+}
+
+// RunTestROM loads a ROM without a boot ROM and runs it for at most budget
+// T-cycles.
+func RunTestROM(romPath string, budget uint64) TestROMResult {
+	cpu := InitCPU()
+	if err := LoadROM(cpu, romPath); err != nil {
+		return TestROMResult{Status: TestROMError, Detail: err.Error()}
+	}
+	cpu.SetModel(ModelAuto)
+	cpu.ResetPostBoot()
+
+	var serial bytes.Buffer
+	cpu.Serial = &serial
+
+	var result TestROMResult
+	seen := 0 // serial bytes already looked at
+	started := false
+	for result.Cycles < budget {
+		for cpu.FrameCycles() < CyclesPerFrame {
+			result.Cycles += uint64(cpu.Step())
+
+			if cpu.Lockup != nil {
+				result.Status, result.Detail = TestROMFailed, cpu.Lockup.Error()
+				result.Serial = serial.String()
+				return result
+			}
+			if cpu.Stopped {
+				// nothing presses a button to wake it up
+				result.Status, result.Detail = TestROMTimeout, "stopped, waiting for a button"
+				result.Serial = serial.String()
+				return result
+			}
+
+			status, detail := cpu.mooneyeStatus()
+			if status == TestROMRunning {
+				status, detail = cpu.memoryStatus(&started)
+			}
+			if status == TestROMRunning && serial.Len() != seen {
+				seen = serial.Len()
+				status, detail = serialStatus(serial.Bytes())
+			}
+			if status != TestROMRunning {
+				result.Status, result.Detail = status, detail
+				result.Serial = serial.String()
+				return result
+			}
+		}
+		cpu.endFrame()
+	}
+	result.Status = TestROMTimeout
+	result.Detail = fmt.Sprintf("no result after %d cycles", result.Cycles)
+	result.Serial = serial.String()
+	return result
+}
+
+// ResetPostBoot puts the CPU and I/O registers in the state the model's
+// boot ROM leaves them in, with the cartridge mapped at 0x0000, ready to
+// start at 0x0100. The CPU registers are the model's own, and SetModel has
+// already reset the CGB registers in CGB mode. DIV, TAC, IF, LCDC, STAT and
+// BGP are the DMG's values on every model; the boot ROMs of the others
+// take a different time to run and leave DIV and STAT elsewhere, which
+// isn't modelled.
+func (cpu *CPU) ResetPostBoot() {
+	copy(cpu.Memory[0x0000:0x0150], cpu.ROM[0x0000:0x0150])
+	af, bc, de, hl := cpu.postBootRegisters()
+	cpu.Registers[RegA] = uint8(af >> 8)
+	cpu.Flags.SetValue(uint8(af))
+	cpu.Registers[RegB], cpu.Registers[RegC] = uint8(bc>>8), uint8(bc)
+	cpu.Registers[RegD], cpu.Registers[RegE] = uint8(de>>8), uint8(de)
+	cpu.Registers[RegH], cpu.Registers[RegL] = uint8(hl>>8), uint8(hl)
+	cpu.SP = 0xFFFE
+	cpu.PC = 0x0100
+
+	cpu.Memory[0xFF07] = 0xF8
+	cpu.resetTimer(0xABCC)
+	cpu.Memory[0xFF0F] = 0xE1
+	cpu.Memory[0xFF40] = 0x91
+	cpu.Memory[0xFF41] = 0x85
+	cpu.Memory[0xFF47] = 0xFC
+	cpu.Memory[0xFF50] = 0x01
+	cpu.FrameStart = cpu.Clock
+	cpu.Events.Schedule(EventLCD, cpu.Clock)
+}
+
+// mooneyeStatus checks the registers at an LD B,B.
+func (cpu *CPU) mooneyeStatus() (TestROMStatus, string) {
+	if cpu.Memory[cpu.PC] != opcodeLDBB || cpu.Halted {
+		return TestROMRunning, ""
+	}
+	r := cpu.Registers
+	switch {
+	case r[RegB] == 3 && r[RegC] == 5 && r[RegD] == 8 && r[RegE] == 13 && r[RegH] == 21 && r[RegL] == 34:
+		return TestROMPassed, "mooneye registers"
+	case r[RegB] == 0x42 && r[RegC] == 0x42 && r[RegD] == 0x42 && r[RegE] == 0x42 && r[RegH] == 0x42 && r[RegL] == 0x42:
+		return TestROMFailed, "mooneye registers"
+	}
+	return TestROMRunning, ""
+}
+
+// memoryStatus checks the Blargg signature at 0xA000. started is set once
+// the test has written 0x80 to say it is running; until then whatever is
+// left in A000 isn't a result.
+func (cpu *CPU) memoryStatus(started *bool) (TestROMStatus, string) {
+	if cpu.Memory[0xA001] != 0xDE || cpu.Memory[0xA002] != 0xB0 || cpu.Memory[0xA003] != 0x61 {
+		return TestROMRunning, ""
+	}
+	switch code := cpu.Memory[0xA000]; {
+	case code == 0x80:
+		*started = true
+	case !*started:
+	case code == 0x00:
+		return TestROMPassed, "memory signature"
+	default:
+		text := cpu.Memory[0xA004:0xC000]
+		if end := bytes.IndexByte(text, 0); end >= 0 {
+			text = text[:end]
+		}
+		return TestROMFailed, fmt.Sprintf("memory signature, code %d: %s", code, lastLine(string(text)))
+	}
+	return TestROMRunning, ""
+}
+
+// serialStatus looks for a Blargg result in the serial output.
+func serialStatus(output []byte) (TestROMStatus, string) {
+	switch {
+	case bytes.Contains(output, []byte("Passed")):
+		return TestROMPassed, "serial"
+	case bytes.Contains(output, []byte("Failed")):
+		// wait for the line to finish, it says which test failed
+		if !bytes.HasSuffix(output, []byte("\n")) {
+			return TestROMRunning, ""
+		}
+		return TestROMFailed, "serial: " + lastLine(string(output))
+	}
+	return TestROMRunning, ""
+}
+
+// lastLine returns the last non-empty line of text.
+func lastLine(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"text/tabwriter"
+)
+
+var testROMSeconds = flag.Float64("testrom-seconds", 120, "emulated seconds each test ROM in testdata may run for")
+
+// writeTestROM puts a program at 0x0100 of an otherwise empty ROM, and a
+// serial interrupt handler at 0x0058 if there is one.
+func writeTestROM(t *testing.T, program, serialHandler []byte) string {
+	rom := make([]byte, 0x8000)
+	copy(rom[0x0100:], program)
+	copy(rom[0x0058:], serialHandler)
+	path := filepath.Join(t.TempDir(), "test.gb")
+	if err := os.WriteFile(path, rom, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// serialProgram sends a string over the link port, then loops.
+func serialProgram(message string) []byte {
+	program := []byte{
+		0x21, 0x0F, 0x01, // 0100 LD HL, 0x010F
+		0x2A,       // 0103 LD A, (HL+)
+		0xB7,       // 0104 OR A
+		0x28, 0xFE, // 0105 JR Z, -2
+		0xE0, 0x01, // 0107 LDH (SB), A
+		0x3E, 0x81, // 0109 LD A, 0x81
+		0xE0, 0x02, // 010B LDH (SC), A
+		0x18, 0xF4, // 010D JR -12, to 0x0103
+	}
+	return append(append(program, message...), 0)
+}
+
+// serialInterruptProgram sends a string over the link port a byte at a
+// time from serialInterruptHandler, waiting in between.
+func serialInterruptProgram(message string) []byte {
+	program := []byte{
+		0x21, 0x14, 0x01, // 0100 LD HL, 0x0114
+		0x3E, 0x08, // 0103 LD A, 0x08
+		0xE0, 0xFF, // 0105 LDH (IE), A
+		0xFB,       // 0107 EI
+		0x2A,       // 0108 LD A, (HL+)
+		0xB7,       // 0109 OR A
+		0x28, 0xFE, // 010A JR Z, -2
+		0xE0, 0x01, // 010C LDH (SB), A
+		0x3E, 0x81, // 010E LD A, 0x81
+		0xE0, 0x02, // 0110 LDH (SC), A
+		0x18, 0xFE, // 0112 JR -2, until the transfer is done
+	}
+	return append(append(program, message...), 0)
+}
+
+var serialInterruptHandler = []byte{
+	0xFB,             // 0058 EI
+	0xC3, 0x08, 0x01, // 0059 JP 0x0108, the next byte
+}
+
+func TestTestROMSignatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		program []byte
+		handler []byte
+		status  TestROMStatus
+		detail  string
+	}{
+		{"mooneye pass", []byte{
+			0x06, 3, 0x0E, 5, 0x16, 8, 0x1E, 13, 0x26, 21, 0x2E, 34, // LD B..L
+			0x40, 0x18, 0xFE, // LD B, B; JR -2
+		}, nil, TestROMPassed, "mooneye registers"},
+		{"mooneye fail", []byte{
+			0x06, 0x42, 0x48, 0x50, 0x58, 0x60, 0x68, // LD B, 0x42; LD C..L, B
+			0x40, 0x18, 0xFE,
+		}, nil, TestROMFailed, "mooneye registers"},
+		{"serial pass", serialProgram("instr_timing\n\n\nPassed\n"), nil, TestROMPassed, "serial"},
+		{"serial fail", serialProgram("instr_timing\n\n\nFailed #2\n"), nil, TestROMFailed, "serial: Failed #2"},
+		{"serial interrupt", serialInterruptProgram("Passed\n"), serialInterruptHandler, TestROMPassed, "serial"},
+		{"memory fail", []byte{
+			0x3E, 0x80, 0xEA, 0x00, 0xA0, // LD (0xA000), 0x80
+			0x21, 0x01, 0xA0, // LD HL, 0xA001
+			0x36, 0xDE, 0x23, 0x36, 0xB0, 0x23, 0x36, 0x61, 0x23, // signature
+			0x36, 'o', 0x23, 0x36, 'k', 0x23, 0x36, '\n', 0x23, 0x36, 'b', 0x23, 0x36, 'a', 0x23, 0x36, 'd', // text
+			0x3E, 0x05, 0xEA, 0x00, 0xA0, // LD (0xA000), 5
+			0x18, 0xFE,
+		}, nil, TestROMFailed, "memory signature, code 5: bad"},
+		{"timeout", []byte{0x18, 0xFE}, nil, TestROMTimeout, "no result after 70224 cycles"},
+	}
+	for _, test := range tests {
+		result := RunTestROM(writeTestROM(t, test.program, test.handler), CyclesPerFrame)
+		if result.Status != test.status || result.Detail != test.detail {
+			t.Errorf("%s: expected %s (%s), got %s (%s)", test.name, test.status, test.detail, result.Status, result.Detail)
+		}
+	}
+
+	if result := RunTestROM(filepath.Join(t.TempDir(), "missing.gb"), CyclesPerFrame); result.Status != TestROMError {
+		t.Errorf("expected an error for a missing ROM, got %s", result.Status)
+	}
+}
+
+// TestROMs runs every ROM under testdata and logs a table of the results
+// (go test -run TestROMs -v). ROMs listed in testdata/known-failures.txt
+// may fail without failing the test.
+func TestROMs(t *testing.T) {
+	var roms []string
+	filepath.WalkDir("testdata", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && (strings.HasSuffix(path, ".gb") || strings.HasSuffix(path, ".gbc")) {
+			roms = append(roms, path)
+		}
+		return nil
+	})
+	if len(roms) == 0 {
+		t.Skip("no test ROMs in testdata")
+	}
+	sort.Strings(roms)
+	known := readKnownFailures(t, filepath.Join("testdata", "known-failures.txt"))
+
+	var table strings.Builder
+	w := tabwriter.NewWriter(&table, 0, 8, 2, ' ', 0)
+	passed := 0
+	for _, path := range roms {
+		name := filepath.ToSlash(strings.TrimPrefix(path, "testdata"+string(filepath.Separator)))
+		budget := uint64(*testROMSeconds * CPUFrequency)
+		t.Run(name, func(t *testing.T) {
+			result := RunTestROM(path, budget)
+			status := result.Status.String()
+			switch {
+			case result.Status == TestROMPassed:
+				passed++
+				if known[name] {
+					t.Logf("passes but is listed as a known failure")
+				}
+			case known[name]:
+				status += " (known)"
+			default:
+				t.Errorf("%s: %s", result.Status, result.Detail)
+				if result.Serial != "" {
+					t.Logf("serial output:\n%s", result.Serial)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.1fs\t%s\n", name, status, float64(result.Cycles)/CPUFrequency, result.Detail)
+		})
+	}
+	w.Flush()
+	t.Logf("%d of %d test ROMs passed\n%s", passed, len(roms), table.String())
+}
+
+// readKnownFailures reads a list of ROM paths relative to testdata, one per
+// line, with # comments.
+func readKnownFailures(t *testing.T, path string) map[string]bool {
+	known := map[string]bool{}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return known
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			known[line] = true
+		}
+	}
+	return known
+}
@@ -0,0 +1,88 @@
+package main
+
+// The timer is driven by a 16-bit counter that goes up every T-cycle; DIV
+// (0xFF04) is its high byte. TIMA (0xFF05) is incremented whenever the
+// counter bit TAC (0xFF07) selects, ANDed with the TAC enable bit, goes from
+// 1 to 0. That includes the counter being reset by a write to DIV and TAC
+// changes, which is why those writes can tick TIMA. When TIMA overflows it
+// reads 0 for one M-cycle, then is loaded from TMA (0xFF06) and the timer
+// interrupt is requested.
+//
+// The counter isn't stored: it is the time since cpu.DivStart, and DIV is
+// worked out when it is read. Each falling edge of the timer's input is an
+// EventTimer.
+
+// timerBits are the counter bits TAC selects: 4096, 262144, 65536 and
+// 16384 Hz.
+var timerBits = [4]uint16{1 << 9, 1 << 3, 1 << 5, 1 << 7}
+
+// divCounter returns the timer's internal counter, which counts CPU
+// T-cycles.
+func (cpu *CPU) divCounter() uint16 {
+	elapsed := cpu.Clock - cpu.DivStart
+	if cpu.DoubleSpeed {
+		elapsed *= 2
+	}
+	return uint16(elapsed)
+}
+
+// timerInput is the signal whose falling edge increments TIMA.
+func (cpu *CPU) timerInput() bool {
+	tac := cpu.Memory[0xFF07]
+	return tac&0x04 != 0 && cpu.divCounter()&timerBits[tac&0x03] != 0
+}
+
+// scheduleTimer schedules the next falling edge of the timer's input, when
+// the selected counter bit and every bit below it next roll over to 0.
+func (cpu *CPU) scheduleTimer() {
+	tac := cpu.Memory[0xFF07]
+	if tac&0x04 == 0 {
+		cpu.Events.Cancel(EventTimer)
+		return
+	}
+	period := uint64(timerBits[tac&0x03]) * 2
+	cpu.Events.Schedule(EventTimer, cpu.Clock+cpu.cpuCycles(period-uint64(cpu.divCounter())%period))
+}
+
+func (cpu *CPU) timerEvent(at uint64) {
+	cpu.incrementTIMA(at)
+	cpu.Events.Schedule(EventTimer, at+cpu.cpuCycles(uint64(timerBits[cpu.Memory[0xFF07]&0x03])*2))
+}
+
+func (cpu *CPU) timerReloadEvent() {
+	cpu.Memory[0xFF05] = cpu.Memory[0xFF06]
+	cpu.RequestTimerInterrupt()
+}
+
+func (cpu *CPU) incrementTIMA(at uint64) {
+	cpu.Memory[0xFF05]++
+	if cpu.Memory[0xFF05] == 0 {
+		cpu.Events.Schedule(EventTimerReload, at+cpu.cpuCycles(4))
+	}
+}
+
+// resetTimer sets the counter, e.g. to the value the boot ROM leaves it at.
+func (cpu *CPU) resetTimer(counter uint16) {
+	cpu.DivStart = cpu.Clock - cpu.cpuCycles(uint64(counter))
+	cpu.scheduleTimer()
+}
+
+// writeTimer stores a write to DIV, TIMA or TAC.
+func (cpu *CPU) writeTimer(address uint16, value uint8) {
+	before := cpu.timerInput()
+	switch address {
+	case 0xFF04:
+		// any write resets the whole counter
+		cpu.DivStart = cpu.Clock
+	case 0xFF05:
+		// a write in the cycle after an overflow cancels the reload
+		cpu.Events.Cancel(EventTimerReload)
+		cpu.Memory[0xFF05] = value
+	case 0xFF07:
+		cpu.Memory[0xFF07] = value | 0xF8
+	}
+	if before && !cpu.timerInput() {
+		cpu.incrementTIMA(cpu.Clock)
+	}
+	cpu.scheduleTimer()
+}
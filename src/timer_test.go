@@ -0,0 +1,200 @@
+package main
+
+import "testing"
+
+// runCode runs the instructions in code from 0xC000 and returns the CPU.
+func runCode(t *testing.T, setup func(cpu *CPU), code ...uint8) *CPU {
+	t.Helper()
+	cpu := InitCPU()
+	copy(cpu.Memory[0xC000:], code)
+	cpu.PC = 0xC000
+	if setup != nil {
+		setup(cpu)
+	}
+	for cpu.PC < 0xC000+uint16(len(code)) {
+		cpu.ParseNextOpcode()
+	}
+	return cpu
+}
+
+func TestTimerCounts(t *testing.T) {
+	cpu := InitCPU()
+	cpu.WriteMemory(0xFF07, 0x05) // enabled, 262144 Hz
+	start := cpu.Clock
+	for cpu.Clock-start < 16*100 {
+		cpu.tick()
+	}
+	if tima := cpu.Memory[0xFF05]; tima != 100 {
+		t.Errorf("TIMA is %d after 100 periods, expected 100", tima)
+	}
+	if div := cpu.ReadMemory(0xFF04); div != uint8(cpu.divCounter()>>8) {
+		t.Errorf("DIV is %02X, counter is %04X", div, cpu.divCounter())
+	}
+}
+
+func TestTimerOverflow(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF06] = 0xAB
+	cpu.WriteMemory(0xFF07, 0x05)
+	cpu.Memory[0xFF05] = 0xFF
+	for cpu.Memory[0xFF05] == 0xFF {
+		cpu.tick()
+	}
+	if tima := cpu.Memory[0xFF05]; tima != 0 {
+		t.Fatalf("TIMA is %02X straight after overflowing, expected 00", tima)
+	}
+	if cpu.Memory[0xFF0F]&0x04 != 0 {
+		t.Errorf("timer interrupt requested before TIMA was reloaded")
+	}
+	cpu.tick()
+	if tima := cpu.Memory[0xFF05]; tima != 0xAB {
+		t.Errorf("TIMA is %02X an M-cycle after overflowing, expected TMA", tima)
+	}
+	if cpu.Memory[0xFF0F]&0x04 == 0 {
+		t.Errorf("timer interrupt not requested")
+	}
+}
+
+func TestTimerInterrupt(t *testing.T) {
+	cpu := InitCPU()
+	copy(cpu.Memory[0xC000:], []uint8{0x18, 0xFE}) // JR -2
+	copy(cpu.Memory[0x0050:], []uint8{0x18, 0xFE}) // the handler loops too
+	cpu.PC = 0xC000
+	cpu.IME = 1
+	cpu.Memory[0xFFFF] = 0x04
+	cpu.WriteMemory(0xFF07, 0x05)
+	cpu.Memory[0xFF05] = 0xFE
+	for i := 0; i < 100 && cpu.PC != 0x0050; i++ {
+		cpu.Step()
+	}
+	if cpu.PC != 0x0050 {
+		t.Fatalf("PC is %04X, expected the timer handler at 0050", cpu.PC)
+	}
+	if cpu.IME != 0 || cpu.Memory[0xFF0F]&0x04 != 0 {
+		t.Errorf("IME %d, IF %02X after dispatching", cpu.IME, cpu.Memory[0xFF0F])
+	}
+	if returnAddress := uint16(cpu.Memory[cpu.SP]) | uint16(cpu.Memory[cpu.SP+1])<<8; returnAddress != 0xC000 {
+		t.Errorf("pushed %04X, expected C000", returnAddress)
+	}
+}
+
+// With several interrupts pending the lowest bit goes first, and none go
+// while IME is clear, although they still end HALT.
+func TestInterruptDispatch(t *testing.T) {
+	cpu := InitCPU()
+	cpu.PC = 0xC000
+	cpu.Memory[0xFFFF] = 0x1F
+	cpu.Memory[0xFF0F] = 0x1C
+	cpu.Halted = true
+	cpu.HandleInterrupts()
+	if cpu.PC != 0xC000 || cpu.Halted {
+		t.Errorf("with IME clear: PC %04X, halted %t", cpu.PC, cpu.Halted)
+	}
+
+	for _, vector := range []uint16{0x0050, 0x0058, 0x0060} {
+		cpu.IME = 1
+		cpu.HandleInterrupts()
+		if cpu.PC != vector {
+			t.Errorf("dispatched to %04X, expected %04X", cpu.PC, vector)
+		}
+	}
+	if cpu.Memory[0xFF0F]&0x1F != 0 {
+		t.Errorf("IF is %02X after dispatching everything", cpu.Memory[0xFF0F])
+	}
+}
+
+// A write to TIMA in the M-cycle it overflows cancels the reload and the
+// interrupt.
+func TestTimerOverflowCancelled(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF06] = 0xAB
+	cpu.WriteMemory(0xFF07, 0x05)
+	cpu.Memory[0xFF05] = 0xFF
+	cpu.resetTimer(0x000C) // bit 3 falls on the next tick
+	cpu.WriteMemory(0xFF05, 0x12)
+	cpu.tick()
+	if tima := cpu.Memory[0xFF05]; tima != 0x12 {
+		t.Errorf("TIMA is %02X, expected the value written", tima)
+	}
+	if cpu.Memory[0xFF0F]&0x04 != 0 {
+		t.Errorf("timer interrupt requested")
+	}
+}
+
+func TestDIVWriteTicksTIMA(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF07] = 0xFD // enabled, counter bit 3
+	cpu.resetTimer(0x1234)    // bit 3 set after the next tick too
+	cpu.WriteMemory(0xFF04, 0x99)
+	if cpu.divCounter() != 0 {
+		t.Errorf("DIV write left the counter at %04X", cpu.divCounter())
+	}
+	if tima := cpu.Memory[0xFF05]; tima != 1 {
+		t.Errorf("TIMA is %d, expected the falling edge of the reset to tick it", tima)
+	}
+}
+
+// A read sees the timer as it is on the M-cycle of the read, not at the
+// start or end of the instruction.
+func TestReadWithinInstruction(t *testing.T) {
+	for _, test := range []struct {
+		counter uint16
+		div     uint8
+	}{
+		// LDH A, (DIV) reads on its third M-cycle, after three ticks
+		{0x00F0, 0x00},
+		{0x00F4, 0x01},
+	} {
+		cpu := runCode(t, func(cpu *CPU) { cpu.resetTimer(test.counter) }, 0xF0, 0x04)
+		if a := cpu.Registers[RegA]; a != test.div {
+			t.Errorf("counter %04X: read DIV %02X, expected %02X", test.counter, a, test.div)
+		}
+		if cpu.divCounter() != test.counter+12 {
+			t.Errorf("counter %04X: %04X after the instruction, expected %04X", test.counter, cpu.divCounter(), test.counter+12)
+		}
+	}
+}
+
+// Internal cycles are ticked too, and the ones that come before a memory
+// access are ticked before it. With SP at FF06 the last write of a push is
+// to DIV, which resets the counter, so it is still 0 after the instruction
+// only if nothing was ticked after the write.
+func TestInternalCycles(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		code   []uint8
+		cycles uint64
+	}{
+		{"PUSH BC", []uint8{0xC5}, 16},
+		{"RST $38", []uint8{0xFF}, 16},
+		{"CALL u16", []uint8{0xCD, 0x00, 0xD0}, 24},
+	} {
+		cpu := InitCPU()
+		copy(cpu.Memory[0xC000:], test.code)
+		cpu.PC = 0xC000
+		cpu.SP = 0xFF06
+		cpu.ParseNextOpcode()
+		if cpu.Clock != test.cycles {
+			t.Errorf("%s took %d cycles, expected %d", test.name, cpu.Clock, test.cycles)
+		}
+		if cpu.divCounter() != 0 {
+			t.Errorf("%s: counter %04X after writing DIV last, expected 0", test.name, cpu.divCounter())
+		}
+	}
+}
+
+func TestSerialTransferTakesTime(t *testing.T) {
+	cpu := InitCPU()
+	cpu.Memory[0xFF01] = 'x'
+	cpu.WriteMemory(0xFF02, 0x81)
+	for i := 0; i < SerialTransferCycles/4-1; i++ {
+		cpu.tick()
+	}
+	if cpu.Memory[0xFF02]&0x80 == 0 {
+		t.Fatalf("transfer finished early")
+	}
+	cpu.tick()
+	if cpu.Memory[0xFF02]&0x80 != 0 || cpu.Memory[0xFF01] != 0xFF || cpu.Memory[0xFF0F]&0x08 == 0 {
+		t.Errorf("transfer not finished after %d cycles: SB %02X SC %02X IF %02X", SerialTransferCycles, cpu.Memory[0xFF01], cpu.Memory[0xFF02], cpu.Memory[0xFF0F])
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// TraceContext is how many matching lines -trace-compare prints before the
+// first divergence.
+const TraceContext = 8
+
+// Tracer logs the CPU state before every instruction in the Gameboy Doctor
+// format:
+//
+//	A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+//
+// which can be diffed against the logs of other emulators. It can also
+// compare against such a log as it goes and stop at the first difference.
+//
+// With Labels set, a "Label:" line is written before instructions that
+// have one in the symbol file. Other tools won't expect these, but the
+// comparison here skips any line that isn't a trace line.
+type Tracer struct {
+	// Only instructions at Start..End (inclusive) are traced, and at most
+	// Limit of them if Limit isn't 0.
+	Start uint16
+	End   uint16
+	Limit uint64
+
+	Labels bool
+
+	// Lines is the number of instructions traced so far, and Matched how
+	// many of them agreed with the reference log.
+	Lines   uint64
+	Matched uint64
+
+	// Err is set when the trace diverges from the reference log.
+	Err error
+
+	out *bufio.Writer
+
+	ref     *bufio.Scanner
+	context []string // the last TraceContext lines, oldest first
+	done    bool     // limit reached, reference ended or diverged
+}
+
+// NewTracer traces every instruction to w, which may be nil when only
+// comparing against a reference.
+func NewTracer(w io.Writer) *Tracer {
+	t := &Tracer{End: 0xFFFF}
+	if w != nil {
+		t.out = bufio.NewWriterSize(w, 1<<16)
+	}
+	return t
+}
+
+// Compare checks each traced line against the next line of a reference log.
+func (t *Tracer) Compare(r io.Reader) {
+	t.ref = bufio.NewScanner(r)
+}
+
+// SetRange limits tracing to a PC range like "0100-7FFF".
+func (t *Tracer) SetRange(spec string) error {
+	first, last, found := strings.Cut(spec, "-")
+	start, err := parseHex16(first)
+	if err != nil {
+		return err
+	}
+	end := uint16(0xFFFF)
+	if found {
+		if end, err = parseHex16(last); err != nil {
+			return err
+		}
+	}
+	if end < start {
+		return fmt.Errorf("empty range %s", spec)
+	}
+	t.Start, t.End = start, end
+	return nil
+}
+
+// Trace is called before each instruction executes.
+func (t *Tracer) Trace(cpu *CPU) {
+	if t.done || cpu.PC < t.Start || cpu.PC > t.End {
+		return
+	}
+	line := cpu.doctorLine()
+	t.Lines++
+	if t.out != nil {
+		if label, ok := cpu.Label(cpu.PC); ok && t.Labels {
+			t.out.WriteString(label)
+			t.out.WriteString(":\n")
+		}
+		t.out.WriteString(line)
+		t.out.WriteByte('\n')
+	}
+
+	if t.ref != nil {
+		if !t.scanReference() {
+			t.done = true
+			if err := t.ref.Err(); err != nil {
+				t.Err = fmt.Errorf("error reading reference log: %v", err)
+				cpu.Exit()
+			}
+		} else if expected := strings.TrimSpace(t.ref.Text()); expected != line {
+			t.Err = t.divergence(expected, line)
+			t.done = true
+			cpu.Exit()
+		} else {
+			t.Matched++
+			if len(t.context) == TraceContext {
+				t.context = t.context[1:]
+			}
+			t.context = append(t.context, line)
+		}
+	}
+
+	if t.Limit > 0 && t.Lines >= t.Limit {
+		t.done = true
+	}
+}
+
+// scanReference moves to the next trace line in the reference log.
+func (t *Tracer) scanReference() bool {
+	for t.ref.Scan() {
+		if strings.HasPrefix(t.ref.Text(), "A:") {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracer) divergence(expected, actual string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "trace diverged from the reference at line %d\n", t.Lines)
+	for i, line := range t.context {
+		fmt.Fprintf(&b, "  %8d  %s\n", t.Lines-uint64(len(t.context)-i), line)
+	}
+	fmt.Fprintf(&b, "expected  %s\n", expected)
+	fmt.Fprintf(&b, "got       %s", actual)
+	return fmt.Errorf("%s", b.String())
+}
+
+// Close flushes the trace.
+func (t *Tracer) Close() error {
+	if t.out == nil {
+		return nil
+	}
+	return t.out.Flush()
+}
+
+func (cpu *CPU) doctorLine() string {
+	return fmt.Sprintf("A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X",
+		cpu.Registers[RegA], cpu.Flags.Value(),
+		cpu.Registers[RegB], cpu.Registers[RegC], cpu.Registers[RegD], cpu.Registers[RegE],
+		cpu.Registers[RegH], cpu.Registers[RegL], cpu.SP, cpu.PC,
+		cpu.Memory[cpu.PC], cpu.Memory[cpu.PC+1], cpu.Memory[cpu.PC+2], cpu.Memory[cpu.PC+3])
+}
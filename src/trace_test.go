@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func traceCountingProgram(t *testing.T, tracer *Tracer, steps int) {
+	cpu := InitCPU()
+	copy(cpu.Memory, countingProgram)
+	cpu.Tracer = tracer
+	for i := 0; i < steps && !cpu.Quit; i++ {
+		cpu.Step()
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestTraceFormat(t *testing.T) {
+	var buf bytes.Buffer
+	traceCountingProgram(t, NewTracer(&buf), 3)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	expected := "A:00 F:00 B:00 C:00 D:00 E:00 H:00 L:00 SP:FFFE PC:0000 PCMEM:3C,00,CD,10"
+	if lines[0] != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "A:01 ") || !strings.Contains(lines[1], "PC:0001") {
+		t.Errorf("unexpected second line %s", lines[1])
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf)
+	if err := tracer.SetRange("0010-0015"); err != nil {
+		t.Fatalf("SetRange: %v", err)
+	}
+	tracer.Limit = 3
+	traceCountingProgram(t, tracer, 100)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %d:\n%s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		if !strings.Contains(line, "PC:001") {
+			t.Errorf("line outside the range: %s", line)
+		}
+	}
+}
+
+func TestTraceCompare(t *testing.T) {
+	var reference bytes.Buffer
+	traceCountingProgram(t, NewTracer(&reference), 50)
+
+	tracer := NewTracer(nil)
+	tracer.Compare(bytes.NewReader(reference.Bytes()))
+	traceCountingProgram(t, tracer, 50)
+	if tracer.Err != nil || tracer.Matched != 50 {
+		t.Fatalf("expected 50 matching lines, got %d (%v)", tracer.Matched, tracer.Err)
+	}
+
+	lines := strings.Split(reference.String(), "\n")
+	lines[20] = "A:FF" + lines[20][4:]
+	tracer = NewTracer(nil)
+	tracer.Compare(strings.NewReader(strings.Join(lines, "\n")))
+	traceCountingProgram(t, tracer, 50)
+	if tracer.Err == nil {
+		t.Fatalf("expected a divergence")
+	}
+	if tracer.Matched != 20 {
+		t.Errorf("expected 20 matching lines, got %d", tracer.Matched)
+	}
+	msg := tracer.Err.Error()
+	if !strings.Contains(msg, "line 21") || !strings.Contains(msg, lines[19]) {
+		t.Errorf("divergence is missing context:\n%s", msg)
+	}
+}